@@ -0,0 +1,387 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownArchiveFormat = errors.New("unknown archive format")
+)
+
+// ArchiveFormat is the format of the archives written by ArchivePrefix
+type ArchiveFormat string
+
+const (
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	ArchiveZip   ArchiveFormat = "zip"
+)
+
+// archiveWriter writes the entries of an archive one at a time
+type archiveWriter interface {
+	create(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{
+		gz: gz,
+		tw: tar.NewWriter(gz),
+	}
+}
+
+func (t *tarGzWriter) create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modTime,
+	})
+	return t.tw, err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) create(name string, _ int64, modTime time.Time) (io.Writer, error) {
+	return z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// ArchivePrefix streams every object under the given prefix into an archive
+// of the given format written to w. Entries are named by their keys relative
+// to the prefix, and objects are read one at a time straight into w so only
+// a single object is in flight. If it fails, the archive written so far is
+// incomplete and should be discarded.
+func (e *S3) ArchivePrefix(ctx context.Context, prefix string, w io.Writer, format ArchiveFormat) error {
+	var archive archiveWriter
+	switch format {
+	case ArchiveTarGz:
+		archive = newTarGzWriter(w)
+	case ArchiveZip:
+		archive = &zipWriter{zw: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownArchiveFormat, format)
+	}
+	e.logOperation("ArchivePrefix", "archiving prefix", "prefix", prefix, "bucket", e.options().Bucket, "format", format)
+
+	root := e.listPrefix(prefix)
+	for object := range e.listRecursive(ctx, prefix) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		name := e.relativeKey(root, object.Key)
+		if err := e.archiveObject(ctx, archive, object.Key, name); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+	}
+	return archive.Close()
+}
+
+// archiveObject copies a single object into the archive as name
+func (e *S3) archiveObject(ctx context.Context, archive archiveWriter, objName string, name string) error {
+	ctx, op := e.startOperation(ctx, "GetObject", e.options().Bucket, objName)
+	body, info, err := e.getObject(ctx, objName, GetOptions{}, true)
+	if err != nil {
+		return op.finish(err)
+	}
+	defer body.Close()
+
+	// Transparently compressed objects are decompressed by getObject, so
+	// the entry has the original size
+	size := info.Size
+	if isCompressed(info.Metadata.Get("Content-Encoding"), info.UserMetadata) {
+		if n, ok := uncompressedSize(info.UserMetadata); ok {
+			size = n
+		}
+	}
+
+	w, err := archive.create(name, size, info.LastModified)
+	if err != nil {
+		return op.finish(err)
+	}
+	n, err := io.Copy(w, body)
+	if err == nil && n != size {
+		err = fmt.Errorf("read %d of %d bytes", n, size)
+	}
+	return op.finish(err)
+}
+
+const (
+	// extractBufferSize is the largest archive entry ExtractArchive buffers
+	// in memory to upload it concurrently, larger tar entries are uploaded
+	// straight from the stream
+	extractBufferSize = 8 << 20
+)
+
+// ExtractOptions are the options for ExtractArchiveWithOptions
+type ExtractOptions struct {
+	Concurrency int
+
+	// Progress is called after every entry has been processed, it
+	// is never called concurrently
+	Progress func(progress ExtractProgress)
+}
+
+// ExtractProgress is reported to ExtractOptions.Progress
+type ExtractProgress struct {
+	Key       string
+	Extracted int
+	Failed    int
+}
+
+// ExtractSummary is the result of ExtractArchive
+type ExtractSummary struct {
+	Extracted int
+	Bytes     int64
+	Failures  []ObjectFailure
+}
+
+// archiveEntry is a regular file read from an archive
+type archiveEntry struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+func (e *S3) ExtractArchive(ctx context.Context, prefix string, r io.Reader, format ArchiveFormat) (*ExtractSummary, error) {
+	return e.ExtractArchiveWithOptions(ctx, prefix, r, format, ExtractOptions{})
+}
+
+// ExtractArchiveWithOptions reads an archive of the given format from r and
+// writes every regular file in it as an object under the given prefix, keyed
+// by its path in the archive, from up to opts.Concurrency workers at a time.
+// Entries that cannot be written, including those whose path is not a valid
+// key, are reported in the summary. An error is only returned if the archive
+// itself cannot be read, along with the entries extracted until then. Zip
+// archives are spooled to a temporary file first, as their index is at the
+// end.
+func (e *S3) ExtractArchiveWithOptions(ctx context.Context, prefix string, r io.Reader, format ArchiveFormat, opts ExtractOptions) (*ExtractSummary, error) {
+	if format != ArchiveTarGz && format != ArchiveZip {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownArchiveFormat, format)
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	e.logOperation("ExtractArchive", "extracting archive", "prefix", prefix, "bucket", e.options().Bucket, "format", format, "concurrency", concurrency)
+
+	summary := new(ExtractSummary)
+	var mu sync.Mutex
+	extract := func(ctx context.Context, entry archiveEntry) {
+		err := e.extractEntry(ctx, prefix, entry)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			summary.Failures = append(summary.Failures, ObjectFailure{
+				Key: entry.name,
+				Err: err,
+			})
+		} else {
+			summary.Extracted++
+			summary.Bytes += entry.size
+		}
+		if opts.Progress != nil {
+			opts.Progress(ExtractProgress{
+				Key:       entry.name,
+				Extracted: summary.Extracted,
+				Failed:    len(summary.Failures),
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	entries := make(chan archiveEntry)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				extract(ctx, entry)
+			}
+		}()
+	}
+
+	var err error
+	if format == ArchiveTarGz {
+		err = readTarGz(ctx, r, entries, extract)
+	} else {
+		var cleanup func()
+		cleanup, err = readZip(ctx, r, entries)
+		defer cleanup()
+	}
+	close(entries)
+	wg.Wait()
+	if err != nil {
+		return summary, fmt.Errorf("failed to read archive: %w", err)
+	}
+	return summary, nil
+}
+
+// extractEntry writes a single archive entry as an object
+func (e *S3) extractEntry(ctx context.Context, prefix string, entry archiveEntry) error {
+	if err := ValidateKey(entry.name); err != nil {
+		return err
+	}
+	body, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(entry.name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err = e.PutObject(ctx, prefix, entry.name, body, entry.size, contentType)
+	return err
+}
+
+// entryName returns the key for a path in an archive
+func entryName(name string) string {
+	for strings.HasPrefix(name, "./") {
+		name = strings.TrimPrefix(name, "./")
+	}
+	return name
+}
+
+// readTarGz sends the regular files of a tar.gz stream to entries, buffering
+// them in memory, or extracts them directly if they are too large to buffer
+func readTarGz(ctx context.Context, r io.Reader, entries chan<- archiveEntry, extract func(ctx context.Context, entry archiveEntry)) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		entry := archiveEntry{
+			name: entryName(header.Name),
+			size: header.Size,
+		}
+		if header.Size > extractBufferSize {
+			entry.open = func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			}
+			extract(ctx, entry)
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		entry.open = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+		select {
+		case entries <- entry:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// readZip spools a zip stream to a temporary file and sends its regular
+// files to entries, which can be read concurrently until cleanup is called
+func readZip(ctx context.Context, r io.Reader, entries chan<- archiveEntry) (cleanup func(), err error) {
+	f, err := os.CreateTemp("", "s3-extract-*.zip")
+	if err != nil {
+		return func() {}, err
+	}
+	cleanup = func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return cleanup, err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return cleanup, err
+	}
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		entry := archiveEntry{
+			name: entryName(file.Name),
+			size: int64(file.UncompressedSize64),
+			open: file.Open,
+		}
+		select {
+		case entries <- entry:
+		case <-ctx.Done():
+			return cleanup, ctx.Err()
+		}
+	}
+	return cleanup, nil
+}
@@ -0,0 +1,118 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// mutations are the operations recorded by the audit log
+var mutations = map[string]struct{}{
+	"PutObject":      {},
+	"DeleteObject":   {},
+	"DeleteObjects":  {},
+	"CopyObject":     {},
+	"RestoreObject":  {},
+	"MakeBucket":     {},
+	"RemoveBucket":   {},
+	"SetBucketQuota": {},
+}
+
+// AuditEvent is a single mutation recorded by the audit log
+type AuditEvent struct {
+	Time      time.Time     `json:"time"`
+	Identity  string        `json:"identity"`
+	Operation string        `json:"operation"`
+	Bucket    string        `json:"bucket"`
+	Key       string        `json:"key,omitempty"`
+	Source    string        `json:"source,omitempty"`
+	Keys      []string      `json:"keys,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// AuditSink receives the audit log of every mutation, whether it
+// succeeded or not. Sinks must be safe for concurrent use.
+type AuditSink interface {
+	WriteAuditEvent(ctx context.Context, event AuditEvent) error
+}
+
+// JSONAuditSink writes audit events to a writer as JSON lines
+type JSONAuditSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+var _ AuditSink = (*JSONAuditSink)(nil)
+
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (s *JSONAuditSink) WriteAuditEvent(_ context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(event)
+}
+
+// auditObserver writes an AuditEvent to the sink for every finished mutation
+type auditObserver struct {
+	sink     AuditSink
+	identity string
+	logger   Logger
+}
+
+func (o *auditObserver) OperationStarted(ctx context.Context, _ *Operation) context.Context {
+	return ctx
+}
+
+func (o *auditObserver) OperationRetried(context.Context, *Operation, int, error) {}
+
+func (o *auditObserver) OperationFinished(ctx context.Context, op *Operation, err error) {
+	if _, ok := mutations[op.Name]; !ok {
+		return
+	}
+
+	event := AuditEvent{
+		Time:      op.Start.UTC(),
+		Identity:  o.identity,
+		Operation: op.Name,
+		Bucket:    op.Bucket,
+		Key:       op.Key,
+		Source:    op.Source,
+		Keys:      op.Keys,
+		Size:      op.BytesSent,
+		Duration:  time.Since(op.Start),
+		Status:    OperationStatus(err),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	// The audit log is written even if the operation was canceled
+	if err = o.sink.WriteAuditEvent(context.WithoutCancel(ctx), event); err != nil {
+		o.logger.Error("failed to write audit event", "operation", op.Name, "key", op.Key, "error", err)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestAuditSink(t *testing.T) {
+	ctx := context.Background()
+	var audit bytes.Buffer
+	client := s3test.NewServer(t, func(options *s3.Options) {
+		options.AuditSink = s3.NewJSONAuditSink(&audit)
+		options.AuditIdentity = "tester"
+	})
+	audit.Reset()
+
+	data := []byte("hello world")
+	if _, err := client.PutObject(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	if _, err := client.StatObject(ctx, "data", "a"); err != nil {
+		t.Fatalf("failed to stat object: %v", err)
+	}
+	if _, err := client.CopyObject(ctx, "data", "a", "data", "b"); err != nil {
+		t.Fatalf("failed to copy object: %v", err)
+	}
+	if err := client.DeleteObject(ctx, "data", "a"); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	// Quotas are only supported by MinIO, failed mutations are audited too
+	_ = client.SetBucketQuota(ctx, s3.BucketQuota{Size: 1 << 30})
+
+	var events []s3.AuditEvent
+	scanner := bufio.NewScanner(&audit)
+	for scanner.Scan() {
+		var event s3.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to decode audit event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	expected := []struct {
+		operation string
+		key       string
+		source    string
+	}{
+		{"PutObject", "data/a", ""},
+		{"CopyObject", "data/b", "data/a"},
+		{"DeleteObject", "data/a", ""},
+		{"SetBucketQuota", "", ""},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d audit events, got %+v", len(expected), events)
+	}
+	for i, e := range expected {
+		event := events[i]
+		if event.Operation != e.operation || event.Key != e.key || event.Source != e.source || event.Identity != "tester" {
+			t.Fatalf("expected %s of %s from %q by tester, got %+v", e.operation, e.key, e.source, event)
+		}
+	}
+}
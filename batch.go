@@ -0,0 +1,362 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrBatchAborted = errors.New("batch aborted")
+)
+
+// BatchOptions are the options for UploadBatchWithOptions and
+// DownloadBatchWithOptions
+type BatchOptions struct {
+	Concurrency int
+
+	// FailFast stops a batch at its first failure, the items that were not
+	// processed yet fail with ErrBatchAborted. Otherwise every item is
+	// processed regardless of the others.
+	FailFast bool
+
+	// Progress is called after every item has been processed, it
+	// is never called concurrently
+	Progress func(progress BatchProgress)
+}
+
+// BatchProgress is reported to BatchOptions.Progress
+type BatchProgress struct {
+	Key   string
+	Total int
+	Done  int
+
+	// Failed counts the items that failed, and is included in Done
+	Failed int
+
+	// Bytes is the number of bytes transferred by the batch so far
+	Bytes int64
+}
+
+// UploadItem is an object uploaded by UploadBatch, from Reader if it is set
+// or from the file at Path otherwise
+type UploadItem struct {
+	Prefix string
+	Key    string
+
+	Reader io.Reader
+	// Size is the size of Reader, -1 if unknown. The size of files is
+	// taken from the file.
+	Size int64
+	Path string
+
+	Options PutOptions
+}
+
+// UploadResult is the result of a single UploadItem, in the order of the
+// items
+type UploadResult struct {
+	Prefix string
+	Key    string
+	Info   minio.UploadInfo
+	Err    error
+}
+
+func (e *S3) UploadBatch(ctx context.Context, items []UploadItem, concurrency int) ([]UploadResult, error) {
+	return e.UploadBatchWithOptions(ctx, items, BatchOptions{Concurrency: concurrency})
+}
+
+// UploadBatchWithOptions uploads the items from up to opts.Concurrency
+// workers at a time and returns a result per item. With opts.FailFast it
+// returns the first failure, otherwise the results have to be checked.
+func (e *S3) UploadBatchWithOptions(ctx context.Context, items []UploadItem, opts BatchOptions) ([]UploadResult, error) {
+	e.logOperation("UploadBatch", "uploading batch", "items", len(items), "bucket", e.options().Bucket, "concurrency", opts.Concurrency)
+	results := make([]UploadResult, len(items))
+	for i, item := range items {
+		results[i] = UploadResult{
+			Prefix: item.Prefix,
+			Key:    item.Key,
+		}
+	}
+
+	err := runBatch(ctx, len(items), opts, func(i int) string {
+		return items[i].Key
+	}, func(ctx context.Context, i int) (int64, error) {
+		info, err := e.uploadItem(ctx, items[i])
+		results[i].Info = info
+		results[i].Err = err
+		return info.Size, err
+	}, func(i int, err error) {
+		results[i].Err = err
+	})
+	return results, err
+}
+
+func (e *S3) uploadItem(ctx context.Context, item UploadItem) (minio.UploadInfo, error) {
+	if item.Reader != nil {
+		return e.PutObjectWithOptions(ctx, item.Prefix, item.Key, item.Reader, item.Size, item.Options)
+	}
+
+	f, err := os.Open(item.Path)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	return e.PutObjectWithOptions(ctx, item.Prefix, item.Key, f, info.Size(), item.Options)
+}
+
+// runBatch calls do for the items 0 to n-1 from up to opts.Concurrency
+// workers at a time, reporting progress with the keys returned by key. With
+// opts.FailFast, it stops at the first failure, calls aborted for every item
+// that was not processed and returns the failure.
+func runBatch(ctx context.Context, n int, opts BatchOptions, key func(i int) string, do func(ctx context.Context, i int) (int64, error), aborted func(i int, err error)) error {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failure error
+	progress := BatchProgress{Total: n}
+	started := make([]bool, n)
+
+	indices := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				bytes, err := do(ctx, i)
+
+				mu.Lock()
+				progress.Key = key(i)
+				progress.Done++
+				progress.Bytes += bytes
+				if err != nil {
+					progress.Failed++
+					if opts.FailFast && failure == nil {
+						failure = fmt.Errorf("failed to process %s: %w", key(i), err)
+						cancel()
+					}
+				}
+				if opts.Progress != nil {
+					opts.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indices <- i:
+			started[i] = true
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	for i, ok := range started {
+		if !ok {
+			aborted(i, ErrBatchAborted)
+		}
+	}
+	if failure == nil {
+		return parent.Err()
+	}
+	return failure
+}
+
+// DownloadResult is the result of a single key of DownloadBatch, in the
+// order of the keys
+type DownloadResult struct {
+	Key  string
+	Path string
+
+	// Bytes is the number of bytes downloaded by this batch
+	Bytes int64
+
+	// Resumed is set if an earlier partial download was continued
+	Resumed bool
+
+	// Skipped is set if the file was already downloaded
+	Skipped bool
+
+	Err error
+}
+
+// DownloadBatch downloads the objects with the given keys, which are not
+// under a prefix, see DownloadBatchWithOptions
+func (e *S3) DownloadBatch(ctx context.Context, keys []string, destDir string, concurrency int) ([]DownloadResult, error) {
+	return e.DownloadBatchWithOptions(ctx, "", keys, destDir, BatchOptions{Concurrency: concurrency})
+}
+
+// DownloadBatchWithOptions downloads the objects with the given keys under
+// prefix to the same paths below destDir, from up to opts.Concurrency
+// workers at a time, and returns a result per key. Objects are downloaded
+// to a partial file first, which a later batch continues from if the
+// object has not changed, and files get the modification time of their
+// object so that downloaded files are skipped. With opts.FailFast it
+// returns the first failure, otherwise the results have to be checked.
+func (e *S3) DownloadBatchWithOptions(ctx context.Context, prefix string, keys []string, destDir string, opts BatchOptions) ([]DownloadResult, error) {
+	e.logOperation("DownloadBatch", "downloading batch", "prefix", prefix, "items", len(keys), "bucket", e.options().Bucket, "concurrency", opts.Concurrency)
+	results := make([]DownloadResult, len(keys))
+	for i, key := range keys {
+		results[i] = DownloadResult{
+			Key:  key,
+			Path: filepath.Join(destDir, filepath.FromSlash(key)),
+		}
+	}
+
+	err := runBatch(ctx, len(keys), opts, func(i int) string {
+		return keys[i]
+	}, func(ctx context.Context, i int) (int64, error) {
+		results[i].Err = e.downloadItem(ctx, prefix, &results[i])
+		return results[i].Bytes, results[i].Err
+	}, func(i int, err error) {
+		results[i].Err = err
+	})
+	return results, err
+}
+
+func (e *S3) downloadItem(ctx context.Context, prefix string, result *DownloadResult) error {
+	// Keys are used as paths, so they must not escape destDir
+	if err := ValidateKey(result.Key); err != nil {
+		return err
+	}
+	info, err := e.StatObject(ctx, prefix, result.Key)
+	if err != nil {
+		return err
+	}
+
+	// Transparently compressed objects are decompressed as they are read,
+	// so they are stored with their original size and can't be resumed
+	// with ranged reads of the stored bytes
+	size := info.Size
+	resumable := true
+	if isCompressed(info.Metadata.Get("Content-Encoding"), info.UserMetadata) {
+		if n, ok := uncompressedSize(info.UserMetadata); ok {
+			size = n
+		}
+		resumable = false
+	}
+
+	if stat, err := os.Stat(result.Path); err == nil && stat.Mode().IsRegular() && stat.Size() == size && stat.ModTime().Equal(info.LastModified) {
+		result.Skipped = true
+		return nil
+	}
+	if err = os.MkdirAll(filepath.Dir(result.Path), 0o755); err != nil {
+		return err
+	}
+
+	// Partial files are named after the ETag of the object, so they are
+	// only continued if the object has not changed
+	part := result.Path + "." + strings.Trim(info.ETag, `"`) + ".part"
+	if err = removeStaleParts(result.Path, part); err != nil {
+		return err
+	}
+	var offset int64
+	if stat, err := os.Stat(part); err == nil && resumable && stat.Size() <= size {
+		offset = stat.Size()
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+		result.Resumed = true
+	}
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if offset < size {
+		getOpts := GetOptions{
+			IfMatch: info.ETag,
+		}
+		if offset > 0 {
+			getOpts.Range = &ByteRange{Start: offset, End: -1}
+		}
+		var body io.ReadCloser
+		body, err = e.GetObjectWithOptions(ctx, prefix, result.Key, getOpts)
+		if err == nil {
+			result.Bytes, err = io.Copy(f, body)
+			_ = body.Close()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if offset+result.Bytes != size {
+		return fmt.Errorf("downloaded %d of %d bytes", offset+result.Bytes, size)
+	}
+
+	if err = os.Chtimes(part, info.LastModified, info.LastModified); err != nil {
+		return err
+	}
+	return os.Rename(part, result.Path)
+}
+
+// removeStaleParts removes the partial files of earlier downloads to path
+// of other versions of its object, except for the current one
+func removeStaleParts(path string, current string) error {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(path) + "."
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, base) || !strings.HasSuffix(name, ".part") {
+			continue
+		}
+		// ETags never contain dots, which tells the partial files of path
+		// apart from those of other keys that start with its name
+		etag := strings.TrimSuffix(strings.TrimPrefix(name, base), ".part")
+		stale := filepath.Join(filepath.Dir(path), name)
+		if etag == "" || strings.Contains(etag, ".") || stale == current {
+			continue
+		}
+		if err = os.Remove(stale); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+)
+
+// Bucket returns a client for another bucket that shares the connections,
+// credentials, cache, observers and options of e. Background tasks such as
+// the reaper and health monitor only run for the original client, so the
+// returned client always reports itself as online.
+func (e *S3) Bucket(bucket string) *S3 {
+	return e.derive(func(options *Options) {
+		options.Bucket = bucket
+	})
+}
+
+// Scoped returns a client whose operations are confined to objects under
+// prefix, which is added to the namespace of e. Keys passed to and returned
+// by the scoped client are relative to the prefix, and it shares the state
+// of e in the same way as Bucket.
+func (e *S3) Scoped(prefix string) *S3 {
+	return e.derive(func(options *Options) {
+		options.Namespace = e.join(e.options().Namespace, prefix)
+	})
+}
+
+// derive returns a client sharing the state of e with modified options
+func (e *S3) derive(modify func(options *Options)) *S3 {
+	options := *e.options()
+	options.ReaperInterval = 0
+	options.HealthCheckInterval = 0
+	options.Replicas = nil
+	modify(&options)
+
+	ctx, cancel := context.WithCancel(e.ctx)
+	derived := &S3{
+		logger:      e.logger,
+		conn:        e.conn,
+		cache:       e.cache,
+		generations: e.generations,
+		encryption:  e.encryption,
+		makeOpts:    e.makeOpts,
+		removeOpts:  e.removeOpts,
+		observers:   e.observers,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	derived.current.Store(&options)
+	return derived
+}
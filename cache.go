@@ -0,0 +1,299 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrCacheEntryTooLarge = errors.New("object is too large to be cached")
+	ErrCacheConflict      = errors.New("only one of the custom, disk and memory caches can be enabled")
+
+	errCacheFillAborted = errors.New("cache fill aborted before the object was fully read")
+	errCacheFillStale   = errors.New("cache fill aborted because the object was changed")
+)
+
+// CacheEntry describes a cached object
+type CacheEntry struct {
+	ETag        string
+	ContentType string
+	Size        int64
+
+	// Expires is the time until which the entry can be served without
+	// revalidating it against the endpoint, a zero value means always revalidate
+	Expires time.Time
+}
+
+// Cache is consulted by GetObject before reading from the endpoint. Keys are
+// the bucket and full object name joined by a slash, and cached objects are served directly until their
+// CacheEntry.Expires has passed, after which they are revalidated by ETag.
+//
+// DiskCache and MemoryCache are the built-in implementations, and shared
+// caches like Redis or groupcache can be plugged in with Options.Cache.
+type Cache interface {
+	// Get returns the cached body and entry for a key, if the key is cached
+	Get(ctx context.Context, key string) (io.ReadCloser, CacheEntry, bool)
+
+	// Set stores the body for a key. Bodies are streamed while the caller is
+	// reading the object, so Set must not store the entry if body returns an
+	// error, and should return ErrCacheEntryTooLarge early for entries it won't keep.
+	Set(ctx context.Context, key string, entry CacheEntry, body io.Reader) error
+
+	// Invalidate drops a key after the object was changed through this client
+	Invalidate(ctx context.Context, key string)
+}
+
+var (
+	_ Cache = (*DiskCache)(nil)
+	_ Cache = (*MemoryCache)(nil)
+)
+
+// newCache returns the cache configured in options, if any
+func newCache(options *Options) (Cache, error) {
+	enabled := 0
+	for _, ok := range []bool{options.Cache != nil, options.DiskCacheDir != "", options.MemoryCacheMaxBytes > 0} {
+		if ok {
+			enabled++
+		}
+	}
+
+	switch {
+	case enabled > 1:
+		return nil, ErrCacheConflict
+	case options.Cache != nil:
+		return options.Cache, nil
+	case options.DiskCacheDir != "":
+		return NewDiskCache(options.DiskCacheDir, options.DiskCacheMaxBytes)
+	case options.MemoryCacheMaxBytes > 0:
+		return NewMemoryCache(options.MemoryCacheMaxBytes, options.MemoryCacheTTL), nil
+	default:
+		return nil, nil
+	}
+}
+
+// cacheGenerations tracks the keys that are being filled, so that a fill
+// that started before the object was changed doesn't store the old body
+// after it was invalidated
+type cacheGenerations struct {
+	mu   sync.Mutex
+	keys map[string]*cacheGeneration
+}
+
+// cacheGeneration counts the invalidations of a key and its running fills,
+// it only exists while the key is being filled
+type cacheGeneration struct {
+	generation uint64
+	fills      int
+}
+
+func newCacheGenerations() *cacheGenerations {
+	return &cacheGenerations{
+		keys: make(map[string]*cacheGeneration),
+	}
+}
+
+// start registers a fill of key and returns the current generation
+func (g *cacheGenerations) start(key string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gen, ok := g.keys[key]
+	if !ok {
+		gen = new(cacheGeneration)
+		g.keys[key] = gen
+	}
+	gen.fills++
+	return gen.generation
+}
+
+// current returns whether key was not invalidated since the generation
+func (g *cacheGenerations) current(key string, generation uint64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gen, ok := g.keys[key]
+	return ok && gen.generation == generation
+}
+
+// finish unregisters a fill of key
+func (g *cacheGenerations) finish(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if gen, ok := g.keys[key]; ok {
+		gen.fills--
+		if gen.fills == 0 {
+			delete(g.keys, key)
+		}
+	}
+}
+
+// invalidate makes the running fills of key stale
+func (g *cacheGenerations) invalidate(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if gen, ok := g.keys[key]; ok {
+		gen.generation++
+	}
+}
+
+// invalidate drops an object from the cache after it was changed through this client
+func (e *S3) invalidate(ctx context.Context, objName string) {
+	if e.cache != nil {
+		key := e.cacheKey(objName)
+		e.generations.invalidate(key)
+		e.cache.Invalidate(ctx, key)
+	}
+}
+
+// cacheKey returns the cache key of an object, which includes the bucket
+// because the cache is shared with the clients returned by Bucket
+func (e *S3) cacheKey(objName string) string {
+	return e.options().Bucket + "/" + objName
+}
+
+// cacheable returns whether a read with the given options can be served
+// from or stored in the object cache
+func cacheable(opts GetOptions) bool {
+	return opts.IfMatch == "" && opts.IfNoneMatch == "" && opts.IfModifiedSince.IsZero() && !opts.VerifyChecksum && opts.Encryption == nil && opts.Range == nil && opts.VersionID == "" && !opts.NoCache
+}
+
+// getCached serves a read through the object cache, revalidating stale
+// entries with a conditional GET and filling the cache as misses are read
+func (e *S3) getCached(ctx context.Context, objName string) (io.ReadCloser, error) {
+	body, entry, ok := e.cache.Get(ctx, e.cacheKey(objName))
+	if ok {
+		if time.Now().Before(entry.Expires) {
+			return body, nil
+		}
+
+		obj, info, err := e.getObject(ctx, objName, GetOptions{IfNoneMatch: entry.ETag}, true)
+		if errors.Is(err, ErrNotModified) {
+			e.logger.Debug("serving object from cache", "key", objName)
+			return body, nil
+		}
+		_ = body.Close()
+		if err != nil {
+			return nil, err
+		}
+		return e.fillCache(ctx, objName, obj, info), nil
+	}
+
+	obj, info, err := e.getObject(ctx, objName, GetOptions{}, true)
+	if err != nil {
+		return nil, err
+	}
+	return e.fillCache(ctx, objName, obj, info), nil
+}
+
+// fillCache returns a reader for body that stores the object in the
+// cache as it is read. The entry is only stored if body is read to the end.
+func (e *S3) fillCache(ctx context.Context, objName string, body io.ReadCloser, info minio.ObjectInfo) io.ReadCloser {
+	pr, pw := io.Pipe()
+	f := &cacheFiller{
+		body: body,
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+
+	entry := CacheEntry{
+		ETag:        info.ETag,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+	}
+	if size, ok := uncompressedSize(info.UserMetadata); ok && isCompressed(info.Metadata.Get("Content-Encoding"), info.UserMetadata) {
+		entry.Size = size
+	}
+
+	key := e.cacheKey(objName)
+	generation := e.generations.start(key)
+	go func() {
+		defer close(f.done)
+		defer e.generations.finish(key)
+		ctx := context.WithoutCancel(ctx)
+		err := e.cache.Set(ctx, key, entry, &staleReader{
+			reader: pr,
+			current: func() bool {
+				return e.generations.current(key, generation)
+			},
+		})
+		if err != nil && !errors.Is(err, errCacheFillAborted) && !errors.Is(err, errCacheFillStale) {
+			e.logger.Debug("not caching object", "key", objName, "error", err)
+		}
+		// The object may have been changed after the body was read but
+		// before the entry was stored
+		if err == nil && !e.generations.current(key, generation) {
+			e.cache.Invalidate(ctx, key)
+		}
+		_ = pr.CloseWithError(errCacheFillAborted)
+	}()
+
+	return f
+}
+
+// staleReader fails with errCacheFillStale instead of returning io.EOF if
+// the object was invalidated while it was read, so that Cache.Set doesn't
+// store it
+type staleReader struct {
+	reader  io.Reader
+	current func() bool
+}
+
+func (r *staleReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if errors.Is(err, io.EOF) && !r.current() {
+		return n, errCacheFillStale
+	}
+	return n, err
+}
+
+// cacheFiller tees an object body into a pipe read by Cache.Set
+type cacheFiller struct {
+	body   io.ReadCloser
+	pw     *io.PipeWriter
+	done   chan struct{}
+	failed bool
+}
+
+func (f *cacheFiller) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+	if n > 0 && !f.failed {
+		if _, werr := f.pw.Write(p[:n]); werr != nil {
+			f.failed = true
+		}
+	}
+	if err != nil && !f.failed {
+		f.failed = true
+		if errors.Is(err, io.EOF) {
+			_ = f.pw.Close()
+		} else {
+			_ = f.pw.CloseWithError(err)
+		}
+		<-f.done
+	}
+	return n, err
+}
+
+func (f *cacheFiller) Close() error {
+	_ = f.pw.CloseWithError(errCacheFillAborted)
+	<-f.done
+	return f.body.Close()
+}
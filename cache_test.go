@@ -0,0 +1,90 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	client := s3test.NewServer(t, func(options *s3.Options) {
+		options.MemoryCacheMaxBytes = 1 << 20
+		options.MemoryCacheTTL = time.Hour
+	})
+	first := bytes.Repeat([]byte("a"), 64<<10)
+	if _, err := client.PutObject(ctx, "data", "a", bytes.NewReader(first), int64(len(first)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	if read := readObject(t, client, "data", "a"); !bytes.Equal(read, first) {
+		t.Fatal("expected the first version")
+	}
+	if read := readObject(t, client, "data", "a"); !bytes.Equal(read, first) {
+		t.Fatal("expected the first version from the cache")
+	}
+
+	second := bytes.Repeat([]byte("b"), 64<<10)
+	if _, err := client.PutObject(ctx, "data", "a", bytes.NewReader(second), int64(len(second)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	if read := readObject(t, client, "data", "a"); !bytes.Equal(read, second) {
+		t.Fatal("expected the cached object to be invalidated by the put")
+	}
+}
+
+func TestMemoryCacheFillInvalidated(t *testing.T) {
+	ctx := context.Background()
+	client := s3test.NewServer(t, func(options *s3.Options) {
+		options.MemoryCacheMaxBytes = 1 << 20
+		options.MemoryCacheTTL = time.Hour
+	})
+	first := bytes.Repeat([]byte("a"), 64<<10)
+	if _, err := client.PutObject(ctx, "data", "a", bytes.NewReader(first), int64(len(first)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	// The cache is filled as the first version is read, which is only
+	// finished after the object was replaced
+	reader, err := client.GetObject(ctx, "data", "a")
+	if err != nil {
+		t.Fatalf("failed to get object: %v", err)
+	}
+	if _, err = reader.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("failed to read object: %v", err)
+	}
+	second := bytes.Repeat([]byte("b"), 64<<10)
+	if _, err = client.PutObject(ctx, "data", "a", bytes.NewReader(second), int64(len(second)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	if _, err = io.ReadAll(reader); err != nil {
+		t.Fatalf("failed to read object: %v", err)
+	}
+	if err = reader.Close(); err != nil {
+		t.Fatalf("failed to close object: %v", err)
+	}
+
+	if read := readObject(t, client, "data", "a"); !bytes.Equal(read, second) {
+		t.Fatal("expected the fill that started before the put not to be cached")
+	}
+}
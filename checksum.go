@@ -0,0 +1,116 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrChecksumMismatch    = errors.New("checksum mismatch")
+	ErrChecksumUnavailable = errors.New("object has no verifiable checksum")
+)
+
+// computeChecksum hashes the data in reader using the given checksum type and
+// returns a reader positioned at the start of the data again. Seekable readers
+// are rewound, everything else is buffered in memory.
+func computeChecksum(reader io.Reader, objectSize int64, checksum minio.ChecksumType) (io.Reader, int64, string, error) {
+	hasher := checksum.Hasher()
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("failed to seek reader: %w", err)
+		}
+		src := io.Reader(seeker)
+		if objectSize >= 0 {
+			src = io.LimitReader(seeker, objectSize)
+		}
+		n, err := io.Copy(hasher, src)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("failed to compute checksum: %w", err)
+		}
+		if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, 0, "", fmt.Errorf("failed to seek reader: %w", err)
+		}
+		return seeker, n, base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+	}
+
+	src := reader
+	if objectSize >= 0 {
+		src = io.LimitReader(reader, objectSize)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to read object data: %w", err)
+	}
+	hasher.Write(data)
+	return bytes.NewReader(data), int64(len(data)), base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// objectChecksum returns the strongest full-object checksum stored for an object.
+// Checksums of multipart objects are checksums of their part checksums, which
+// can't be verified against the object body.
+func objectChecksum(info minio.ObjectInfo) (minio.ChecksumType, string, bool) {
+	candidates := []struct {
+		checksum minio.ChecksumType
+		value    string
+	}{
+		{minio.ChecksumSHA256, info.ChecksumSHA256},
+		{minio.ChecksumSHA1, info.ChecksumSHA1},
+		{minio.ChecksumCRC32C, info.ChecksumCRC32C},
+		{minio.ChecksumCRC32, info.ChecksumCRC32},
+	}
+	for _, c := range candidates {
+		if c.value != "" && !strings.Contains(c.value, "-") {
+			return c.checksum, c.value, true
+		}
+	}
+	return minio.ChecksumNone, "", false
+}
+
+// checksumReader verifies the checksum of the body as it is read, returning
+// ErrChecksumMismatch instead of io.EOF if the body is corrupt
+type checksumReader struct {
+	io.ReadCloser
+	hasher   hash.Hash
+	expected string
+}
+
+func newChecksumReader(body io.ReadCloser, checksum minio.ChecksumType, expected string) *checksumReader {
+	return &checksumReader{
+		ReadCloser: body,
+		hasher:     checksum.Hasher(),
+		expected:   expected,
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.hasher.Write(p[:n])
+	if errors.Is(err, io.EOF) && base64.StdEncoding.EncodeToString(c.hasher.Sum(nil)) != c.expected {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,91 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Codec serializes typed values for PutTyped and GetTyped
+type Codec interface {
+	// ContentType is the content type objects encoded with this codec are stored with
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values as JSON
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return JSONContentType }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// GobCodec encodes values using encoding/gob
+type GobCodec struct{}
+
+func (GobCodec) ContentType() string { return GobContentType }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// PutTyped encodes v with the given codec and stores it as an object
+func PutTyped[T any](ctx context.Context, e Storage, codec Codec, prefix string, key string, v T) (minio.UploadInfo, error) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("failed to encode object: %w", err)
+	}
+	return e.PutObject(ctx, prefix, key, bytes.NewReader(data), int64(len(data)), codec.ContentType())
+}
+
+// GetTyped gets an object and decodes it into a T with the given codec
+func GetTyped[T any](ctx context.Context, e Storage, codec Codec, prefix string, key string) (T, error) {
+	var v T
+	obj, err := e.GetObject(ctx, prefix, key)
+	if err != nil {
+		return v, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return v, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	if err = codec.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("failed to decode object: %w", err)
+	}
+	return v, nil
+}
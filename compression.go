@@ -0,0 +1,180 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+)
+
+// Compression is the algorithm used to transparently compress objects
+type Compression string
+
+const (
+	// CompressionNone disables compression for a single PutObjectWithOptions
+	// call when Options.Compression is set
+	CompressionNone Compression = "identity"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+const (
+	// UncompressedSizeMetadata is the user metadata key that stores the
+	// original size of transparently compressed objects
+	UncompressedSizeMetadata = "Uncompressed-Size"
+)
+
+// compress compresses the data in reader into memory, returning the
+// compressed data and the size of the original data
+func compress(reader io.Reader, objectSize int64, compression Compression) (*bytes.Reader, int64, error) {
+	if objectSize >= 0 {
+		reader = io.LimitReader(reader, objectSize)
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZstd:
+		var err error
+		w, err = zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+	default:
+		return nil, 0, fmt.Errorf("%w: %s", ErrUnsupportedCompression, compression)
+	}
+
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		_ = w.Close()
+		return nil, 0, fmt.Errorf("failed to compress object data: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, 0, fmt.Errorf("failed to compress object data: %w", err)
+	}
+
+	return bytes.NewReader(buf.Bytes()), n, nil
+}
+
+// isCompressed returns whether an object was transparently compressed by this package
+func isCompressed(contentEncoding string, userMetadata map[string]string) bool {
+	if _, ok := userMetadata[UncompressedSizeMetadata]; !ok {
+		return false
+	}
+	return contentEncoding == string(CompressionGzip) || contentEncoding == string(CompressionZstd)
+}
+
+// uncompressedSize returns the original size of a transparently compressed object
+func uncompressedSize(userMetadata map[string]string) (int64, bool) {
+	size, err := strconv.ParseInt(userMetadata[UncompressedSizeMetadata], 10, 64)
+	return size, err == nil
+}
+
+// ContentSize returns the size of an object once it is downloaded, which is
+// its original size if it was transparently compressed
+func ContentSize(info minio.ObjectInfo) int64 {
+	if isCompressed(info.Metadata.Get("Content-Encoding"), info.UserMetadata) {
+		if size, ok := uncompressedSize(info.UserMetadata); ok {
+			return size
+		}
+	}
+	return info.Size
+}
+
+type decompressReader struct {
+	io.Reader
+	close func()
+	body  io.ReadCloser
+}
+
+func (d *decompressReader) Close() error {
+	d.close()
+	return d.body.Close()
+}
+
+// decompress wraps body in a reader that decompresses it with the given content encoding
+func decompress(body io.ReadCloser, contentEncoding string) (io.ReadCloser, error) {
+	switch Compression(contentEncoding) {
+	case CompressionGzip:
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return &decompressReader{
+			Reader: r,
+			close:  func() { _ = r.Close() },
+			body:   body,
+		}, nil
+	case CompressionZstd:
+		r, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return &decompressReader{
+			Reader: r,
+			close:  r.Close,
+			body:   body,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, contentEncoding)
+	}
+}
+
+// lazyDecompressReader decompresses the body of a lazy GetObject request if
+// the object was transparently compressed, which is only known once the
+// request has been sent on the first Read
+type lazyDecompressReader struct {
+	obj  *minio.Object
+	body io.ReadCloser
+}
+
+func (l *lazyDecompressReader) Read(p []byte) (int, error) {
+	if l.body == nil {
+		info, err := l.obj.Stat()
+		if err != nil {
+			return 0, err
+		}
+		body := io.ReadCloser(l.obj)
+		if contentEncoding := info.Metadata.Get("Content-Encoding"); isCompressed(contentEncoding, info.UserMetadata) {
+			if body, err = decompress(l.obj, contentEncoding); err != nil {
+				return 0, err
+			}
+		}
+		l.body = body
+	}
+	return l.body.Read(p)
+}
+
+func (l *lazyDecompressReader) Close() error {
+	if l.body == nil {
+		return l.obj.Close()
+	}
+	return l.body.Close()
+}
@@ -0,0 +1,103 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible data "), 4096)
+	for _, lazy := range []bool{false, true} {
+		// Objects are decompressed by clients that don't compress uploads
+		client := s3test.NewServer(t, func(options *s3.Options) {
+			options.LazyGetObject = lazy
+		})
+		for _, compression := range []s3.Compression{s3.CompressionNone, s3.CompressionGzip, s3.CompressionZstd} {
+			name := string(compression)
+			if lazy {
+				name += "/lazy"
+			}
+			t.Run(name, func(t *testing.T) {
+				ctx := context.Background()
+				key := string(compression)
+				if _, err := client.PutObjectWithOptions(ctx, "compressed", key, bytes.NewReader(data), int64(len(data)), s3.PutOptions{
+					Compression: compression,
+				}); err != nil {
+					t.Fatalf("failed to put object: %v", err)
+				}
+
+				info, err := client.StatObject(ctx, "compressed", key)
+				if err != nil {
+					t.Fatalf("failed to stat object: %v", err)
+				}
+				if compression != s3.CompressionNone && info.Size >= int64(len(data)) {
+					t.Fatalf("expected object to be stored compressed, got %d bytes for %d", info.Size, len(data))
+				}
+				if size := s3.ContentSize(info); size != int64(len(data)) {
+					t.Fatalf("expected content size %d, got %d", len(data), size)
+				}
+
+				reader, err := client.GetObject(ctx, "compressed", key)
+				if err != nil {
+					t.Fatalf("failed to get object: %v", err)
+				}
+				defer reader.Close()
+				read, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("failed to read object: %v", err)
+				}
+				if !bytes.Equal(read, data) {
+					t.Fatalf("expected %d bytes of original data, got %d bytes", len(data), len(read))
+				}
+			})
+		}
+	}
+}
+
+func TestCompressionOption(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("compressible data "), 4096)
+	client := s3test.NewServer(t, func(options *s3.Options) {
+		options.Compression = s3.CompressionZstd
+	})
+	if _, err := client.PutObject(ctx, "compressed", "default", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	// The per-call option overrides Options.Compression
+	if _, err := client.PutObjectWithOptions(ctx, "compressed", "none", bytes.NewReader(data), int64(len(data)), s3.PutOptions{
+		Compression: s3.CompressionNone,
+	}); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	for key, compressed := range map[string]bool{"default": true, "none": false} {
+		info, err := client.StatObject(ctx, "compressed", key)
+		if err != nil {
+			t.Fatalf("failed to stat object: %v", err)
+		}
+		if (info.Size < int64(len(data))) != compressed {
+			t.Fatalf("expected %s to be compressed %v, got %d bytes for %d", key, compressed, info.Size, len(data))
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+var (
+	ErrUnknownBucketLookup = errors.New("unknown bucket lookup")
+)
+
+// BucketLookup selects how the bucket is addressed in request URLs
+type BucketLookup string
+
+const (
+	// BucketLookupAuto puts the bucket in the host name for endpoints known
+	// to support it, such as AWS, and in the path otherwise. It is the
+	// default if no lookup is set.
+	BucketLookupAuto BucketLookup = "auto"
+
+	// BucketLookupPath always puts the bucket in the path
+	// (endpoint/bucket/key), which some appliances require
+	BucketLookupPath BucketLookup = "path"
+
+	// BucketLookupDNS always puts the bucket in the host name
+	// (bucket.endpoint/key)
+	BucketLookupDNS BucketLookup = "dns"
+)
+
+// minioBucketLookup returns the minio equivalent of a BucketLookup
+func minioBucketLookup(lookup BucketLookup) (minio.BucketLookupType, error) {
+	switch lookup {
+	case "", BucketLookupAuto:
+		return minio.BucketLookupAuto, nil
+	case BucketLookupPath:
+		return minio.BucketLookupPath, nil
+	case BucketLookupDNS:
+		return minio.BucketLookupDNS, nil
+	}
+	return minio.BucketLookupAuto, fmt.Errorf("%w: %s", ErrUnknownBucketLookup, lookup)
+}
+
+// connection is the minio client shared by a client and the clients derived
+// from it, which is replaced when the client is reloaded
+type connection struct {
+	client      atomic.Pointer[minio.Client]
+	credentials atomic.Pointer[credentials.Credentials]
+
+	// httpClient sends requests outside of the minio client, such as to the
+	// MinIO admin API
+	httpClient atomic.Pointer[http.Client]
+
+	// clients are the clients of Options.Endpoint and the failover endpoints
+	// in order, and active is the index of the one in client
+	clients atomic.Pointer[[]*minio.Client]
+	active  atomic.Int32
+
+	// readers are the endpoints that reads are routed to, the main endpoint
+	// followed by Options.ReadEndpoints, or nil if there are none
+	readers atomic.Pointer[[]*readEndpoint]
+}
+
+// newConnection creates the minio client for the endpoint, transport and
+// credentials in the options
+func newConnection(options *Options, logger Logger) (*connection, error) {
+	bucketLookup, err := minioBucketLookup(options.BucketLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(options, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := newCredentials(options, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	signing := creds
+	if options.SignatureV2 {
+		signing = credentials.New(&signatureV2{
+			creds: creds,
+		})
+	}
+
+	endpoints := append([]string{options.Endpoint}, options.FailoverEndpoints...)
+	clients := make([]*minio.Client, len(endpoints))
+	for i, endpoint := range endpoints {
+		client, err := newClient(endpoint, options, signing, transport, bucketLookup)
+		if err != nil {
+			return nil, err
+		}
+		clients[i] = client
+	}
+
+	var readers []*readEndpoint
+	if len(options.ReadEndpoints) > 0 {
+		readers = append(readers, new(readEndpoint))
+	}
+	for _, endpoint := range options.ReadEndpoints {
+		client, err := newClient(endpoint, options, signing, transport, bucketLookup)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, &readEndpoint{
+			client: client,
+		})
+	}
+
+	conn := new(connection)
+	conn.credentials.Store(creds)
+	if readers != nil {
+		conn.readers.Store(&readers)
+	}
+	conn.clients.Store(&clients)
+	conn.client.Store(clients[0])
+	conn.httpClient.Store(&http.Client{
+		Transport: transport,
+	})
+	return conn, nil
+}
+
+// newClient creates the minio client for one endpoint
+func newClient(endpoint string, options *Options, creds *credentials.Credentials, transport http.RoundTripper, bucketLookup minio.BucketLookupType) (*minio.Client, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       options.Secure,
+		Region:       options.Region,
+		Transport:    transport,
+		BucketLookup: bucketLookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client for %s: %w", endpoint, err)
+	}
+	if options.DualStack {
+		client.SetS3EnableDualstack(true)
+	}
+	return client, nil
+}
+
+// client returns the current minio client
+func (e *S3) client() *minio.Client {
+	return e.conn.client.Load()
+}
+
+// options returns the current options of the client
+func (e *S3) options() *Options {
+	return e.current.Load()
+}
+
+// Reload replaces the connection of the client and the clients derived from
+// it with one for the endpoint, TLS, region, transport and credentials
+// settings in options, such as after keys were rotated, with the defaults of
+// options.Provider applied. The client also switches to options.Bucket,
+// while clients derived with Bucket or Scoped keep their buckets. Other
+// options are ignored, and requests that already started finish on the old
+// connection. If the new connection cannot be created the old one is kept.
+func (e *S3) Reload(options *Options) error {
+	options, err := withProvider(options)
+	if err != nil {
+		return err
+	}
+	conn, err := newConnection(options, e.logger)
+	if err != nil {
+		return err
+	}
+
+	e.reload.Lock()
+	defer e.reload.Unlock()
+	next := *e.options()
+	next.Endpoint = options.Endpoint
+	next.FailoverEndpoints = options.FailoverEndpoints
+	next.ReadEndpoints = options.ReadEndpoints
+	next.Secure = options.Secure
+	next.Region = options.Region
+	next.Bucket = options.Bucket
+	next.Provider = options.Provider
+	next.BucketLookup = options.BucketLookup
+	next.DualStack = options.DualStack
+	e.current.Store(&next)
+
+	e.conn.credentials.Store(conn.credentials.Load())
+	e.conn.clients.Store(conn.clients.Load())
+	e.conn.active.Store(0)
+	e.conn.readers.Store(conn.readers.Load())
+	e.conn.client.Store(conn.client.Load())
+	e.conn.httpClient.Store(conn.httpClient.Load())
+	e.logger.Info("reloaded s3 client", "endpoint", options.Endpoint, "bucket", options.Bucket)
+	return nil
+}
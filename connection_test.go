@@ -0,0 +1,80 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	first, second := s3test.StartServer(), s3test.StartServer()
+	defer first.Close()
+	defer second.Close()
+
+	client, err := s3.NewWithLogger(first.Options("first"), nil)
+	if err != nil {
+		t.Fatalf("failed to create s3 client: %v", err)
+	}
+	defer client.Close()
+	if err = client.MakeBucket(ctx, "first"); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	options := second.Options("second")
+	if err = client.Reload(options); err != nil {
+		t.Fatalf("failed to reload client: %v", err)
+	}
+	if err = client.MakeBucket(ctx, "second"); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	data := []byte("hello world")
+	if _, err = client.PutObject(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object after reload: %v", err)
+	}
+	u, err := client.PublicURL("data", "a")
+	if err != nil {
+		t.Fatalf("failed to get public url: %v", err)
+	}
+	if u.Host != second.Endpoint() || !strings.HasPrefix(u.Path, "/second/") {
+		t.Fatalf("expected public url on %s in the second bucket, got %s", second.Endpoint(), u)
+	}
+
+	// The object was written to the second server
+	other, err := s3.NewWithLogger(second.Options("second"), nil)
+	if err != nil {
+		t.Fatalf("failed to create s3 client: %v", err)
+	}
+	defer other.Close()
+	if _, err = other.StatObject(ctx, "data", "a"); err != nil {
+		t.Fatalf("expected object on the second server: %v", err)
+	}
+
+	// Provider defaults and checks are applied to the reloaded options
+	options.Provider = s3.ProviderR2
+	options.KMSKeyID = "key"
+	if err = client.Reload(options); !errors.Is(err, s3.ErrNotImplemented) {
+		t.Fatalf("expected ErrNotImplemented reloading r2 options with sse-kms, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+var (
+	ErrUnknownCredentialsSource = errors.New("unknown credentials source")
+	ErrRoleARNRequired          = errors.New("role arn is required")
+
+	ErrSourceCredentialsRequired = errors.New("access key and secret key are required to assume a role")
+	ErrWebIdentityTokenRequired  = errors.New("web identity token file is required")
+)
+
+const (
+	// DefaultSTSEndpoint is the STS endpoint used to assume roles if
+	// Options.STSEndpoint is empty
+	DefaultSTSEndpoint = "https://sts.amazonaws.com"
+
+	// WebIdentityTokenFileEnv and RoleARNEnv are the environment variables
+	// set for pods using IAM roles for service accounts, which are used by
+	// CredentialsWebIdentity if the options don't set them
+	WebIdentityTokenFileEnv = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	RoleARNEnv              = "AWS_ROLE_ARN"
+)
+
+// CredentialsSource selects where the client gets the credentials it signs
+// requests with
+type CredentialsSource string
+
+const (
+	// CredentialsStatic uses Options.AccessKey, Options.SecretKey and
+	// Options.SessionToken, and is the default if no source is set
+	CredentialsStatic CredentialsSource = "static"
+
+	// CredentialsIAM fetches temporary credentials from the EC2 instance
+	// metadata service or the ECS container credentials endpoint, and
+	// refreshes them before they expire
+	CredentialsIAM CredentialsSource = "iam"
+
+	// CredentialsAssumeRole assumes Options.AssumeRoleARN with STS using the
+	// access key and secret key, and assumes it again before the temporary
+	// credentials expire
+	CredentialsAssumeRole CredentialsSource = "assume_role"
+
+	// CredentialsWebIdentity exchanges the token in Options.WebIdentityTokenFile
+	// for temporary credentials of Options.AssumeRoleARN with STS, reading the
+	// token again every time the credentials are refreshed
+	CredentialsWebIdentity CredentialsSource = "web_identity"
+
+	// CredentialsEnv uses the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	// environment variables, or the MINIO_ equivalents
+	CredentialsEnv CredentialsSource = "env"
+
+	// CredentialsFile reads a profile from a shared AWS credentials file, see
+	// Options.SharedCredentialsFile
+	CredentialsFile CredentialsSource = "file"
+
+	// CredentialsChain uses the first of the static keys, the environment
+	// variables, the shared credentials file and IAM that has credentials,
+	// in that order, like the AWS SDKs do
+	CredentialsChain CredentialsSource = "chain"
+
+	// CredentialsAnonymous sends unsigned requests, for reading public
+	// buckets without credentials
+	CredentialsAnonymous CredentialsSource = "anonymous"
+)
+
+// newCredentials returns the credentials selected by the options. Requests to
+// STS and the IAM endpoints are sent with the given transport.
+func newCredentials(options *Options, transport http.RoundTripper) (*credentials.Credentials, error) {
+	if options.Credentials != nil {
+		return options.Credentials, nil
+	}
+
+	switch options.CredentialsSource {
+	case "", CredentialsStatic:
+		provider, err := staticProvider(options)
+		if err != nil {
+			return nil, err
+		}
+		return credentials.New(provider), nil
+	case CredentialsIAM:
+		return credentials.New(iamProvider(options, transport)), nil
+	case CredentialsAssumeRole:
+		return newAssumeRoleCredentials(options, transport)
+	case CredentialsWebIdentity:
+		return newWebIdentityCredentials(options, transport)
+	case CredentialsEnv:
+		return credentials.NewChainCredentials(envProviders()), nil
+	case CredentialsAnonymous:
+		return credentials.NewStatic("", "", "", credentials.SignatureAnonymous), nil
+	case CredentialsFile:
+		return credentials.New(fileProvider(options)), nil
+	case CredentialsChain:
+		provider, err := staticProvider(options)
+		if err != nil {
+			return nil, err
+		}
+		providers := []credentials.Provider{provider}
+		providers = append(providers, envProviders()...)
+		providers = append(providers, fileProvider(options), iamProvider(options, transport))
+		return credentials.NewChainCredentials(providers), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCredentialsSource, options.CredentialsSource)
+}
+
+// iamProvider returns the provider of the credentials from the instance
+// metadata service or the ECS container credentials endpoint
+func iamProvider(options *Options, transport http.RoundTripper) *credentials.IAM {
+	return &credentials.IAM{
+		Client: &http.Client{
+			Transport: transport,
+		},
+		Endpoint: options.IAMEndpoint,
+	}
+}
+
+// staticProvider returns the provider of the access key and secret key in the
+// options, which may be read from secret files
+func staticProvider(options *Options) (credentials.Provider, error) {
+	files, err := secretFilesProvider(options)
+	if err != nil {
+		return nil, err
+	}
+	if files != nil {
+		return files, nil
+	}
+	return &credentials.Static{
+		Value: credentials.Value{
+			AccessKeyID:     options.AccessKey,
+			SecretAccessKey: options.SecretKey,
+			SessionToken:    options.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		},
+	}, nil
+}
+
+func newAssumeRoleCredentials(options *Options, transport http.RoundTripper) (*credentials.Credentials, error) {
+	if options.AssumeRoleARN == "" {
+		return nil, ErrRoleARNRequired
+	}
+	endpoint := options.STSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+
+	// The source credentials are only read once, even from watched secret files
+	provider, err := staticProvider(options)
+	if err != nil {
+		return nil, err
+	}
+	source, err := provider.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	if source.AccessKeyID == "" || source.SecretAccessKey == "" {
+		return nil, ErrSourceCredentialsRequired
+	}
+
+	return credentials.New(&credentials.STSAssumeRole{
+		Client: &http.Client{
+			Transport: transport,
+		},
+		STSEndpoint: endpoint,
+		Options: credentials.STSAssumeRoleOptions{
+			AccessKey:       source.AccessKeyID,
+			SecretKey:       source.SecretAccessKey,
+			SessionToken:    source.SessionToken,
+			Location:        options.Region,
+			DurationSeconds: int(options.AssumeRoleDuration.Seconds()),
+			RoleARN:         options.AssumeRoleARN,
+			RoleSessionName: options.AssumeRoleSessionName,
+			ExternalID:      options.AssumeRoleExternalID,
+		},
+	}), nil
+}
+
+func newWebIdentityCredentials(options *Options, transport http.RoundTripper) (*credentials.Credentials, error) {
+	tokenFile := options.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv(WebIdentityTokenFileEnv)
+	}
+	if tokenFile == "" {
+		return nil, ErrWebIdentityTokenRequired
+	}
+	roleARN := options.AssumeRoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv(RoleARNEnv)
+	}
+	if roleARN == "" {
+		return nil, ErrRoleARNRequired
+	}
+	endpoint := options.STSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+
+	return credentials.New(&credentials.STSWebIdentity{
+		Client: &http.Client{
+			Transport: transport,
+		},
+		STSEndpoint: endpoint,
+		RoleARN:     roleARN,
+		GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+			token, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read web identity token: %w", err)
+			}
+			return &credentials.WebIdentityToken{
+				Token:  strings.TrimSpace(string(token)),
+				Expiry: int(options.AssumeRoleDuration.Seconds()),
+			}, nil
+		},
+	}), nil
+}
+
+func envProviders() []credentials.Provider {
+	return []credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+	}
+}
+
+func fileProvider(options *Options) credentials.Provider {
+	return &credentials.FileAWSCredentials{
+		Filename: options.SharedCredentialsFile,
+		Profile:  options.SharedCredentialsProfile,
+	}
+}
+
+// RotatingCredentials is a credentials.Provider for static credentials that
+// are replaced at runtime, for example when a secret store issues new keys.
+// Requests signed after Rotate use the new credentials, without recreating
+// the client.
+type RotatingCredentials struct {
+	mu      sync.Mutex
+	value   credentials.Value
+	rotated bool
+}
+
+var _ credentials.Provider = (*RotatingCredentials)(nil)
+
+// NewRotatingCredentials returns RotatingCredentials that start with the
+// given credentials
+func NewRotatingCredentials(accessKey string, secretKey string, sessionToken string) *RotatingCredentials {
+	r := new(RotatingCredentials)
+	r.Rotate(accessKey, secretKey, sessionToken)
+	return r
+}
+
+// Rotate replaces the credentials
+func (r *RotatingCredentials) Rotate(accessKey string, secretKey string, sessionToken string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.value = credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SessionToken:    sessionToken,
+		SignerType:      credentials.SignatureV4,
+	}
+	r.rotated = true
+}
+
+func (r *RotatingCredentials) Retrieve() (credentials.Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotated = false
+	return r.value, nil
+}
+
+func (r *RotatingCredentials) IsExpired() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotated
+}
+
+// CredentialsFunc fetches credentials that are valid until the returned
+// time, such as a lease from a secret store. A zero time never expires.
+type CredentialsFunc func() (credentials.Value, time.Time, error)
+
+// funcProvider is a credentials.Provider that calls a CredentialsFunc
+type funcProvider struct {
+	credentials.Expiry
+	fn CredentialsFunc
+}
+
+// NewFuncProvider returns a credentials.Provider that calls fn for new
+// credentials shortly before the previous ones expire
+func NewFuncProvider(fn CredentialsFunc) credentials.Provider {
+	return &funcProvider{
+		fn: fn,
+	}
+}
+
+func (p *funcProvider) Retrieve() (credentials.Value, error) {
+	value, expiration, err := p.fn()
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to fetch credentials: %w", err)
+	}
+	if value.SignerType == credentials.SignatureDefault {
+		value.SignerType = credentials.SignatureV4
+	}
+	if expiration.IsZero() {
+		expiration = time.Now().Add(time.Duration(1<<63 - 1))
+	}
+	p.SetExpiration(expiration, credentials.DefaultExpiryWindow)
+	return value, nil
+}
+
+// signatureV2 is a credentials.Provider that signs the credentials of
+// another source with Signature V2
+type signatureV2 struct {
+	creds *credentials.Credentials
+}
+
+func (s *signatureV2) Retrieve() (credentials.Value, error) {
+	value, err := s.creds.Get()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if !value.SignerType.IsAnonymous() {
+		value.SignerType = credentials.SignatureV2
+	}
+	return value, nil
+}
+
+func (s *signatureV2) IsExpired() bool {
+	return s.creds.IsExpired()
+}
+
+// RefreshCredentials discards the cached credentials of the client, so that
+// the next request fetches them again from their source
+func (e *S3) RefreshCredentials() {
+	e.conn.credentials.Load().Expire()
+}
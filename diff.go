@@ -0,0 +1,141 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectDiff is a key whose objects differ between two prefixes
+type ObjectDiff struct {
+	Key string
+	A   minio.ObjectInfo
+	B   minio.ObjectInfo
+}
+
+// PrefixDiff is the result of DiffPrefixes, with keys relative to the
+// compared prefixes in listing order. With Options.DisablePrefixing the
+// prefixes are stripped from the object names as raw strings.
+type PrefixDiff struct {
+	OnlyInA   []string
+	OnlyInB   []string
+	Differing []ObjectDiff
+}
+
+// Equal returns whether the compared prefixes hold the same objects
+func (d *PrefixDiff) Equal() bool {
+	return len(d.OnlyInA) == 0 && len(d.OnlyInB) == 0 && len(d.Differing) == 0
+}
+
+// DiffPrefixes compares the objects under prefixes a and b by key, size and
+// ETag. Both prefixes are listed at the same time and merged in key order,
+// so only the differences are held in memory. Objects uploaded in parts of
+// different sizes have different ETags even if their data is the same.
+func (e *S3) DiffPrefixes(ctx context.Context, a string, b string) (*PrefixDiff, error) {
+	return e.DiffPrefixesWith(ctx, a, e, b)
+}
+
+// DiffPrefixesWith compares the objects under prefix a with those under
+// prefix b of another client, such as the same prefix in a replica bucket,
+// as DiffPrefixes does
+func (e *S3) DiffPrefixesWith(ctx context.Context, a string, other *S3, b string) (*PrefixDiff, error) {
+	e.logOperation("DiffPrefixes", "comparing prefixes", "a", a, "b", b, "bucket", e.options().Bucket, "other_bucket", other.options().Bucket)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listA := newDiffListing(ctx, e, a)
+	listB := newDiffListing(ctx, other, b)
+
+	diff := new(PrefixDiff)
+	for {
+		objA, okA, err := listA.peek()
+		if err != nil {
+			return diff, err
+		}
+		objB, okB, err := listB.peek()
+		if err != nil {
+			return diff, err
+		}
+
+		switch {
+		case !okA && !okB:
+			return diff, nil
+		case okA && (!okB || objA.Key < objB.Key):
+			diff.OnlyInA = append(diff.OnlyInA, objA.Key)
+			listA.next()
+		case okB && (!okA || objB.Key < objA.Key):
+			diff.OnlyInB = append(diff.OnlyInB, objB.Key)
+			listB.next()
+		default:
+			if objA.Size != objB.Size || strings.Trim(objA.ETag, `"`) != strings.Trim(objB.ETag, `"`) {
+				diff.Differing = append(diff.Differing, ObjectDiff{
+					Key: objA.Key,
+					A:   objA,
+					B:   objB,
+				})
+			}
+			listA.next()
+			listB.next()
+		}
+	}
+}
+
+// diffListing is a recursive listing with relative keys that can be peeked
+type diffListing struct {
+	objects <-chan minio.ObjectInfo
+	root    string
+	head    *minio.ObjectInfo
+	done    bool
+}
+
+func newDiffListing(ctx context.Context, client *S3, prefix string) *diffListing {
+	return &diffListing{
+		objects: client.listRecursive(ctx, prefix),
+		root:    client.listPrefix(prefix),
+	}
+}
+
+// peek returns the next object without consuming it, and false once the
+// listing is exhausted
+func (l *diffListing) peek() (minio.ObjectInfo, bool, error) {
+	if l.head == nil && !l.done {
+		object, ok := <-l.objects
+		if !ok {
+			l.done = true
+		} else {
+			if object.Err != nil {
+				return object, false, fmt.Errorf("failed to list objects: %w", object.Err)
+			}
+			// Keys are relative to the listed prefix even if prefixing is
+			// disabled, so that objects under different prefixes match
+			object.Key = strings.TrimPrefix(object.Key, l.root)
+			l.head = &object
+		}
+	}
+	if l.head == nil {
+		return minio.ObjectInfo{}, false, nil
+	}
+	return *l.head, true, nil
+}
+
+func (l *diffListing) next() {
+	l.head = nil
+}
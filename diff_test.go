@@ -0,0 +1,70 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestDiffPrefixes(t *testing.T) {
+	for _, disablePrefixing := range []bool{false, true} {
+		name := "prefixing"
+		if disablePrefixing {
+			name = "disabled"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			client := s3test.NewServer(t, func(options *s3.Options) {
+				options.DisablePrefixing = disablePrefixing
+			})
+			// Keys are full names if prefixing is disabled
+			put := func(prefix string, key string, data string) {
+				if disablePrefixing {
+					key = prefix + "/" + key
+				}
+				if _, err := client.PutObject(ctx, prefix, key, bytes.NewReader([]byte(data)), int64(len(data)), "text/plain"); err != nil {
+					t.Fatalf("failed to put object: %v", err)
+				}
+			}
+			put("a", "same", "same")
+			put("b", "same", "same")
+			put("a", "changed", "first")
+			put("b", "changed", "second")
+			put("a", "only-a", "a")
+			put("b", "only-b", "b")
+
+			diff, err := client.DiffPrefixes(ctx, "a/", "b/")
+			if err != nil {
+				t.Fatalf("failed to diff prefixes: %v", err)
+			}
+			if len(diff.OnlyInA) != 1 || diff.OnlyInA[0] != "only-a" || len(diff.OnlyInB) != 1 || diff.OnlyInB[0] != "only-b" {
+				t.Fatalf("expected only-a and only-b to be missing on the other side, got %v and %v", diff.OnlyInA, diff.OnlyInB)
+			}
+			if len(diff.Differing) != 1 || diff.Differing[0].Key != "changed" {
+				t.Fatalf("expected changed to differ, got %+v", diff.Differing)
+			}
+			if diff.Equal() {
+				t.Fatal("expected prefixes not to be equal")
+			}
+		})
+	}
+}
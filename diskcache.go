@@ -0,0 +1,176 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultDiskCacheMaxBytes = 1 << 30
+
+	diskCacheSuffix = ".s3cache"
+)
+
+// DiskCache is a size-bounded LRU cache of objects stored as files in a
+// local directory. The index is kept in memory, so the cache starts out
+// empty every time it is created.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	used    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type diskCacheItem struct {
+	key   string
+	path  string
+	entry CacheEntry
+}
+
+// NewDiskCache creates a DiskCache in dir holding up to maxBytes of objects,
+// removing any files left behind in dir by a previous DiskCache
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultDiskCacheMaxBytes
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create disk cache directory: %w", err)
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, "*"+diskCacheSuffix+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk cache directory: %w", err)
+	}
+	for _, path := range stale {
+		_ = os.Remove(path)
+	}
+
+	return &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}, nil
+}
+
+func (d *DiskCache) Get(_ context.Context, key string) (io.ReadCloser, CacheEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	elem, ok := d.entries[key]
+	if !ok {
+		return nil, CacheEntry{}, false
+	}
+
+	item := elem.Value.(*diskCacheItem)
+	f, err := os.Open(item.path)
+	if err != nil {
+		d.remove(elem)
+		return nil, CacheEntry{}, false
+	}
+
+	d.lru.MoveToFront(elem)
+	return f, item.entry, true
+}
+
+func (d *DiskCache) Set(_ context.Context, key string, entry CacheEntry, body io.Reader) error {
+	if entry.Size > d.maxBytes {
+		return ErrCacheEntryTooLarge
+	}
+
+	tmp, err := os.CreateTemp(d.dir, "*"+diskCacheSuffix+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+
+	n, err := io.Copy(tmp, io.LimitReader(body, d.maxBytes+1))
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && n > d.maxBytes {
+		err = ErrCacheEntryTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	entry.Size = n
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := d.path(key)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store cache file: %w", err)
+	}
+
+	if elem, ok := d.entries[key]; ok {
+		d.used -= elem.Value.(*diskCacheItem).entry.Size
+		d.lru.Remove(elem)
+		delete(d.entries, key)
+	}
+
+	d.entries[key] = d.lru.PushFront(&diskCacheItem{
+		key:   key,
+		path:  path,
+		entry: entry,
+	})
+	d.used += entry.Size
+
+	for d.used > d.maxBytes {
+		d.remove(d.lru.Back())
+	}
+
+	return nil
+}
+
+func (d *DiskCache) Invalidate(_ context.Context, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elem, ok := d.entries[key]; ok {
+		d.remove(elem)
+	}
+}
+
+// remove must be called with mu held
+func (d *DiskCache) remove(elem *list.Element) {
+	item := elem.Value.(*diskCacheItem)
+	_ = os.Remove(item.path)
+	d.used -= item.entry.Size
+	d.lru.Remove(elem)
+	delete(d.entries, item.key)
+}
+
+func (d *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+diskCacheSuffix)
+}
@@ -0,0 +1,199 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Errors returned by operations are mapped to these sentinel errors where
+// possible, while the underlying minio.ErrorResponse remains reachable
+// with errors.As (or ErrorResponse)
+var (
+	ErrObjectNotFound     = errors.New("object does not exist")
+	ErrBucketNotFound     = errors.New("bucket does not exist")
+	ErrAccessDenied       = errors.New("access denied")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrTooManyRequests    = errors.New("too many requests")
+	ErrTimeout            = errors.New("operation timed out")
+	ErrNotImplemented     = errors.New("not implemented by the provider")
+)
+
+// OperationError is returned by every operation that fails, adding the
+// operation, bucket and key to the underlying error. The underlying error
+// response and sentinel errors remain reachable with errors.As and errors.Is.
+type OperationError struct {
+	Op     string
+	Bucket string
+	Key    string
+	Err    error
+}
+
+func (e *OperationError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s %s: %s", e.Op, e.Bucket, e.Err)
+	}
+	return fmt.Sprintf("%s %s/%s: %s", e.Op, e.Bucket, e.Key, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// mappedError is an error mapped to one of the sentinel errors
+type mappedError struct {
+	sentinel error
+	err      error
+}
+
+func (e *mappedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *mappedError) Unwrap() []error {
+	return []error{e.err, e.sentinel}
+}
+
+// sentinel returns the sentinel error that err maps to, or nil
+func sentinel(err error) error {
+	if errResp := ErrorResponse(err); errResp.Code != "" || errResp.StatusCode != 0 {
+		switch errResp.Code {
+		case "NoSuchKey", "NoSuchVersion":
+			return ErrObjectNotFound
+		case "NoSuchBucket":
+			return ErrBucketNotFound
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "AllAccessDisabled":
+			return ErrAccessDenied
+		case "PreconditionFailed":
+			return ErrPreconditionFailed
+		case "SlowDown", "Throttling", "ThrottlingException", "TooManyRequests", "RequestLimitExceeded":
+			return ErrTooManyRequests
+		case "RequestTimeout":
+			return ErrTimeout
+		case "NotImplemented":
+			return ErrNotImplemented
+		}
+
+		switch errResp.StatusCode {
+		case http.StatusNotFound:
+			return ErrObjectNotFound
+		case http.StatusForbidden:
+			return ErrAccessDenied
+		case http.StatusPreconditionFailed:
+			return ErrPreconditionFailed
+		case http.StatusTooManyRequests:
+			return ErrTooManyRequests
+		case http.StatusNotImplemented:
+			return ErrNotImplemented
+		}
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// wrapError maps err to its sentinel error and adds the request IDs of
+// failed requests, keeping the original error reachable
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if kind := sentinel(err); kind != nil && !errors.Is(err, kind) {
+		err = &mappedError{
+			sentinel: kind,
+			err:      err,
+		}
+	}
+	return withRequestIDs(err)
+}
+
+// RequestError is an error response from the endpoint, together with the
+// request IDs (x-amz-request-id and x-amz-id-2) that providers need to
+// look up the failed request
+type RequestError struct {
+	Err       error
+	RequestID string
+	HostID    string
+}
+
+func (e *RequestError) Error() string {
+	if e.HostID != "" {
+		return fmt.Sprintf("%s (request id: %s, host id: %s)", e.Err, e.RequestID, e.HostID)
+	}
+	return fmt.Sprintf("%s (request id: %s)", e.Err, e.RequestID)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorResponse returns the minio.ErrorResponse in err, like minio.ToErrorResponse
+// but also finding responses wrapped by this package. It returns an empty
+// response if err is not an error response.
+func ErrorResponse(err error) minio.ErrorResponse {
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp
+	}
+	return minio.ErrorResponse{}
+}
+
+// RequestIDs returns the request IDs of the failed request in err, if there are any
+func RequestIDs(err error) (requestID string, hostID string, ok bool) {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.RequestID, requestErr.HostID, true
+	}
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) && errResp.RequestID != "" {
+		return errResp.RequestID, errResp.HostID, true
+	}
+	return "", "", false
+}
+
+// withRequestIDs wraps errors returned by the endpoint in a RequestError
+func withRequestIDs(err error) error {
+	if err == nil {
+		return nil
+	}
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return err
+	}
+	var errResp minio.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.RequestID == "" {
+		return err
+	}
+	return &RequestError{
+		Err:       err,
+		RequestID: errResp.RequestID,
+		HostID:    errResp.HostID,
+	}
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	DefaultFailbackInterval = time.Second * 30
+)
+
+// failoverable returns whether err means the endpoint failed rather than the
+// request, so that it should be sent to the next endpoint
+func failoverable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// endpoints returns the number of endpoints requests can fail over between
+func (e *S3) endpoints() int {
+	return len(*e.conn.clients.Load())
+}
+
+// failover switches requests from the failed client to the next endpoint if
+// err is an endpoint failure, and returns whether the request should be sent
+// again. It returns true without switching if another request has already
+// moved away from the failed client.
+func (e *S3) failover(failed *minio.Client, err error) bool {
+	if !failoverable(err) {
+		return false
+	}
+
+	clients := *e.conn.clients.Load()
+	active := int(e.conn.active.Load())
+	if clients[active] != failed {
+		return true
+	}
+	if active+1 >= len(clients) {
+		return false
+	}
+	if e.conn.active.CompareAndSwap(int32(active), int32(active+1)) {
+		e.conn.client.Store(clients[active+1])
+		e.logger.Warn("failing over to secondary s3 endpoint", "endpoint", clients[active+1].EndpointURL().Host, "failed", failed.EndpointURL().Host, "error", err)
+	}
+	return true
+}
+
+// failback checks the primary endpoint while requests are failed over, and
+// switches them back once it is healthy again
+func (e *S3) failback() {
+	defer e.wg.Done()
+	interval := e.options().FailbackInterval
+	if interval <= 0 {
+		interval = DefaultFailbackInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		active := e.conn.active.Load()
+		if active == 0 {
+			continue
+		}
+		primary := (*e.conn.clients.Load())[0]
+
+		ctx, cancel := context.WithTimeout(e.ctx, interval)
+		exists, err := primary.BucketExists(ctx, e.options().Bucket)
+		cancel()
+		if err != nil || !exists {
+			e.logger.Debug("primary s3 endpoint is still unhealthy", "endpoint", primary.EndpointURL().Host, "error", err)
+			continue
+		}
+
+		if e.conn.active.CompareAndSwap(active, 0) {
+			e.conn.client.Store(primary)
+			e.logger.Info("failing back to primary s3 endpoint", "endpoint", primary.EndpointURL().Host)
+		}
+	}
+}
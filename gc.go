@@ -0,0 +1,146 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// DefaultGCGracePeriod is how old unreferenced objects must be before
+	// CollectGarbage deletes them, unless GCOptions.GracePeriod is set
+	DefaultGCGracePeriod = 24 * time.Hour
+)
+
+// GCOptions are the options for CollectGarbage
+type GCOptions struct {
+	// GracePeriod protects objects modified within it from being deleted,
+	// so that objects uploaded before their reference was stored survive.
+	// DefaultGCGracePeriod is used if zero, and a negative period deletes
+	// unreferenced objects regardless of their age.
+	GracePeriod time.Duration
+
+	// DryRun reports the objects that would be deleted without deleting them
+	DryRun bool
+
+	// Concurrency is the number of bulk delete requests sent at a time
+	Concurrency int
+}
+
+// GCSummary is the result of CollectGarbage
+type GCSummary struct {
+	// Scanned is the number of objects listed under the prefix
+	Scanned int
+
+	// Referenced is the number of listed objects that are referenced
+	Referenced int
+
+	// Recent is the number of unreferenced objects kept because they were
+	// modified within the grace period
+	Recent int
+
+	// Deleted is the number of objects deleted, zero for dry runs
+	Deleted int
+
+	// Unreferenced are the keys of the objects that were deleted, or that
+	// would have been for dry runs, relative to the prefix
+	Unreferenced []string
+
+	Failures []ObjectFailure
+}
+
+// ReadInventoryKeys reads the keys of a manifest written by
+// GenerateInventory, for use as the referenced keys of CollectGarbage
+func ReadInventoryKeys(r io.Reader, format InventoryFormat) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+	switch format {
+	case InventoryCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(inventoryColumns)
+		if _, err := cr.Read(); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read inventory: %w", err)
+		}
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return keys, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read inventory: %w", err)
+			}
+			keys[record[0]] = struct{}{}
+		}
+	case InventoryNDJSON:
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var entry InventoryEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("failed to read inventory: %w", err)
+			}
+			keys[entry.Key] = struct{}{}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read inventory: %w", err)
+		}
+		return keys, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownInventoryFormat, format)
+}
+
+// CollectGarbage deletes the objects under the given prefix whose keys,
+// relative to the prefix, are not in referenced and that are older than the
+// grace period. Objects are deleted with bulk delete requests as they are
+// listed, so referenced must hold every live key before it is called.
+func (e *S3) CollectGarbage(ctx context.Context, prefix string, referenced map[string]struct{}, opts GCOptions) (*GCSummary, error) {
+	grace := opts.GracePeriod
+	if grace == 0 {
+		grace = DefaultGCGracePeriod
+	}
+	e.logOperation("CollectGarbage", "collecting garbage", "prefix", prefix, "bucket", e.options().Bucket, "grace", grace, "dry_run", opts.DryRun)
+
+	summary := new(GCSummary)
+	deleted := new(DeleteSummary)
+	cutoff := time.Now().Add(-grace)
+	root := e.listPrefix(prefix)
+	err := e.deleteListed(ctx, prefix, opts.Concurrency, deleted, func(object minio.ObjectInfo) bool {
+		summary.Scanned++
+		key := e.relativeKey(root, object.Key)
+		if _, ok := referenced[key]; ok {
+			summary.Referenced++
+			return false
+		}
+		if object.LastModified.After(cutoff) {
+			summary.Recent++
+			return false
+		}
+		summary.Unreferenced = append(summary.Unreferenced, key)
+		return !opts.DryRun
+	})
+	summary.Deleted, summary.Failures = deleted.Deleted, deleted.Failures
+	return summary, err
+}
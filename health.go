@@ -0,0 +1,77 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"time"
+)
+
+// Ping checks that the endpoint is reachable and the configured bucket exists
+func (e *S3) Ping(ctx context.Context) error {
+	ctx, op := e.startOperation(ctx, "Ping", e.options().Bucket, "")
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+	defer cancel()
+
+	exists, err := e.client().BucketExists(ctx, e.options().Bucket)
+	if err == nil && !exists {
+		err = ErrBucketNotFound
+	}
+	return op.finish(err)
+}
+
+// IsOnline returns whether the last health check succeeded. It always
+// returns true if Options.HealthCheckInterval is not set.
+func (e *S3) IsOnline() bool {
+	if e.options().HealthCheckInterval <= 0 {
+		return true
+	}
+	return e.online.Load()
+}
+
+func (e *S3) monitor() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.options().HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		e.checkHealth()
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *S3) checkHealth() {
+	timeout := e.options().HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = e.options().HealthCheckInterval
+	}
+	ctx, cancel := context.WithTimeout(e.ctx, timeout)
+	defer cancel()
+
+	err := e.Ping(ctx)
+	online := err == nil
+	if e.online.Swap(online) != online {
+		if online {
+			e.logger.Info("s3 endpoint is back online", "endpoint", e.options().Endpoint)
+		} else {
+			e.logger.Warn("s3 endpoint is offline", "endpoint", e.options().Endpoint, "error", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownInventoryFormat = errors.New("unknown inventory format")
+)
+
+// InventoryFormat is the format of the manifests written by GenerateInventory
+type InventoryFormat string
+
+const (
+	// InventoryCSV writes a header row followed by a row per object
+	InventoryCSV InventoryFormat = "csv"
+	// InventoryNDJSON writes an InventoryEntry as JSON per line
+	InventoryNDJSON InventoryFormat = "ndjson"
+)
+
+// inventoryColumns are the CSV columns of an inventory
+var inventoryColumns = []string{"key", "size", "etag", "last_modified", "storage_class"}
+
+// InventoryEntry is an object in an inventory
+type InventoryEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	StorageClass string    `json:"storage_class"`
+}
+
+// GenerateInventory writes a manifest of every object under the given prefix
+// to w in the given format, with keys relative to the prefix. Entries are
+// written in listing order as they are listed, so the manifest is not held
+// in memory. It returns the number of objects written.
+func (e *S3) GenerateInventory(ctx context.Context, prefix string, w io.Writer, format InventoryFormat) (int, error) {
+	if format != InventoryCSV && format != InventoryNDJSON {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownInventoryFormat, format)
+	}
+	e.logOperation("GenerateInventory", "generating inventory", "prefix", prefix, "bucket", e.options().Bucket, "format", format)
+
+	buf := bufio.NewWriter(w)
+	var cw *csv.Writer
+	var enc *json.Encoder
+	if format == InventoryCSV {
+		cw = csv.NewWriter(buf)
+		if err := cw.Write(inventoryColumns); err != nil {
+			return 0, err
+		}
+	} else {
+		enc = json.NewEncoder(buf)
+	}
+
+	root := e.listPrefix(prefix)
+	n := 0
+	for object := range e.listRecursive(ctx, prefix) {
+		if object.Err != nil {
+			return n, fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		entry := InventoryEntry{
+			Key:          e.relativeKey(root, object.Key),
+			Size:         object.Size,
+			ETag:         strings.Trim(object.ETag, `"`),
+			LastModified: object.LastModified.UTC(),
+			StorageClass: object.StorageClass,
+		}
+		var err error
+		if cw != nil {
+			err = cw.Write([]string{
+				entry.Key,
+				strconv.FormatInt(entry.Size, 10),
+				entry.ETag,
+				entry.LastModified.Format(time.RFC3339Nano),
+				entry.StorageClass,
+			})
+		} else {
+			err = enc.Encode(entry)
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to write inventory: %w", err)
+		}
+		n++
+	}
+
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return n, fmt.Errorf("failed to write inventory: %w", err)
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return n, fmt.Errorf("failed to write inventory: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,38 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	JSONContentType = "application/json"
+	GobContentType  = "application/x-gob"
+)
+
+// PutJSON marshals v as JSON and stores it as an object
+func PutJSON[T any](ctx context.Context, e Storage, prefix string, key string, v T) (minio.UploadInfo, error) {
+	return PutTyped(ctx, e, JSONCodec{}, prefix, key, v)
+}
+
+// GetJSON gets an object and unmarshals it from JSON into a T
+func GetJSON[T any](ctx context.Context, e Storage, prefix string, key string) (T, error) {
+	return GetTyped[T](ctx, e, JSONCodec{}, prefix, key)
+}
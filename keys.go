@@ -0,0 +1,118 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxKeyLength is the maximum length of an object name in bytes
+	MaxKeyLength = 1024
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// KeyError is returned for object keys that fail validation, and matches
+// ErrInvalidKey with errors.Is
+type KeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("invalid object key %q: %s", e.Key, e.Reason)
+}
+
+func (e *KeyError) Is(target error) bool {
+	return target == ErrInvalidKey
+}
+
+// ValidateKey checks that an object name can be stored and addressed
+// reliably: it must be valid UTF-8 of at most MaxKeyLength bytes, must not
+// contain control characters, must not start with a slash and must not
+// contain "." or ".." path segments
+func ValidateKey(key string) error {
+	switch {
+	case key == "":
+		return &KeyError{Key: key, Reason: "key is empty"}
+	case len(key) > MaxKeyLength:
+		return &KeyError{Key: key, Reason: fmt.Sprintf("key is longer than %d bytes", MaxKeyLength)}
+	case !utf8.ValidString(key):
+		return &KeyError{Key: key, Reason: "key is not valid UTF-8"}
+	case strings.HasPrefix(key, "/"):
+		return &KeyError{Key: key, Reason: "key starts with a slash"}
+	case strings.IndexFunc(key, unicode.IsControl) >= 0:
+		return &KeyError{Key: key, Reason: "key contains control characters"}
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return &KeyError{Key: key, Reason: fmt.Sprintf("key contains a %q segment", segment)}
+		}
+	}
+	return nil
+}
+
+// SanitizeKey removes control characters and invalid UTF-8 from a key,
+// along with leading slashes, empty segments and "." or ".." segments.
+// A trailing slash is kept. The result may still be empty or too long.
+func SanitizeKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(key, ""))
+
+	trailing := strings.HasSuffix(key, "/")
+	segments := strings.Split(key, "/")
+	sanitized := segments[:0]
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		sanitized = append(sanitized, segment)
+	}
+
+	key = strings.Join(sanitized, "/")
+	if trailing && key != "" {
+		key += "/"
+	}
+	return key
+}
+
+// objectKey returns the full object name for a key under prefix, sanitizing
+// the key and validating the result if the options enable it
+func (e *S3) objectKey(prefix string, key string) (string, error) {
+	if e.options().SanitizeKeys {
+		key = SanitizeKey(key)
+	}
+	objName := e.objectName(prefix, key)
+	if e.options().ValidateKeys || e.options().SanitizeKeys {
+		if err := ValidateKey(objName); err != nil {
+			return "", err
+		}
+	}
+	return objName, nil
+}
@@ -0,0 +1,272 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"github.com/rs/zerolog"
+)
+
+// LogLevel is the level operations are logged at, see Options.LogLevels
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+	LogLevelOff   LogLevel = "off"
+)
+
+// KeyRedaction is how object keys and prefixes are redacted in log messages
+type KeyRedaction string
+
+const (
+	// RedactNone logs keys as they are
+	RedactNone KeyRedaction = ""
+
+	// RedactHash replaces keys with a short hash, so that messages about the
+	// same key can still be correlated
+	RedactHash KeyRedaction = "hash"
+
+	// RedactTruncate only logs the start of keys, see Options.RedactKeyLength
+	RedactTruncate KeyRedaction = "truncate"
+)
+
+const (
+	DefaultRedactKeyLength = 8
+)
+
+// redactedFields are the log fields that contain object keys or paths
+var redactedFields = map[string]struct{}{
+	"key":    {},
+	"source": {},
+	"prefix": {},
+	"path":   {},
+}
+
+// Logger is the logging interface used by the wrapper, which is satisfied
+// by *slog.Logger. Fields are passed as alternating keys and values.
+type Logger interface {
+	Debug(msg string, fields ...any)
+	Info(msg string, fields ...any)
+	Warn(msg string, fields ...any)
+	Error(msg string, fields ...any)
+}
+
+// NewZerologLogger adapts a zerolog logger to the Logger interface
+func NewZerologLogger(logger *zerolog.Logger) Logger {
+	return &zerologLogger{
+		logger: logger,
+	}
+}
+
+type zerologLogger struct {
+	logger *zerolog.Logger
+}
+
+func (l *zerologLogger) Debug(msg string, fields ...any) {
+	l.logger.Debug().Fields(fields).Msg(msg)
+}
+
+func (l *zerologLogger) Info(msg string, fields ...any) {
+	l.logger.Info().Fields(fields).Msg(msg)
+}
+
+func (l *zerologLogger) Warn(msg string, fields ...any) {
+	l.logger.Warn().Fields(fields).Msg(msg)
+}
+
+func (l *zerologLogger) Error(msg string, fields ...any) {
+	l.logger.Error().Fields(fields).Msg(msg)
+}
+
+// fieldLogger adds fields to every message logged by a Logger
+type fieldLogger struct {
+	logger Logger
+	fields []any
+}
+
+func (l *fieldLogger) Debug(msg string, fields ...any) {
+	l.logger.Debug(msg, append(l.fields[:len(l.fields):len(l.fields)], fields...)...)
+}
+
+func (l *fieldLogger) Info(msg string, fields ...any) {
+	l.logger.Info(msg, append(l.fields[:len(l.fields):len(l.fields)], fields...)...)
+}
+
+func (l *fieldLogger) Warn(msg string, fields ...any) {
+	l.logger.Warn(msg, append(l.fields[:len(l.fields):len(l.fields)], fields...)...)
+}
+
+func (l *fieldLogger) Error(msg string, fields ...any) {
+	l.logger.Error(msg, append(l.fields[:len(l.fields):len(l.fields)], fields...)...)
+}
+
+// redactingLogger redacts object keys and paths in the fields of every message
+type redactingLogger struct {
+	logger    Logger
+	redaction KeyRedaction
+	length    int
+}
+
+func (l *redactingLogger) Debug(msg string, fields ...any) {
+	l.logger.Debug(msg, l.redact(fields)...)
+}
+
+func (l *redactingLogger) Info(msg string, fields ...any) {
+	l.logger.Info(msg, l.redact(fields)...)
+}
+
+func (l *redactingLogger) Warn(msg string, fields ...any) {
+	l.logger.Warn(msg, l.redact(fields)...)
+}
+
+func (l *redactingLogger) Error(msg string, fields ...any) {
+	l.logger.Error(msg, l.redact(fields)...)
+}
+
+func (l *redactingLogger) redact(fields []any) []any {
+	redacted := make([]any, len(fields))
+	copy(redacted, fields)
+	for i := 0; i+1 < len(redacted); i += 2 {
+		name, ok := redacted[i].(string)
+		if !ok {
+			continue
+		}
+		if _, ok = redactedFields[name]; ok {
+			redacted[i+1] = l.redactKey(fmt.Sprint(redacted[i+1]))
+			continue
+		}
+		if err, ok := redacted[i+1].(error); ok && err != nil {
+			redacted[i+1] = l.redactError(err)
+		}
+	}
+	return redacted
+}
+
+// redactError returns the message of err with the keys of the operations
+// and objects it wraps redacted, including the escaped keys in the URLs of
+// failed requests
+func (l *redactingLogger) redactError(err error) string {
+	msg := err.Error()
+	keys := errorKeys(err, nil)
+	// Longer keys are replaced first, as they may contain shorter ones
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+	for _, key := range keys {
+		redacted := l.redactKey(key)
+		msg = strings.ReplaceAll(msg, key, redacted)
+		if escaped := s3utils.EncodePath(key); escaped != key {
+			msg = strings.ReplaceAll(msg, escaped, redacted)
+		}
+	}
+	return msg
+}
+
+// errorKeys appends the object keys in the chain of err to keys
+func errorKeys(err error, keys []string) []string {
+	var key string
+	switch e := err.(type) {
+	case *OperationError:
+		key = e.Key
+	case *KeyError:
+		key = e.Key
+	case minio.ErrorResponse:
+		key = e.Key
+	}
+	if key != "" {
+		keys = append(keys, key)
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := e.Unwrap(); inner != nil {
+			keys = errorKeys(inner, keys)
+		}
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			keys = errorKeys(inner, keys)
+		}
+	}
+	return keys
+}
+
+func (l *redactingLogger) redactKey(key string) string {
+	switch l.redaction {
+	case RedactHash:
+		sum := sha256.Sum256([]byte(key))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case RedactTruncate:
+		if len(key) <= l.length {
+			return key
+		}
+		return key[:l.length] + "..."
+	}
+	return key
+}
+
+// logOperation logs the start of an operation at debug level, or at the
+// level configured for the operation in Options.LogLevels
+func (e *S3) logOperation(name string, msg string, fields ...any) {
+	level, ok := e.options().LogLevels[name]
+	if !ok {
+		level = LogLevelDebug
+	}
+	switch level {
+	case LogLevelOff:
+	case LogLevelInfo:
+		e.logger.Info(msg, fields...)
+	case LogLevelWarn:
+		e.logger.Warn(msg, fields...)
+	case LogLevelError:
+		e.logger.Error(msg, fields...)
+	default:
+		e.logger.Debug(msg, fields...)
+	}
+}
+
+// namedLogger adds the log name to every message logged by a Logger, and
+// discards all messages if the logger is nil
+func namedLogger(logger Logger, logName string) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	if logName == "" {
+		return logger
+	}
+	return &fieldLogger{
+		logger: logger,
+		fields: []any{logName, "S3"},
+	}
+}
+
+// nopLogger discards all messages
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
@@ -0,0 +1,76 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type captureLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *captureLogger) log(msg string, fields ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprint(append([]any{msg}, fields...)...))
+}
+
+func (l *captureLogger) Debug(msg string, fields ...any) { l.log(msg, fields...) }
+func (l *captureLogger) Info(msg string, fields ...any)  { l.log(msg, fields...) }
+func (l *captureLogger) Warn(msg string, fields ...any)  { l.log(msg, fields...) }
+func (l *captureLogger) Error(msg string, fields ...any) { l.log(msg, fields...) }
+
+func TestRedactingLogger(t *testing.T) {
+	key := "private/name with spaces.txt"
+	opErr := &OperationError{
+		Op:     "GetObject",
+		Bucket: "bkt",
+		Key:    key,
+		Err: &url.Error{
+			Op:  "Get",
+			URL: "http://localhost/bkt/" + url.PathEscape("private") + "/name%20with%20spaces.txt",
+			Err: errors.New("connection refused"),
+		},
+	}
+	for _, redaction := range []KeyRedaction{RedactHash, RedactTruncate} {
+		capture := new(captureLogger)
+		logger := &redactingLogger{
+			logger:    capture,
+			redaction: redaction,
+			length:    DefaultRedactKeyLength,
+		}
+		logger.Warn("request failed", "key", key, "error", opErr)
+		logger.Error("failed to move object", "error", fmt.Errorf("failed to delete source object: %w", opErr))
+		logger.Info("invalid key", "error", &KeyError{Key: key, Reason: "too long"})
+
+		for _, msg := range capture.messages {
+			if strings.Contains(msg, "name with spaces") || strings.Contains(msg, "name%20with%20spaces") {
+				t.Fatalf("expected key to be redacted with %s, got %q", redaction, msg)
+			}
+		}
+		if !strings.Contains(capture.messages[0], "connection refused") {
+			t.Fatalf("expected the rest of the error to be logged, got %q", capture.messages[0])
+		}
+	}
+}
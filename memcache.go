@@ -0,0 +1,133 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMemoryCacheMaxBytes = 64 << 20
+	DefaultMemoryCacheTTL      = time.Minute
+)
+
+// MemoryCache is a size-bounded LRU cache of small objects kept in memory.
+// Entries are served without contacting the endpoint until their TTL has
+// passed, after which they are revalidated by ETag.
+type MemoryCache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	used    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	data  []byte
+	entry CacheEntry
+}
+
+// NewMemoryCache creates a MemoryCache holding up to maxBytes of objects
+// for ttl each, using the defaults for values that are zero
+func NewMemoryCache(maxBytes int64, ttl time.Duration) *MemoryCache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMemoryCacheMaxBytes
+	}
+	if ttl <= 0 {
+		ttl = DefaultMemoryCacheTTL
+	}
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryCache) Get(_ context.Context, key string) (io.ReadCloser, CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, CacheEntry{}, false
+	}
+
+	m.lru.MoveToFront(elem)
+	item := elem.Value.(*memoryCacheItem)
+	return io.NopCloser(bytes.NewReader(item.data)), item.entry, true
+}
+
+func (m *MemoryCache) Set(_ context.Context, key string, entry CacheEntry, body io.Reader) error {
+	if entry.Size > m.maxBytes {
+		return ErrCacheEntryTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, m.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > m.maxBytes {
+		return ErrCacheEntryTooLarge
+	}
+	entry.Size = int64(len(data))
+	entry.Expires = time.Now().Add(m.ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryCacheItem{
+		key:   key,
+		data:  data,
+		entry: entry,
+	})
+	m.used += entry.Size
+
+	for m.used > m.maxBytes {
+		m.remove(m.lru.Back())
+	}
+
+	return nil
+}
+
+func (m *MemoryCache) Invalidate(_ context.Context, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+}
+
+// remove must be called with mu held
+func (m *MemoryCache) remove(elem *list.Element) {
+	item := elem.Value.(*memoryCacheItem)
+	m.used -= item.entry.Size
+	m.lru.Remove(elem)
+	delete(m.entries, item.key)
+}
@@ -0,0 +1,98 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// meterObserver records OpenTelemetry metrics for every operation
+type meterObserver struct {
+	duration metric.Float64Histogram
+	size     metric.Int64Histogram
+	errors   metric.Int64Counter
+	retries  metric.Int64Counter
+}
+
+func newMeterObserver(provider metric.MeterProvider) (*meterObserver, error) {
+	meter := provider.Meter(tracerName)
+
+	duration, err := meter.Float64Histogram("s3.client.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("The duration of S3 operations, including retries and reading the body of downloads."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create duration histogram: %w", err)
+	}
+
+	size, err := meter.Int64Histogram("s3.client.operation.size",
+		metric.WithUnit("By"),
+		metric.WithDescription("The number of object bytes uploaded or downloaded by S3 operations."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create size histogram: %w", err)
+	}
+
+	errors, err := meter.Int64Counter("s3.client.operation.errors",
+		metric.WithDescription("The number of failed S3 operations."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error counter: %w", err)
+	}
+
+	retries, err := meter.Int64Counter("s3.client.operation.retries",
+		metric.WithDescription("The number of retried S3 operation attempts."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry counter: %w", err)
+	}
+
+	return &meterObserver{
+		duration: duration,
+		size:     size,
+		errors:   errors,
+		retries:  retries,
+	}, nil
+}
+
+func (o *meterObserver) OperationStarted(ctx context.Context, _ *Operation) context.Context {
+	return ctx
+}
+
+func (o *meterObserver) OperationRetried(ctx context.Context, op *Operation, _ int, _ error) {
+	o.retries.Add(ctx, 1, metric.WithAttributes(attribute.String("s3.operation", op.Name)))
+}
+
+func (o *meterObserver) OperationFinished(ctx context.Context, op *Operation, err error) {
+	operation := attribute.String("s3.operation", op.Name)
+	status := OperationStatus(err)
+	o.duration.Record(ctx, time.Since(op.Start).Seconds(), metric.WithAttributes(operation, attribute.String("s3.status", status)))
+	if op.BytesSent > 0 {
+		o.size.Record(ctx, op.BytesSent, metric.WithAttributes(operation, attribute.String("s3.direction", "upload")))
+	}
+	if op.BytesReceived > 0 {
+		o.size.Record(ctx, op.BytesReceived, metric.WithAttributes(operation, attribute.String("s3.direction", "download")))
+	}
+	if err != nil && status != "not_modified" {
+		o.errors.Add(ctx, 1, metric.WithAttributes(operation, attribute.String("s3.status", status)))
+	}
+}
@@ -0,0 +1,203 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// ObjectOption tunes a single GetObject, PutObject, CopyObject or
+// DeleteObject call. Options that do not apply to an operation are ignored.
+type ObjectOption func(o *objectOptions)
+
+// objectOptions holds the options of the operation an ObjectOption is
+// applied to, only one of which is set
+type objectOptions struct {
+	get    *GetOptions
+	put    *PutOptions
+	copy   *CopyOptions
+	delete *DeleteOptions
+}
+
+// DeleteOptions are the per-call options for DeleteObject
+type DeleteOptions struct {
+	// IfMatch only deletes the object if its current ETag matches the given
+	// one, with or without quotes. The condition is sent with the delete, so
+	// it is atomic on services that support conditional deletes, like AWS S3.
+	// Services that ignore it only get a check made with a separate request
+	// before deleting, which is best-effort against concurrent changes.
+	IfMatch string
+}
+
+// MatchETag returns whether two ETags are equal ignoring their quotes, for
+// Storage implementations
+func MatchETag(a string, b string) bool {
+	return strings.Trim(a, `"`) == strings.Trim(b, `"`)
+}
+
+// WithContentType sets the content type of uploads and copies
+func WithContentType(contentType string) ObjectOption {
+	return func(o *objectOptions) {
+		if o.put != nil {
+			o.put.ContentType = contentType
+		}
+		if o.copy != nil {
+			o.copy.ContentType = contentType
+		}
+	}
+}
+
+// WithMetadata adds user metadata to uploads and copies
+func WithMetadata(metadata map[string]string) ObjectOption {
+	return func(o *objectOptions) {
+		if o.put != nil {
+			o.put.Metadata = mergeMetadata(o.put.Metadata, metadata)
+		}
+		if o.copy != nil {
+			o.copy.Metadata = mergeMetadata(o.copy.Metadata, metadata)
+		}
+	}
+}
+
+// WithSSE sets the server-side encryption objects are stored with, or were
+// stored with for reads. For copies it applies to the source and the copy.
+func WithSSE(encryption encrypt.ServerSide) ObjectOption {
+	return func(o *objectOptions) {
+		if o.get != nil {
+			o.get.Encryption = encryption
+		}
+		if o.put != nil {
+			o.put.Encryption = encryption
+		}
+		if o.copy != nil {
+			o.copy.SourceEncryption = encryption
+			o.copy.Encryption = encryption
+		}
+	}
+}
+
+// WithStorageClass sets the storage class of uploads and copies
+func WithStorageClass(storageClass string) ObjectOption {
+	return func(o *objectOptions) {
+		if o.put != nil {
+			o.put.StorageClass = storageClass
+		}
+		if o.copy != nil {
+			o.copy.StorageClass = storageClass
+		}
+	}
+}
+
+// WithRange only reads the bytes from start to end inclusive, see ByteRange
+func WithRange(start int64, end int64) ObjectOption {
+	return func(o *objectOptions) {
+		if o.get != nil {
+			o.get.Range = &ByteRange{
+				Start: start,
+				End:   end,
+			}
+		}
+	}
+}
+
+// WithVersionID reads a specific version of an object, see GetOptions.VersionID
+func WithVersionID(versionID string) ObjectOption {
+	return func(o *objectOptions) {
+		if o.get != nil {
+			o.get.VersionID = versionID
+		}
+	}
+}
+
+// WithTags adds object tags to uploads
+func WithTags(tags map[string]string) ObjectOption {
+	return func(o *objectOptions) {
+		if o.put != nil {
+			o.put.Tags = mergeMetadata(o.put.Tags, tags)
+		}
+	}
+}
+
+// WithIfMatch only performs the operation if the object's current ETag matches
+// the given one, returning ErrPreconditionFailed otherwise. For copies the
+// ETag of the source is checked.
+func WithIfMatch(etag string) ObjectOption {
+	return func(o *objectOptions) {
+		if o.get != nil {
+			o.get.IfMatch = etag
+		}
+		if o.put != nil {
+			o.put.IfMatch = etag
+		}
+		if o.copy != nil {
+			o.copy.SourceIfMatch = etag
+		}
+		if o.delete != nil {
+			o.delete.IfMatch = etag
+		}
+	}
+}
+
+// ApplyGetOptions returns opts with the given ObjectOptions applied, for
+// Storage implementations
+func ApplyGetOptions(opts GetOptions, options ...ObjectOption) GetOptions {
+	for _, option := range options {
+		option(&objectOptions{get: &opts})
+	}
+	return opts
+}
+
+// ApplyPutOptions returns opts with the given ObjectOptions applied, for
+// Storage implementations
+func ApplyPutOptions(opts PutOptions, options ...ObjectOption) PutOptions {
+	for _, option := range options {
+		option(&objectOptions{put: &opts})
+	}
+	return opts
+}
+
+// ApplyCopyOptions returns opts with the given ObjectOptions applied, for
+// Storage implementations
+func ApplyCopyOptions(opts CopyOptions, options ...ObjectOption) CopyOptions {
+	for _, option := range options {
+		option(&objectOptions{copy: &opts})
+	}
+	return opts
+}
+
+// ApplyDeleteOptions returns opts with the given ObjectOptions applied, for
+// Storage implementations
+func ApplyDeleteOptions(opts DeleteOptions, options ...ObjectOption) DeleteOptions {
+	for _, option := range options {
+		option(&objectOptions{delete: &opts})
+	}
+	return opts
+}
+
+// mergeMetadata returns a copy of metadata with the entries of add added
+func mergeMetadata(metadata map[string]string, add map[string]string) map[string]string {
+	merged := make(map[string]string, len(metadata)+len(add))
+	for name, value := range metadata {
+		merged[name] = value
+	}
+	for name, value := range add {
+		merged[name] = value
+	}
+	return merged
+}
@@ -0,0 +1,231 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Operation is a single call to the S3 wrapper, as reported to every Observer
+type Operation struct {
+	// Name is the name of the operation, such as "GetObject" or "PutObject"
+	Name   string
+	Bucket string
+	Key    string
+	Start  time.Time
+
+	// Source is the source object of a copy, and Keys are the objects of batch
+	// operations such as deleting a prefix, where Key is empty
+	Source string
+	Keys   []string
+
+	// BytesSent and BytesReceived are the object bytes uploaded and downloaded
+	// by the operation, which for downloads are only final once it has finished
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// Observer is notified about every operation, for example to record metrics
+// (see pkg/metrics). Observers are called synchronously and must be safe for
+// concurrent use.
+type Observer interface {
+	// OperationStarted is called before an operation is sent and returns the
+	// context used for the rest of the operation
+	OperationStarted(ctx context.Context, op *Operation) context.Context
+
+	// OperationRetried is called before an operation is retried after the
+	// given failed attempt
+	OperationRetried(ctx context.Context, op *Operation, attempt int, err error)
+
+	// OperationFinished is called once an operation has completed, which for
+	// reads returning a body is when the body is closed
+	OperationFinished(ctx context.Context, op *Operation, err error)
+}
+
+// OperationStatus returns a short, low-cardinality status for the result of an
+// operation, such as "ok", "timeout" or the S3 error code, for use in metrics
+func OperationStatus(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotModified):
+		return "not_modified"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Code != "" {
+		return errResp.Code
+	}
+	return "error"
+}
+
+// slowObserver logs a warning for operations that take longer than the threshold
+type slowObserver struct {
+	threshold time.Duration
+	logger    Logger
+}
+
+func (o *slowObserver) OperationStarted(ctx context.Context, _ *Operation) context.Context {
+	return ctx
+}
+
+func (o *slowObserver) OperationRetried(context.Context, *Operation, int, error) {}
+
+func (o *slowObserver) OperationFinished(_ context.Context, op *Operation, err error) {
+	duration := time.Since(op.Start)
+	if duration < o.threshold {
+		return
+	}
+	fields := []any{
+		"operation", op.Name,
+		"bucket", op.Bucket,
+		"key", op.Key,
+		"size", op.BytesSent + op.BytesReceived,
+		"duration", duration,
+	}
+	if err != nil {
+		fields = append(fields, "error", err)
+	}
+	o.logger.Warn("slow s3 operation", fields...)
+}
+
+type operationKey struct{}
+
+// operation is an operation in progress
+type operation struct {
+	Operation
+	e         *S3
+	observers []Observer
+	ctx       context.Context
+	once      sync.Once
+}
+
+// startOperation notifies the observers about a new operation, the returned
+// context must be used for the operation so that retries are reported
+func (e *S3) startOperation(ctx context.Context, name string, bucket string, key string) (context.Context, *operation) {
+	return e.startOperationWith(ctx, Operation{
+		Name:   name,
+		Bucket: bucket,
+		Key:    key,
+	})
+}
+
+// startOperationWith is startOperation for operations with further details
+func (e *S3) startOperationWith(ctx context.Context, details Operation) (context.Context, *operation) {
+	details.Start = time.Now()
+	op := &operation{
+		Operation: details,
+		e:         e,
+		observers: e.observers,
+	}
+	for _, observer := range op.observers {
+		ctx = observer.OperationStarted(ctx, &op.Operation)
+	}
+	op.ctx = context.WithValue(ctx, operationKey{}, op)
+	return op.ctx, op
+}
+
+// retried reports a failed attempt of the operation in ctx, if there is one
+func retried(ctx context.Context, attempt int, err error) {
+	op, ok := ctx.Value(operationKey{}).(*operation)
+	if !ok {
+		return
+	}
+	for _, observer := range op.observers {
+		observer.OperationRetried(op.ctx, &op.Operation, attempt, err)
+	}
+}
+
+// finish reports the result of the operation and returns err wrapped by
+// wrap, only the first call notifies the observers
+func (o *operation) finish(err error) error {
+	err = o.wrap(err)
+	o.once.Do(func() {
+		if requestID, hostID, ok := RequestIDs(err); ok {
+			o.e.logger.Debug("s3 request failed", "operation", o.Name, "key", o.Key, "request_id", requestID, "host_id", hostID, "error", err)
+		}
+		for _, observer := range o.observers {
+			observer.OperationFinished(o.ctx, &o.Operation, err)
+		}
+	})
+	return err
+}
+
+// wrap maps err to its sentinel error, adds the request IDs of failed
+// requests and wraps it in an OperationError for the operation
+func (o *operation) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	return &OperationError{
+		Op:     o.Name,
+		Bucket: o.Bucket,
+		Key:    o.Key,
+		Err:    wrapError(err),
+	}
+}
+
+// finishOnClose counts the bytes read from body and finishes the operation
+// once it is closed, with the first read error other than io.EOF
+func (o *operation) finishOnClose(body io.ReadCloser) io.ReadCloser {
+	return &observedReadCloser{
+		ReadCloser: body,
+		op:         o,
+	}
+}
+
+type observedReadCloser struct {
+	io.ReadCloser
+	op  *operation
+	err error
+}
+
+func (r *observedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.op.BytesReceived += int64(n)
+	if err != nil && err != io.EOF {
+		err = r.op.wrap(err)
+		if r.err == nil {
+			r.err = err
+		}
+	}
+	return n, err
+}
+
+func (r *observedReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err != nil {
+		_ = r.op.finish(r.err)
+	} else {
+		err = r.op.finish(err)
+	}
+	return err
+}
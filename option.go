@@ -0,0 +1,170 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"net/http"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Option modifies the Options passed to New, or the default Options of NewClient
+type Option func(options *Options)
+
+// WithLogger sets the logger that receives the client's logs
+func WithLogger(logger Logger) Option {
+	return func(options *Options) {
+		options.Logger = logger
+	}
+}
+
+// WithLogName adds the given name as a field to every log message
+func WithLogName(logName string) Option {
+	return func(options *Options) {
+		options.LogName = logName
+	}
+}
+
+// WithSecure sets whether requests are sent over HTTPS
+func WithSecure(secure bool) Option {
+	return func(options *Options) {
+		options.Secure = secure
+	}
+}
+
+// WithRegion sets the region of the bucket, which is looked up on first use
+// if it is empty
+func WithRegion(region string) Option {
+	return func(options *Options) {
+		options.Region = region
+	}
+}
+
+// WithBucketLookup sets whether the bucket is in the host name or the path
+// of request URLs
+func WithBucketLookup(lookup BucketLookup) Option {
+	return func(options *Options) {
+		options.BucketLookup = lookup
+	}
+}
+
+// WithCredentials signs requests with a static access key and secret key
+func WithCredentials(accessKey string, secretKey string) Option {
+	return func(options *Options) {
+		options.AccessKey = accessKey
+		options.SecretKey = secretKey
+		options.Credentials = nil
+	}
+}
+
+// WithSecretFiles reads the access key and secret key from files, and reads
+// them again when they change if watch is set
+func WithSecretFiles(accessKeyFile string, secretKeyFile string, watch bool) Option {
+	return func(options *Options) {
+		options.AccessKeyFile = accessKeyFile
+		options.SecretKeyFile = secretKeyFile
+		options.WatchSecretFiles = watch
+		options.Credentials = nil
+	}
+}
+
+// WithAnonymous sends unsigned requests, for reading public buckets
+func WithAnonymous() Option {
+	return func(options *Options) {
+		options.CredentialsSource = CredentialsAnonymous
+		options.Credentials = nil
+	}
+}
+
+// WithSignatureV2 signs requests with Signature V2 instead of V4
+func WithSignatureV2() Option {
+	return func(options *Options) {
+		options.SignatureV2 = true
+	}
+}
+
+// WithSessionToken sets the session token of temporary static credentials
+func WithSessionToken(sessionToken string) Option {
+	return func(options *Options) {
+		options.SessionToken = sessionToken
+	}
+}
+
+// WithCredentialsProvider signs requests with the credentials returned by
+// provider, which are fetched again whenever they expire
+func WithCredentialsProvider(provider credentials.Provider) Option {
+	return func(options *Options) {
+		options.Credentials = credentials.New(provider)
+	}
+}
+
+// WithRetry sets how failed operations are retried
+func WithRetry(policy RetryPolicy) Option {
+	return func(options *Options) {
+		options.Retry = policy
+	}
+}
+
+// WithTimeouts sets the default timeouts of operations
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(options *Options) {
+		options.Timeouts = timeouts
+	}
+}
+
+// WithFailover sets the secondary endpoints that requests fail over to, in order
+func WithFailover(endpoints ...string) Option {
+	return func(options *Options) {
+		options.FailoverEndpoints = endpoints
+	}
+}
+
+// WithReadEndpoints sets other endpoints serving the same bucket that reads
+// are routed to
+func WithReadEndpoints(endpoints ...string) Option {
+	return func(options *Options) {
+		options.ReadEndpoints = endpoints
+	}
+}
+
+// WithDefaultStorageClass sets the storage class of uploads that do not specify one
+func WithDefaultStorageClass(storageClass string) Option {
+	return func(options *Options) {
+		options.StorageClass = storageClass
+	}
+}
+
+// WithNamespace prepends namespace to the names of all objects used by the client
+func WithNamespace(namespace string) Option {
+	return func(options *Options) {
+		options.Namespace = namespace
+	}
+}
+
+// WithObserver adds an Observer that is notified about every operation
+func WithObserver(observer Observer) Option {
+	return func(options *Options) {
+		options.Observers = append(options.Observers[:len(options.Observers):len(options.Observers)], observer)
+	}
+}
+
+// WithTransport sets the transport used for all requests to the endpoint
+func WithTransport(transport http.RoundTripper) Option {
+	return func(options *Options) {
+		options.Transport = transport
+	}
+}
@@ -0,0 +1,160 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package cas provides content-addressable storage on top of S3.
+//
+// Blobs are stored under "blobs/<sha256>" in the configured prefix, are only
+// written if they do not exist yet, and are verified against their digest
+// whenever they are read.
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/loopholelabs/s3"
+)
+
+var (
+	ErrInvalidDigest  = errors.New("invalid digest")
+	ErrDigestMismatch = errors.New("blob content does not match its digest")
+)
+
+const (
+	ContentType = "application/octet-stream"
+	blobsPrefix = "blobs/"
+)
+
+// Store is a content-addressable blob store
+type Store struct {
+	client s3.Storage
+	prefix string
+}
+
+// New returns a Store that keeps its blobs under the given prefix
+func New(client s3.Storage, prefix string) *Store {
+	return &Store{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Digest returns the digest of the given data
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores data if a blob with the same content does not exist yet,
+// returning its digest
+func (s *Store) Put(ctx context.Context, data []byte) (string, error) {
+	digest := Digest(data)
+	_, err := s.client.PutObjectIfAbsent(ctx, s.prefix, blobKey(digest), bytes.NewReader(data), int64(len(data)), ContentType)
+	if err != nil && !errors.Is(err, s3.ErrObjectExists) {
+		return "", fmt.Errorf("failed to put blob: %w", err)
+	}
+	return digest, nil
+}
+
+// Get returns the content of the blob with the given digest, returning
+// ErrDigestMismatch if the stored content has been tampered with
+func (s *Store) Get(ctx context.Context, digest string) ([]byte, error) {
+	r, err := s.Open(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Open returns a reader for the blob with the given digest. The content is
+// verified as it is read, and Read returns ErrDigestMismatch instead of io.EOF
+// if it does not match the digest.
+func (s *Store) Open(ctx context.Context, digest string) (io.ReadCloser, error) {
+	if err := validateDigest(digest); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.client.GetObject(ctx, s.prefix, blobKey(digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+
+	return &verifier{
+		ReadCloser: obj,
+		hasher:     sha256.New(),
+		digest:     digest,
+	}, nil
+}
+
+// Exists returns whether a blob with the given digest is stored
+func (s *Store) Exists(ctx context.Context, digest string) (bool, error) {
+	if err := validateDigest(digest); err != nil {
+		return false, err
+	}
+
+	_, err := s.client.StatObject(ctx, s.prefix, blobKey(digest))
+	if err != nil {
+		if errors.Is(err, s3.ErrObjectNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	return true, nil
+}
+
+// Delete removes the blob with the given digest
+func (s *Store) Delete(ctx context.Context, digest string) error {
+	if err := validateDigest(digest); err != nil {
+		return err
+	}
+	return s.client.DeleteObject(ctx, s.prefix, blobKey(digest))
+}
+
+func blobKey(digest string) string {
+	return blobsPrefix + digest
+}
+
+func validateDigest(digest string) error {
+	if len(digest) != sha256.Size*2 {
+		return ErrInvalidDigest
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return ErrInvalidDigest
+	}
+	return nil
+}
+
+type verifier struct {
+	io.ReadCloser
+	hasher hash.Hash
+	digest string
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hasher.Write(p[:n])
+	if errors.Is(err, io.EOF) && hex.EncodeToString(v.hasher.Sum(nil)) != v.digest {
+		return n, ErrDigestMismatch
+	}
+	return n, err
+}
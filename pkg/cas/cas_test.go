@@ -0,0 +1,120 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package cas
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3mem"
+)
+
+func TestPutGet(t *testing.T) {
+	client := s3mem.New("bucket")
+	store := New(client, "artifacts")
+	data := []byte("hello world")
+
+	digest, err := store.Put(context.Background(), data)
+	if err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+	if digest != Digest(data) {
+		t.Fatalf("expected digest %s, got %s", Digest(data), digest)
+	}
+	if _, err = client.StatObject(context.Background(), "artifacts", "blobs/"+digest); err != nil {
+		t.Fatalf("expected the blob under its digest: %v", err)
+	}
+
+	// Storing the same content again is deduplicated
+	again, err := store.Put(context.Background(), bytes.Clone(data))
+	if err != nil {
+		t.Fatalf("failed to put blob again: %v", err)
+	}
+	if again != digest {
+		t.Fatalf("expected the same digest, got %s and %s", digest, again)
+	}
+
+	read, err := store.Get(context.Background(), digest)
+	if err != nil {
+		t.Fatalf("failed to get blob: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("expected %q, got %q", data, read)
+	}
+
+	exists, err := store.Exists(context.Background(), digest)
+	if err != nil || !exists {
+		t.Fatalf("expected the blob to exist, got %t and %v", exists, err)
+	}
+	if err = store.Delete(context.Background(), digest); err != nil {
+		t.Fatalf("failed to delete blob: %v", err)
+	}
+	if exists, err = store.Exists(context.Background(), digest); err != nil || exists {
+		t.Fatalf("expected the blob to be deleted, got %t and %v", exists, err)
+	}
+	if _, err = store.Get(context.Background(), digest); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound for a deleted blob, got %v", err)
+	}
+}
+
+func TestTamperedBlob(t *testing.T) {
+	client := s3mem.New("bucket")
+	store := New(client, "artifacts")
+	digest, err := store.Put(context.Background(), []byte("hello world"))
+	if err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+
+	tampered := []byte("hello there")
+	if _, err = client.PutObject(context.Background(), "artifacts", "blobs/"+digest, bytes.NewReader(tampered), int64(len(tampered)), ContentType); err != nil {
+		t.Fatalf("failed to overwrite blob: %v", err)
+	}
+
+	if _, err = store.Get(context.Background(), digest); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch for a tampered blob, got %v", err)
+	}
+
+	// Open only reports the mismatch once the whole blob has been read
+	r, err := store.Open(context.Background(), digest)
+	if err != nil {
+		t.Fatalf("failed to open blob: %v", err)
+	}
+	defer r.Close()
+	if _, err = io.ReadAll(r); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch at the end of a tampered blob, got %v", err)
+	}
+}
+
+func TestInvalidDigest(t *testing.T) {
+	store := New(s3mem.New("bucket"), "artifacts")
+	for _, digest := range []string{"", "abc", strings.Repeat("z", 64), "../" + strings.Repeat("a", 61)} {
+		if _, err := store.Get(context.Background(), digest); !errors.Is(err, ErrInvalidDigest) {
+			t.Fatalf("expected ErrInvalidDigest from Get for %q, got %v", digest, err)
+		}
+		if _, err := store.Exists(context.Background(), digest); !errors.Is(err, ErrInvalidDigest) {
+			t.Fatalf("expected ErrInvalidDigest from Exists for %q, got %v", digest, err)
+		}
+		if err := store.Delete(context.Background(), digest); !errors.Is(err, ErrInvalidDigest) {
+			t.Fatalf("expected ErrInvalidDigest from Delete for %q, got %v", digest, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package codec provides s3.Codec implementations that depend on
+// third-party serialization libraries.
+package codec
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrNotProtoMessage = errors.New("value is not a proto.Message")
+)
+
+const (
+	ProtobufContentType = "application/x-protobuf"
+	MsgpackContentType  = "application/msgpack"
+)
+
+// Protobuf encodes values using protocol buffers. Values passed to PutTyped
+// must implement proto.Message, and GetTyped must be instantiated with a
+// pointer to a generated message type.
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string { return ProtobufContentType }
+
+func (Protobuf) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal decodes into v, which GetTyped passes as a pointer to its T.
+// When T is itself a message pointer a new message is allocated for it.
+func (Protobuf) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+		return ErrNotProtoMessage
+	}
+	msg := reflect.New(rv.Elem().Type().Elem())
+	m, ok := msg.Interface().(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return err
+	}
+	rv.Elem().Set(msg)
+	return nil
+}
+
+// Msgpack encodes values using MessagePack
+type Msgpack struct{}
+
+func (Msgpack) ContentType() string { return MsgpackContentType }
+
+func (Msgpack) Marshal(v any) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (Msgpack) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
@@ -18,8 +18,13 @@ package config
 
 import (
 	"errors"
-	"github.com/loopholelabs/s3"
+	"time"
+
+	"github.com/rs/zerolog"
 	"github.com/spf13/pflag"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3fsbackend"
 )
 
 var (
@@ -28,12 +33,19 @@ var (
 	ErrBucketRequired    = errors.New("bucket is required")
 	ErrAccessKeyRequired = errors.New("access key is required")
 	ErrSecretKeyRequired = errors.New("secret key is required")
+	ErrRoleARNRequired   = errors.New("role arn is required")
+	ErrInvalidEndpoint   = errors.New("invalid endpoint")
+	ErrInvalidBucket     = errors.New("invalid bucket")
+	ErrInvalidRegion     = errors.New("invalid region")
+	ErrInvalidNetwork    = errors.New("invalid network")
 )
 
 const (
 	DefaultDisabled = false
 	DefaultSecure   = true
 	DefaultRegion   = "auto"
+
+	DefaultFlagPrefix = "s3"
 )
 
 type Config struct {
@@ -44,6 +56,116 @@ type Config struct {
 	Bucket    string `mapstructure:"bucket"`
 	AccessKey string `mapstructure:"access_key"`
 	SecretKey string `mapstructure:"secret_key"`
+
+	BucketLookup string `mapstructure:"bucket_lookup"`
+	Provider     string `mapstructure:"provider"`
+
+	SessionToken string `mapstructure:"session_token"`
+	SignatureV2  bool   `mapstructure:"signature_v2"`
+
+	AccessKeyFile    string `mapstructure:"access_key_file"`
+	SecretKeyFile    string `mapstructure:"secret_key_file"`
+	WatchSecretFiles bool   `mapstructure:"watch_secret_files"`
+
+	CredentialsSource string `mapstructure:"credentials_source"`
+	IAMEndpoint       string `mapstructure:"iam_endpoint"`
+
+	AssumeRoleARN         string        `mapstructure:"assume_role_arn"`
+	AssumeRoleExternalID  string        `mapstructure:"assume_role_external_id"`
+	AssumeRoleSessionName string        `mapstructure:"assume_role_session_name"`
+	AssumeRoleDuration    time.Duration `mapstructure:"assume_role_duration"`
+	STSEndpoint           string        `mapstructure:"sts_endpoint"`
+
+	WebIdentityTokenFile string `mapstructure:"web_identity_token_file"`
+
+	SharedCredentialsFile    string `mapstructure:"shared_credentials_file"`
+	SharedCredentialsProfile string `mapstructure:"shared_credentials_profile"`
+
+	StorageClass string `mapstructure:"storage_class"`
+
+	KMSKeyID   string            `mapstructure:"kms_key_id"`
+	KMSContext map[string]string `mapstructure:"kms_context"`
+
+	ReaperInterval time.Duration `mapstructure:"reaper_interval"`
+	ReaperPrefix   string        `mapstructure:"reaper_prefix"`
+
+	DiskCacheDir      string `mapstructure:"disk_cache_dir"`
+	DiskCacheMaxBytes int64  `mapstructure:"disk_cache_max_bytes"`
+
+	MemoryCacheMaxBytes int64         `mapstructure:"memory_cache_max_bytes"`
+	MemoryCacheTTL      time.Duration `mapstructure:"memory_cache_ttl"`
+
+	ReadRangeSize  int64 `mapstructure:"read_range_size"`
+	PrefetchWindow int   `mapstructure:"prefetch_window"`
+
+	PartSize          uint64 `mapstructure:"part_size"`
+	UploadConcurrency uint   `mapstructure:"upload_concurrency"`
+
+	PresignExpiry time.Duration `mapstructure:"presign_expiry"`
+
+	RateLimit float64 `mapstructure:"rate_limit"`
+	RateBurst int     `mapstructure:"rate_burst"`
+
+	RetryMaxAttempts    int           `mapstructure:"retry_max_attempts"`
+	RetryInitialBackoff time.Duration `mapstructure:"retry_initial_backoff"`
+	RetryMaxBackoff     time.Duration `mapstructure:"retry_max_backoff"`
+	RetryJitter         float64       `mapstructure:"retry_jitter"`
+
+	ReadTimeout    time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout   time.Duration `mapstructure:"write_timeout"`
+	ListTimeout    time.Duration `mapstructure:"list_timeout"`
+	PresignTimeout time.Duration `mapstructure:"presign_timeout"`
+
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+	HealthCheckTimeout  time.Duration `mapstructure:"health_check_timeout"`
+
+	FailoverEndpoints []string      `mapstructure:"failover_endpoints"`
+	FailbackInterval  time.Duration `mapstructure:"failback_interval"`
+	ReadEndpoints     []string      `mapstructure:"read_endpoints"`
+
+	ProxyURL string `mapstructure:"proxy_url"`
+	NoProxy  string `mapstructure:"no_proxy"`
+
+	CACertFile         string `mapstructure:"ca_cert_file"`
+	ClientCertFile     string `mapstructure:"client_cert_file"`
+	ClientKeyFile      string `mapstructure:"client_key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+
+	MaxIdleConns        int           `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `mapstructure:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `mapstructure:"idle_conn_timeout"`
+
+	DialTimeout           time.Duration `mapstructure:"dial_timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+
+	DualStack bool   `mapstructure:"dual_stack"`
+	Network   string `mapstructure:"network"`
+	DNSServer string `mapstructure:"dns_server"`
+
+	TraceRequests bool `mapstructure:"trace_requests"`
+
+	RedactKeys      string            `mapstructure:"redact_keys"`
+	RedactKeyLength int               `mapstructure:"redact_key_length"`
+	LogLevels       map[string]string `mapstructure:"log_levels"`
+
+	SlowOperationThreshold time.Duration `mapstructure:"slow_operation_threshold"`
+
+	LazyGetObject bool `mapstructure:"lazy_get_object"`
+
+	KeyDelimiter     string `mapstructure:"key_delimiter"`
+	DisablePrefixing bool   `mapstructure:"disable_prefixing"`
+	LegacyKeyJoin    bool   `mapstructure:"legacy_key_join"`
+
+	ValidateKeys bool `mapstructure:"validate_keys"`
+	SanitizeKeys bool `mapstructure:"sanitize_keys"`
+
+	Namespace string `mapstructure:"namespace"`
+
+	PublicBaseURL string `mapstructure:"public_base_url"`
+
+	// flagPrefix is the prefix the flags of the config were registered with
+	flagPrefix string
 }
 
 func New() *Config {
@@ -60,45 +182,306 @@ func (c *Config) Validate() error {
 			return ErrEndpointRequired
 		}
 
+		if c.Bucket == "" {
+			return ErrBucketRequired
+		}
+
+		// File endpoints are local directories, so they need no credentials
+		if s3fsbackend.IsEndpoint(c.Endpoint) {
+			return nil
+		}
+
+		if err := validateEndpoint(c.Endpoint); err != nil {
+			return err
+		}
+
+		for _, endpoint := range c.FailoverEndpoints {
+			if err := validateEndpoint(endpoint); err != nil {
+				return err
+			}
+		}
+
+		for _, endpoint := range c.ReadEndpoints {
+			if err := validateEndpoint(endpoint); err != nil {
+				return err
+			}
+		}
+
+		if err := validateBucket(c.Bucket); err != nil {
+			return err
+		}
+
 		if c.Region == "" {
 			return ErrRegionRequired
 		}
 
-		if c.Bucket == "" {
-			return ErrBucketRequired
+		if err := validateRegion(c.Region); err != nil {
+			return err
 		}
 
-		if c.AccessKey == "" {
-			return ErrAccessKeyRequired
+		if err := validateNetwork(c.Network); err != nil {
+			return err
 		}
 
-		if c.SecretKey == "" {
-			return ErrSecretKeyRequired
+		if s3.CredentialsSource(c.CredentialsSource) == s3.CredentialsAssumeRole && c.AssumeRoleARN == "" {
+			return ErrRoleARNRequired
+		}
+
+		if c.staticCredentials() {
+			if c.AccessKey == "" && c.AccessKeyFile == "" {
+				return ErrAccessKeyRequired
+			}
+
+			if c.SecretKey == "" && c.SecretKeyFile == "" {
+				return ErrSecretKeyRequired
+			}
 		}
 	}
 
 	return nil
 }
 
+// staticCredentials returns whether the access key and secret key in the
+// config are needed to sign requests or assume a role
+func (c *Config) staticCredentials() bool {
+	switch s3.CredentialsSource(c.CredentialsSource) {
+	case "", s3.CredentialsStatic, s3.CredentialsAssumeRole:
+		return true
+	}
+	return false
+}
+
 func (c *Config) RootPersistentFlags(flags *pflag.FlagSet) {
-	flags.BoolVar(&c.Disabled, "s3-disabled", DefaultDisabled, "Disable s3")
-	flags.StringVar(&c.Endpoint, "s3-endpoint", "", "The s3 endpoint")
-	flags.BoolVar(&c.Secure, "s3-secure", DefaultSecure, "The s3 secure flag")
-	flags.StringVar(&c.Region, "s3-region", DefaultRegion, "The s3 region")
-	flags.StringVar(&c.Bucket, "s3-bucket", "", "The s3 bucket to use")
-	flags.StringVar(&c.AccessKey, "s3-access-key", "", "The s3 access key")
-	flags.StringVar(&c.SecretKey, "s3-secret-key", "", "The s3 secret key")
+	c.RootPersistentFlagsWithPrefix(flags, DefaultFlagPrefix)
+}
+
+// RootPersistentFlagsWithPrefix registers the flags of the config named with
+// prefix instead of DefaultFlagPrefix (for example --backup-endpoint for the
+// prefix "backup"), so that several configs can share a FlagSet
+func (c *Config) RootPersistentFlagsWithPrefix(flags *pflag.FlagSet, prefix string) {
+	c.flagPrefix = prefix
+	flags.BoolVar(&c.Disabled, prefix+"-disabled", DefaultDisabled, "Disable s3")
+	flags.StringVar(&c.Endpoint, prefix+"-endpoint", "", "The s3 endpoint, or a file:// URL of a local directory to store objects in")
+	flags.BoolVar(&c.Secure, prefix+"-secure", DefaultSecure, "The s3 secure flag")
+	flags.StringVar(&c.Region, prefix+"-region", DefaultRegion, "The s3 region")
+	flags.StringVar(&c.Bucket, prefix+"-bucket", "", "The s3 bucket to use")
+	flags.StringVar(&c.BucketLookup, prefix+"-bucket-lookup", "", "How the s3 bucket is addressed ('auto', 'path' or 'dns'), defaults to auto")
+	flags.StringVar(&c.Provider, prefix+"-provider", "", "The provider of the s3 endpoint whose quirks are handled ('generic', 'r2' or 'gcs'), defaults to generic")
+	flags.StringVar(&c.AccessKey, prefix+"-access-key", "", "The s3 access key")
+	flags.StringVar(&c.SecretKey, prefix+"-secret-key", "", "The s3 secret key")
+	flags.StringVar(&c.SessionToken, prefix+"-session-token", "", "The s3 session token, for temporary credentials")
+	flags.StringVar(&c.AccessKeyFile, prefix+"-access-key-file", "", "A file the s3 access key is read from")
+	flags.StringVar(&c.SecretKeyFile, prefix+"-secret-key-file", "", "A file the s3 secret key is read from")
+	flags.BoolVar(&c.WatchSecretFiles, prefix+"-watch-secret-files", false, "Read the s3 access and secret key files again when they change")
+	flags.BoolVar(&c.SignatureV2, prefix+"-signature-v2", false, "Sign s3 requests with signature v2 for endpoints that do not support v4")
+	flags.StringVar(&c.CredentialsSource, prefix+"-credentials-source", "", "Where s3 credentials come from ('static', 'env', 'file', 'chain', 'iam', 'assume_role', 'web_identity' or 'anonymous'), defaults to the access and secret key")
+	flags.StringVar(&c.IAMEndpoint, prefix+"-iam-endpoint", "", "The instance metadata endpoint used for iam s3 credentials")
+	flags.StringVar(&c.AssumeRoleARN, prefix+"-assume-role-arn", "", "The ARN of the role assumed with assume_role s3 credentials")
+	flags.StringVar(&c.AssumeRoleExternalID, prefix+"-assume-role-external-id", "", "The external ID passed when assuming the s3 role")
+	flags.StringVar(&c.AssumeRoleSessionName, prefix+"-assume-role-session-name", "", "The session name used when assuming the s3 role")
+	flags.DurationVar(&c.AssumeRoleDuration, prefix+"-assume-role-duration", 0, "How long assumed s3 role sessions last, one hour if zero")
+	flags.StringVar(&c.WebIdentityTokenFile, prefix+"-web-identity-token-file", "", "The token file used for web_identity s3 credentials, defaults to $"+s3.WebIdentityTokenFileEnv)
+	flags.StringVar(&c.SharedCredentialsFile, prefix+"-shared-credentials-file", "", "The AWS credentials file used for file s3 credentials, defaults to ~/.aws/credentials")
+	flags.StringVar(&c.SharedCredentialsProfile, prefix+"-shared-credentials-profile", "", "The profile read from the AWS credentials file, defaults to $AWS_PROFILE or 'default'")
+	flags.StringVar(&c.STSEndpoint, prefix+"-sts-endpoint", "", "The STS endpoint used to assume the s3 role, defaults to "+s3.DefaultSTSEndpoint)
+	flags.StringVar(&c.StorageClass, prefix+"-storage-class", "", "The default s3 storage class for uploads")
+	flags.StringVar(&c.KMSKeyID, prefix+"-kms-key-id", "", "The s3 SSE-KMS key ID used to encrypt uploads by default")
+	flags.StringToStringVar(&c.KMSContext, prefix+"-kms-context", nil, "The s3 SSE-KMS encryption context")
+	flags.DurationVar(&c.ReaperInterval, prefix+"-reaper-interval", 0, "The interval at which expired s3 objects are deleted, disabled if zero")
+	flags.StringVar(&c.ReaperPrefix, prefix+"-reaper-prefix", "", "The s3 prefix the expired object reaper is limited to")
+	flags.StringVar(&c.DiskCacheDir, prefix+"-disk-cache-dir", "", "The directory to cache s3 objects in, disabled if empty")
+	flags.Int64Var(&c.DiskCacheMaxBytes, prefix+"-disk-cache-max-bytes", s3.DefaultDiskCacheMaxBytes, "The maximum size of the s3 disk cache in bytes")
+	flags.Int64Var(&c.MemoryCacheMaxBytes, prefix+"-memory-cache-max-bytes", 0, "The maximum size of the s3 memory cache in bytes, disabled if zero")
+	flags.DurationVar(&c.MemoryCacheTTL, prefix+"-memory-cache-ttl", s3.DefaultMemoryCacheTTL, "The duration s3 objects are served from the memory cache before being revalidated")
+	flags.Int64Var(&c.ReadRangeSize, prefix+"-read-range-size", s3.DefaultReadRangeSize, "The size of the ranges requested when reading s3 objects with random access")
+	flags.IntVar(&c.PrefetchWindow, prefix+"-prefetch-window", 0, "The number of ranges prefetched while reading s3 objects sequentially")
+	flags.Uint64Var(&c.PartSize, prefix+"-part-size", 0, "The size of the parts of s3 multipart uploads in bytes, chosen from the object size if zero")
+	flags.UintVar(&c.UploadConcurrency, prefix+"-upload-concurrency", 0, "The number of parts of an s3 upload sent at once (0 uses the default)")
+	flags.DurationVar(&c.PresignExpiry, prefix+"-presign-expiry", s3.DefaultPresignExpiry, "How long presigned s3 URLs are valid for by default")
+	flags.Float64Var(&c.RateLimit, prefix+"-rate-limit", 0, "The maximum number of s3 requests per second, disabled if zero")
+	flags.IntVar(&c.RateBurst, prefix+"-rate-burst", 0, "The maximum burst of s3 requests allowed by the rate limit")
+	flags.IntVar(&c.RetryMaxAttempts, prefix+"-retry-max-attempts", 0, "The maximum number of attempts for failed s3 operations, retries are disabled if zero or one")
+	flags.DurationVar(&c.RetryInitialBackoff, prefix+"-retry-initial-backoff", s3.DefaultRetryInitialBackoff, "The delay before retrying a failed s3 operation for the first time")
+	flags.DurationVar(&c.RetryMaxBackoff, prefix+"-retry-max-backoff", s3.DefaultRetryMaxBackoff, "The maximum delay between retries of failed s3 operations")
+	flags.Float64Var(&c.RetryJitter, prefix+"-retry-jitter", 0, "The fraction by which s3 retry delays are randomized")
+	flags.DurationVar(&c.ReadTimeout, prefix+"-read-timeout", 0, "The default timeout for s3 reads, disabled if zero")
+	flags.DurationVar(&c.WriteTimeout, prefix+"-write-timeout", 0, "The default timeout for s3 writes, disabled if zero")
+	flags.DurationVar(&c.ListTimeout, prefix+"-list-timeout", 0, "The default timeout for s3 listings, disabled if zero")
+	flags.DurationVar(&c.PresignTimeout, prefix+"-presign-timeout", 0, "The default timeout for presigning s3 URLs, disabled if zero")
+	flags.DurationVar(&c.HealthCheckInterval, prefix+"-health-check-interval", 0, "The interval at which the s3 endpoint is health checked, disabled if zero")
+	flags.DurationVar(&c.HealthCheckTimeout, prefix+"-health-check-timeout", 0, "The timeout for s3 health checks, defaults to the interval")
+	flags.StringSliceVar(&c.FailoverEndpoints, prefix+"-failover-endpoints", nil, "The secondary s3 endpoints that requests fail over to, in order")
+	flags.DurationVar(&c.FailbackInterval, prefix+"-failback-interval", 0, "The interval at which the primary s3 endpoint is checked while failed over (0 uses the default)")
+	flags.StringSliceVar(&c.ReadEndpoints, prefix+"-read-endpoints", nil, "Other s3 endpoints serving the same bucket that reads are routed to")
+	flags.StringVar(&c.ProxyURL, prefix+"-proxy-url", "", "The HTTP proxy to send s3 requests through")
+	flags.StringVar(&c.NoProxy, prefix+"-no-proxy", "", "A comma-separated list of hosts that bypass the s3 proxy")
+	flags.StringVar(&c.CACertFile, prefix+"-ca-cert-file", "", "A PEM file with additional CA certificates to trust for s3")
+	flags.StringVar(&c.ClientCertFile, prefix+"-client-cert-file", "", "The client certificate used for s3 mTLS")
+	flags.StringVar(&c.ClientKeyFile, prefix+"-client-key-file", "", "The client key used for s3 mTLS")
+	flags.BoolVar(&c.InsecureSkipVerify, prefix+"-insecure-skip-verify", false, "Disable s3 TLS certificate verification")
+	flags.IntVar(&c.MaxIdleConns, prefix+"-max-idle-conns", 0, "The maximum number of idle s3 connections (0 uses the default)")
+	flags.IntVar(&c.MaxIdleConnsPerHost, prefix+"-max-idle-conns-per-host", 0, "The maximum number of idle s3 connections per host (0 uses the default)")
+	flags.IntVar(&c.MaxConnsPerHost, prefix+"-max-conns-per-host", 0, "The maximum number of s3 connections per host (0 is unlimited)")
+	flags.DurationVar(&c.IdleConnTimeout, prefix+"-idle-conn-timeout", 0, "How long idle s3 connections are kept open (0 uses the default)")
+	flags.DurationVar(&c.DialTimeout, prefix+"-dial-timeout", 0, "The timeout for connecting to the s3 endpoint (0 uses the default)")
+	flags.DurationVar(&c.ResponseHeaderTimeout, prefix+"-response-header-timeout", 0, "How long s3 requests wait for response headers (0 uses the default)")
+	flags.BoolVar(&c.DualStack, prefix+"-dual-stack", false, "Use the dual-stack (IPv4 and IPv6) s3 endpoints of AWS")
+	flags.StringVar(&c.Network, prefix+"-network", "", "Restrict s3 connections to 'tcp4' or 'tcp6' (empty uses both)")
+	flags.StringVar(&c.DNSServer, prefix+"-dns-server", "", "The DNS server (host:port) used to resolve the s3 endpoint (empty uses the system resolver)")
+	flags.BoolVar(&c.TraceRequests, prefix+"-trace-requests", false, "Log the timing of every s3 request at debug level")
+	flags.StringVar(&c.RedactKeys, prefix+"-redact-keys", "", "How object keys are redacted in s3 logs ('hash' or 'truncate', empty disables redaction)")
+	flags.IntVar(&c.RedactKeyLength, prefix+"-redact-key-length", 0, "The number of characters kept when truncating object keys in s3 logs")
+	flags.StringToStringVar(&c.LogLevels, prefix+"-log-levels", nil, "The log level of s3 operations by operation name (debug, info, warn, error or off)")
+	flags.DurationVar(&c.SlowOperationThreshold, prefix+"-slow-operation-threshold", 0, "Log a warning for s3 operations that take longer than this, disabled if zero")
+	flags.BoolVar(&c.LazyGetObject, prefix+"-lazy-get-object", false, "Defer s3 GetObject requests until the body is first read")
+	flags.StringVar(&c.KeyDelimiter, prefix+"-key-delimiter", s3.DefaultKeyDelimiter, "The delimiter used to join s3 prefixes and keys")
+	flags.BoolVar(&c.DisablePrefixing, prefix+"-disable-prefixing", false, "Use s3 keys as full object names, ignoring prefixes")
+	flags.BoolVar(&c.LegacyKeyJoin, prefix+"-legacy-key-join", false, "Join s3 prefixes and keys without removing repeated delimiters, as older versions did")
+	flags.BoolVar(&c.ValidateKeys, prefix+"-validate-keys", false, "Reject invalid s3 object keys before sending requests")
+	flags.BoolVar(&c.SanitizeKeys, prefix+"-sanitize-keys", false, "Sanitize s3 object keys before validating them")
+	flags.StringVar(&c.Namespace, prefix+"-namespace", "", "A prefix prepended to the names of all s3 objects used by the client")
+	flags.StringVar(&c.PublicBaseURL, prefix+"-public-base-url", "", "The base URL of public s3 object URLs, such as a CDN, defaults to the endpoint")
 }
 
 func (c *Config) GenerateOptions(logName string) *s3.Options {
-	return &s3.Options{
-		LogName:   logName,
-		Disabled:  c.Disabled,
-		Secure:    c.Secure,
-		Region:    c.Region,
-		Endpoint:  c.Endpoint,
-		Bucket:    c.Bucket,
-		AccessKey: c.AccessKey,
-		SecretKey: c.SecretKey,
+	options := &s3.Options{
+		LogName:      logName,
+		Disabled:     c.Disabled,
+		Secure:       c.Secure,
+		Region:       c.Region,
+		Endpoint:     c.Endpoint,
+		Bucket:       c.Bucket,
+		BucketLookup: s3.BucketLookup(c.BucketLookup),
+		Provider:     s3.Provider(c.Provider),
+		AccessKey:    c.AccessKey,
+		SecretKey:    c.SecretKey,
+		SessionToken: c.SessionToken,
+		SignatureV2:  c.SignatureV2,
+
+		AccessKeyFile:    c.AccessKeyFile,
+		SecretKeyFile:    c.SecretKeyFile,
+		WatchSecretFiles: c.WatchSecretFiles,
+
+		CredentialsSource: s3.CredentialsSource(c.CredentialsSource),
+		IAMEndpoint:       c.IAMEndpoint,
+
+		AssumeRoleARN:         c.AssumeRoleARN,
+		AssumeRoleExternalID:  c.AssumeRoleExternalID,
+		AssumeRoleSessionName: c.AssumeRoleSessionName,
+		AssumeRoleDuration:    c.AssumeRoleDuration,
+		STSEndpoint:           c.STSEndpoint,
+
+		WebIdentityTokenFile: c.WebIdentityTokenFile,
+
+		SharedCredentialsFile:    c.SharedCredentialsFile,
+		SharedCredentialsProfile: c.SharedCredentialsProfile,
+
+		StorageClass: c.StorageClass,
+
+		KMSKeyID:   c.KMSKeyID,
+		KMSContext: c.KMSContext,
+
+		ReaperInterval: c.ReaperInterval,
+		ReaperPrefix:   c.ReaperPrefix,
+
+		DiskCacheDir:      c.DiskCacheDir,
+		DiskCacheMaxBytes: c.DiskCacheMaxBytes,
+
+		MemoryCacheMaxBytes: c.MemoryCacheMaxBytes,
+		MemoryCacheTTL:      c.MemoryCacheTTL,
+
+		ReadRangeSize:  c.ReadRangeSize,
+		PrefetchWindow: c.PrefetchWindow,
+
+		PartSize:          c.PartSize,
+		UploadConcurrency: c.UploadConcurrency,
+
+		PresignExpiry: c.PresignExpiry,
+
+		RateLimit: c.RateLimit,
+		RateBurst: c.RateBurst,
+
+		Retry: s3.RetryPolicy{
+			MaxAttempts:    c.RetryMaxAttempts,
+			InitialBackoff: c.RetryInitialBackoff,
+			MaxBackoff:     c.RetryMaxBackoff,
+			Jitter:         c.RetryJitter,
+		},
+
+		Timeouts: s3.Timeouts{
+			Read:    c.ReadTimeout,
+			Write:   c.WriteTimeout,
+			List:    c.ListTimeout,
+			Presign: c.PresignTimeout,
+		},
+
+		HealthCheckInterval: c.HealthCheckInterval,
+		HealthCheckTimeout:  c.HealthCheckTimeout,
+
+		FailoverEndpoints: c.FailoverEndpoints,
+		FailbackInterval:  c.FailbackInterval,
+		ReadEndpoints:     c.ReadEndpoints,
+
+		ProxyURL: c.ProxyURL,
+		NoProxy:  c.NoProxy,
+
+		CACertFile:         c.CACertFile,
+		ClientCertFile:     c.ClientCertFile,
+		ClientKeyFile:      c.ClientKeyFile,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleConnTimeout:     c.IdleConnTimeout,
+
+		DialTimeout:           c.DialTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+
+		DualStack: c.DualStack,
+		Network:   c.Network,
+		DNSServer: c.DNSServer,
+
+		TraceRequests: c.TraceRequests,
+
+		RedactKeys:      s3.KeyRedaction(c.RedactKeys),
+		RedactKeyLength: c.RedactKeyLength,
+
+		SlowOperationThreshold: c.SlowOperationThreshold,
+
+		LazyGetObject: c.LazyGetObject,
+
+		KeyDelimiter:     c.KeyDelimiter,
+		DisablePrefixing: c.DisablePrefixing,
+		LegacyKeyJoin:    c.LegacyKeyJoin,
+
+		ValidateKeys: c.ValidateKeys,
+		SanitizeKeys: c.SanitizeKeys,
+
+		Namespace: c.Namespace,
+
+		PublicBaseURL: c.PublicBaseURL,
+	}
+
+	if len(c.LogLevels) > 0 {
+		options.LogLevels = make(map[string]s3.LogLevel, len(c.LogLevels))
+		for operation, level := range c.LogLevels {
+			options.LogLevels[operation] = s3.LogLevel(level)
+		}
+	}
+
+	return options
+}
+
+// NewStorage creates the Storage described by the config, which is a local
+// directory for file endpoints and an S3 client otherwise
+func (c *Config) NewStorage(logName string, logger *zerolog.Logger, opts ...s3.Option) (s3.Storage, error) {
+	if !c.Disabled && s3fsbackend.IsEndpoint(c.Endpoint) {
+		return s3fsbackend.NewFromEndpoint(c.Endpoint, c.Bucket)
+	}
+	client, err := s3.New(c.GenerateOptions(logName), logger, opts...)
+	if err != nil {
+		return nil, err
 	}
+	return client, nil
 }
@@ -0,0 +1,93 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/loopholelabs/s3"
+)
+
+func TestFlagPrefix(t *testing.T) {
+	primary, backup := New(), New()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	primary.RootPersistentFlags(flags)
+	backup.RootPersistentFlagsWithPrefix(flags, "backup")
+	if err := flags.Parse([]string{"--s3-endpoint=primary:9000", "--backup-endpoint=backup:9000", "--backup-bucket=backups"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	if primary.Endpoint != "primary:9000" || primary.Bucket != "" {
+		t.Fatalf("expected only the primary endpoint to be set, got %q and %q", primary.Endpoint, primary.Bucket)
+	}
+	if backup.Endpoint != "backup:9000" || backup.Bucket != "backups" {
+		t.Fatalf("expected the backup flags to be set, got %q and %q", backup.Endpoint, backup.Bucket)
+	}
+
+	// Loading keeps the flags registered with the prefix of the config
+	t.Setenv("BACKUP_ENDPOINT", "env:9000")
+	t.Setenv("BACKUP_REGION", "eu-west-1")
+	if err := backup.Load("", "backup", flags); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if backup.Endpoint != "backup:9000" || backup.Region != "eu-west-1" {
+		t.Fatalf("expected the endpoint from the flags and the region from the environment, got %q and %q", backup.Endpoint, backup.Region)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	// Loading, watching and viper find the flag of every config key by name
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	New().RootPersistentFlagsWithPrefix(flags, "test")
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if flags.Lookup(flagName("test", tag)) == nil {
+			t.Errorf("expected a %s flag for %s", flagName("test", tag), tag)
+		}
+	}
+}
+
+func TestTransferSettings(t *testing.T) {
+	c := New()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	c.RootPersistentFlags(flags)
+	if err := flags.Parse([]string{"--s3-part-size=16777216", "--s3-upload-concurrency=8", "--s3-dial-timeout=5s"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	t.Setenv("S3_RESPONSE_HEADER_TIMEOUT", "10s")
+	if err := c.Load("", DefaultEnvPrefix, flags); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	options := c.GenerateOptions("test")
+	if options.PartSize != 16777216 || options.UploadConcurrency != 8 {
+		t.Fatalf("expected the part size and upload concurrency of the flags, got %d and %d", options.PartSize, options.UploadConcurrency)
+	}
+	if options.DialTimeout != 5*time.Second || options.ResponseHeaderTimeout != 10*time.Second {
+		t.Fatalf("expected the dial and response header timeouts, got %s and %s", options.DialTimeout, options.ResponseHeaderTimeout)
+	}
+	if options.PresignExpiry != s3.DefaultPresignExpiry {
+		t.Fatalf("expected the default presign expiry, got %s", options.PresignExpiry)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidEnv = errors.New("invalid environment variable")
+)
+
+const (
+	DefaultEnvPrefix = "S3"
+)
+
+// FromEnv returns a Config with the defaults of New, overridden by the
+// environment variables named after the mapstructure tags of its fields,
+// upper-cased and prefixed with prefix and an underscore (S3_ENDPOINT,
+// S3_ACCESS_KEY, ... for DefaultEnvPrefix)
+func FromEnv(prefix string) (*Config, error) {
+	c := New()
+	if err := c.LoadEnv(prefix); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadEnv overrides the fields of the Config that are set in the environment,
+// see FromEnv. Maps are written like flags, as comma-separated key=value
+// pairs, and lists as comma-separated values.
+func (c *Config) LoadEnv(prefix string) error {
+	return loadEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+func loadEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := EnvName(prefix, tag)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%w %s: %w", ErrInvalidEnv, name, err)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable of the config key with the given
+// prefix
+func EnvName(prefix string, key string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+	if prefix == "" {
+		return name
+	}
+	return strings.ToUpper(prefix) + "_" + name
+}
+
+// setField parses s into a config field
+func setField(field reflect.Value, s string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Map:
+		m := make(map[string]string)
+		for _, pair := range strings.Split(s, ",") {
+			if pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			m[k] = v
+		}
+		field.Set(reflect.ValueOf(m))
+	case reflect.Slice:
+		var values []string
+		for _, value := range strings.Split(s, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("TEST_ENDPOINT", "localhost:9000")
+	t.Setenv("TEST_SECURE", "false")
+	t.Setenv("TEST_BUCKET", "bucket")
+	t.Setenv("TEST_ACCESS_KEY", "access")
+	t.Setenv("TEST_DISK_CACHE_MAX_BYTES", "1024")
+	t.Setenv("TEST_PART_SIZE", "5242880")
+	t.Setenv("TEST_RETRY_JITTER", "0.5")
+	t.Setenv("TEST_READ_TIMEOUT", "30s")
+	t.Setenv("TEST_KMS_CONTEXT", "team=storage,env=test")
+	t.Setenv("TEST_FAILOVER_ENDPOINTS", "a:9000, b:9000,")
+
+	c, err := FromEnv("test")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if c.Endpoint != "localhost:9000" || c.Secure || c.Bucket != "bucket" || c.AccessKey != "access" {
+		t.Fatalf("expected the connection settings from the environment, got %+v", c)
+	}
+	if c.DiskCacheMaxBytes != 1024 || c.PartSize != 5242880 || c.RetryJitter != 0.5 || c.ReadTimeout != 30*time.Second {
+		t.Fatalf("expected numbers and durations from the environment, got %d, %d, %f and %s", c.DiskCacheMaxBytes, c.PartSize, c.RetryJitter, c.ReadTimeout)
+	}
+	if !reflect.DeepEqual(c.KMSContext, map[string]string{"team": "storage", "env": "test"}) {
+		t.Fatalf("expected a map from the environment, got %v", c.KMSContext)
+	}
+	if !reflect.DeepEqual(c.FailoverEndpoints, []string{"a:9000", "b:9000"}) {
+		t.Fatalf("expected a list from the environment, got %v", c.FailoverEndpoints)
+	}
+
+	// Unset variables keep the defaults of New
+	if c.Region != DefaultRegion || c.Disabled != DefaultDisabled {
+		t.Fatalf("expected defaults for unset variables, got %q and %t", c.Region, c.Disabled)
+	}
+}
+
+func TestFromEnvInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"TEST_SECURE":       "maybe",
+		"TEST_PART_SIZE":    "-1",
+		"TEST_READ_TIMEOUT": "30",
+		"TEST_KMS_CONTEXT":  "team",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := FromEnv("test"); !errors.Is(err, ErrInvalidEnv) {
+				t.Fatalf("expected ErrInvalidEnv for %s=%s, got %v", name, value, err)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for _, name := range []struct {
+		prefix   string
+		key      string
+		expected string
+	}{
+		{DefaultEnvPrefix, "access_key", "S3_ACCESS_KEY"},
+		{"backup", "endpoint", "BACKUP_ENDPOINT"},
+		{"", "disk-cache.dir", "DISK_CACHE_DIR"},
+	} {
+		if actual := EnvName(name.prefix, name.key); actual != name.expected {
+			t.Fatalf("expected %s for %s and %s, got %s", name.expected, name.prefix, name.key, actual)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported config file format")
+	ErrUnknownKey        = errors.New("unknown config key")
+	ErrInvalidValue      = errors.New("invalid config value")
+)
+
+// FromFile returns a Config with the defaults of New, overridden by the
+// file at path, see LoadFile
+func FromFile(path string) (*Config, error) {
+	c := New()
+	if err := c.LoadFile(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile overrides the fields of the Config that are set in the YAML, TOML
+// or JSON file at path, chosen by its extension. Keys are the mapstructure
+// tags of the fields, and unknown keys are rejected.
+func (c *Config) LoadFile(path string) error {
+	values, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	return decodeMap(reflect.ValueOf(c).Elem(), values)
+}
+
+// Load overrides the fields of the Config from the file at path, then from
+// the environment with envPrefix, then from the flags that were set on the
+// command line. The flags must have been registered with RootPersistentFlags
+// or RootPersistentFlagsWithPrefix on this Config. An empty path skips the
+// file and nil flags are ignored.
+func (c *Config) Load(path string, envPrefix string, flags *pflag.FlagSet) error {
+	var values map[string]interface{}
+	if path != "" {
+		var err error
+		values, err = readFile(path)
+		if err != nil {
+			return err
+		}
+	}
+	flagPrefix := c.flagPrefix
+	if flagPrefix == "" {
+		flagPrefix = DefaultFlagPrefix
+	}
+	return c.load(values, envPrefix, flags, flagPrefix)
+}
+
+// load overrides the fields of the Config from the values of a file, the
+// environment and the flags registered with flagPrefix, in that order
+func (c *Config) load(values map[string]interface{}, envPrefix string, flags *pflag.FlagSet, flagPrefix string) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	// The flags were already parsed into the Config, so the values they
+	// set are kept aside while the file and environment are loaded
+	changed := make(map[int]reflect.Value)
+	if flags != nil {
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			if flags.Changed(flagName(flagPrefix, tag)) {
+				value := reflect.New(t.Field(i).Type).Elem()
+				value.Set(v.Field(i))
+				changed[i] = value
+			}
+		}
+	}
+
+	if values != nil {
+		if err := decodeMap(v, values); err != nil {
+			return err
+		}
+	}
+
+	if err := c.LoadEnv(envPrefix); err != nil {
+		return err
+	}
+
+	for i, value := range changed {
+		v.Field(i).Set(value)
+	}
+	return nil
+}
+
+// readFile decodes the YAML, TOML or JSON file at path
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return values, nil
+}
+
+// flagName returns the name of the flag registered for the config key with
+// the prefix
+func flagName(prefix string, key string) string {
+	return prefix + "-" + strings.ReplaceAll(key, "_", "-")
+}
+
+// decodeMap sets the fields of v from values keyed by their mapstructure tags
+func decodeMap(v reflect.Value, values map[string]interface{}) error {
+	t := v.Type()
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag != "" && tag != "-" {
+			fields[tag] = i
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		i, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+		}
+		if err := setValue(v.Field(i), values[key]); err != nil {
+			return fmt.Errorf("%w %s: %w", ErrInvalidValue, key, err)
+		}
+	}
+	return nil
+}
+
+// setValue sets a config field from a decoded file value
+func setValue(field reflect.Value, value interface{}) error {
+	if m, ok := value.(map[string]string); ok {
+		if field.Kind() != reflect.Map {
+			return fmt.Errorf("unexpected table for %s", field.Type())
+		}
+		field.Set(reflect.ValueOf(m))
+		return nil
+	}
+	if m, ok := value.(map[string]interface{}); ok {
+		if field.Kind() != reflect.Map {
+			return fmt.Errorf("unexpected table for %s", field.Type())
+		}
+		values := make(map[string]string, len(m))
+		for k, v := range m {
+			values[k] = fmt.Sprint(v)
+		}
+		field.Set(reflect.ValueOf(values))
+		return nil
+	}
+	if l, ok := value.([]string); ok {
+		if field.Kind() != reflect.Slice {
+			return fmt.Errorf("unexpected list for %s", field.Type())
+		}
+		field.Set(reflect.ValueOf(l))
+		return nil
+	}
+	if l, ok := value.([]interface{}); ok {
+		if field.Kind() != reflect.Slice {
+			return fmt.Errorf("unexpected list for %s", field.Type())
+		}
+		values := make([]string, len(l))
+		for i, v := range l {
+			values[i] = fmt.Sprint(v)
+		}
+		field.Set(reflect.ValueOf(values))
+		return nil
+	}
+	if s, ok := value.(string); ok {
+		return setField(field, s)
+	}
+	return setField(field, fmt.Sprint(value))
+}
@@ -0,0 +1,137 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestFromFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yaml": `
+endpoint: localhost:9000
+secure: false
+bucket: bucket
+part_size: 5242880
+read_timeout: 30s
+kms_context:
+  team: storage
+failover_endpoints:
+  - a:9000
+  - b:9000
+`,
+		"config.toml": `
+endpoint = "localhost:9000"
+secure = false
+bucket = "bucket"
+part_size = 5242880
+read_timeout = "30s"
+failover_endpoints = ["a:9000", "b:9000"]
+
+[kms_context]
+team = "storage"
+`,
+		"config.json": `{
+	"endpoint": "localhost:9000",
+	"secure": false,
+	"bucket": "bucket",
+	"part_size": 5242880,
+	"read_timeout": "30s",
+	"kms_context": {"team": "storage"},
+	"failover_endpoints": ["a:9000", "b:9000"]
+}`,
+	} {
+		t.Run(filepath.Ext(name), func(t *testing.T) {
+			c, err := FromFile(writeFile(t, name, content))
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+			if c.Endpoint != "localhost:9000" || c.Secure || c.Bucket != "bucket" || c.PartSize != 5242880 || c.ReadTimeout != 30*time.Second {
+				t.Fatalf("expected the settings of the file, got %+v", c)
+			}
+			if !reflect.DeepEqual(c.KMSContext, map[string]string{"team": "storage"}) || !reflect.DeepEqual(c.FailoverEndpoints, []string{"a:9000", "b:9000"}) {
+				t.Fatalf("expected tables and lists from the file, got %v and %v", c.KMSContext, c.FailoverEndpoints)
+			}
+			if c.Region != DefaultRegion {
+				t.Fatalf("expected the default region, got %q", c.Region)
+			}
+		})
+	}
+}
+
+func TestFromFileInvalid(t *testing.T) {
+	if _, err := FromFile(writeFile(t, "config.yaml", "endpont: localhost:9000\n")); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+	if _, err := FromFile(writeFile(t, "config.yaml", "part_size: large\n")); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+	if _, err := FromFile(writeFile(t, "config.yaml", "secure: [true]\n")); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue for a list, got %v", err)
+	}
+	if _, err := FromFile(writeFile(t, "config.ini", "endpoint=localhost:9000\n")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+	if _, err := FromFile(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file to fail, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+endpoint: file:9000
+bucket: file-bucket
+region: us-east-1
+`)
+	t.Setenv("TEST_BUCKET", "env-bucket")
+	t.Setenv("TEST_REGION", "eu-west-1")
+
+	c := New()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	c.RootPersistentFlags(flags)
+	if err := flags.Parse([]string{"--s3-region=ap-south-1"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	// Flags set on the command line override the environment, which
+	// overrides the file
+	if err := c.Load(path, "test", flags); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if c.Endpoint != "file:9000" || c.Bucket != "env-bucket" || c.Region != "ap-south-1" {
+		t.Fatalf("expected the endpoint from the file, the bucket from the environment and the region from the flags, got %q, %q and %q", c.Endpoint, c.Bucket, c.Region)
+	}
+	if !c.Secure {
+		t.Fatal("expected unset flags to keep their defaults")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/pflag"
+
+	"github.com/loopholelabs/s3"
+)
+
+// Profiles are named configs, for services that use more than one bucket or
+// endpoint. The flags of a profile are prefixed with its name (for example
+// --s3-backups-endpoint), and so are its environment variables
+// (S3_BACKUPS_ENDPOINT) and its section of a config file.
+type Profiles map[string]*Config
+
+// NewProfiles returns profiles with the given names and the defaults of New
+func NewProfiles(names ...string) Profiles {
+	p := make(Profiles, len(names))
+	for _, name := range names {
+		p[name] = New()
+	}
+	return p
+}
+
+// Names returns the names of the profiles in order
+func (p Profiles) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p Profiles) Validate() error {
+	for _, name := range p.Names() {
+		if err := p[name].Validate(); err != nil {
+			return fmt.Errorf("invalid %s profile: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (p Profiles) RootPersistentFlags(flags *pflag.FlagSet) {
+	for _, name := range p.Names() {
+		p[name].RootPersistentFlagsWithPrefix(flags, flagName(DefaultFlagPrefix, name))
+	}
+}
+
+// LoadEnv overrides the profiles from the environment, see Config.LoadEnv
+func (p Profiles) LoadEnv(prefix string) error {
+	for _, name := range p.Names() {
+		if err := p[name].LoadEnv(EnvName(prefix, name)); err != nil {
+			return fmt.Errorf("failed to load %s profile: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Load overrides the profiles from the sections of the file at path, then
+// from the environment and the flags, like Config.Load. Profiles that are
+// only in the file are added with the defaults of New.
+func (p Profiles) Load(path string, envPrefix string, flags *pflag.FlagSet) error {
+	var sections map[string]interface{}
+	if path != "" {
+		var err error
+		sections, err = readFile(path)
+		if err != nil {
+			return err
+		}
+		for name, section := range sections {
+			if _, ok := section.(map[string]interface{}); !ok {
+				return fmt.Errorf("%w %s: expected a table of settings", ErrInvalidValue, name)
+			}
+			if _, ok := p[name]; !ok {
+				p[name] = New()
+			}
+		}
+	}
+
+	for _, name := range p.Names() {
+		values, _ := sections[name].(map[string]interface{})
+		if err := p[name].load(values, EnvName(envPrefix, name), flags, flagName(DefaultFlagPrefix, name)); err != nil {
+			return fmt.Errorf("failed to load %s profile: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// GenerateOptions returns the options of every profile by name, logged with
+// the profile name appended to logName
+func (p Profiles) GenerateOptions(logName string) map[string]*s3.Options {
+	options := make(map[string]*s3.Options, len(p))
+	for name, c := range p {
+		options[name] = c.GenerateOptions(logName + "-" + name)
+	}
+	return options
+}
@@ -0,0 +1,99 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestProfilesLoad(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+primary:
+  endpoint: primary:9000
+  bucket: primary
+backups:
+  endpoint: backups:9000
+  bucket: backups
+archive:
+  endpoint: archive:9000
+  bucket: archive
+`)
+	t.Setenv("TEST_BACKUPS_BUCKET", "env-backups")
+
+	p := NewProfiles("primary", "backups")
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	p.RootPersistentFlags(flags)
+	if err := flags.Parse([]string{"--s3-primary-region=us-east-1"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	if err := p.Load(path, "test", flags); err != nil {
+		t.Fatalf("failed to load profiles: %v", err)
+	}
+
+	// Profiles that are only in the file are added
+	if names := p.Names(); !reflect.DeepEqual(names, []string{"archive", "backups", "primary"}) {
+		t.Fatalf("expected the profiles of the file, got %v", names)
+	}
+	if c := p["primary"]; c.Endpoint != "primary:9000" || c.Bucket != "primary" || c.Region != "us-east-1" {
+		t.Fatalf("expected the primary profile from its section and flags, got %q, %q and %q", c.Endpoint, c.Bucket, c.Region)
+	}
+	if c := p["backups"]; c.Endpoint != "backups:9000" || c.Bucket != "env-backups" || c.Region != DefaultRegion {
+		t.Fatalf("expected the backups profile from its section and environment, got %q, %q and %q", c.Endpoint, c.Bucket, c.Region)
+	}
+	if c := p["archive"]; c.Endpoint != "archive:9000" || c.Secure != DefaultSecure {
+		t.Fatalf("expected the archive profile with defaults, got %q and %t", c.Endpoint, c.Secure)
+	}
+
+	options := p.GenerateOptions("service")
+	if len(options) != 3 || options["backups"].LogName != "service-backups" || options["backups"].Bucket != "env-backups" {
+		t.Fatalf("expected options for every profile, got %v", options)
+	}
+}
+
+func TestProfilesInvalid(t *testing.T) {
+	path := writeFile(t, "config.yaml", "endpoint: localhost:9000\n")
+	if err := NewProfiles("primary").Load(path, "test", nil); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue for settings outside of a profile, got %v", err)
+	}
+
+	p := NewProfiles("primary", "backups")
+	for _, c := range p {
+		c.Endpoint, c.Bucket, c.AccessKey, c.SecretKey = "localhost:9000", "bucket", "access", "secret"
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected valid profiles: %v", err)
+	}
+	p["backups"].Bucket = ""
+	if err := p.Validate(); !errors.Is(err, ErrBucketRequired) {
+		t.Fatalf("expected ErrBucketRequired from the backups profile, got %v", err)
+	}
+}
+
+func TestProfilesLoadEnv(t *testing.T) {
+	t.Setenv("S3_BACKUPS_ENDPOINT", "backups:9000")
+	p := NewProfiles("primary", "backups")
+	if err := p.LoadEnv(DefaultEnvPrefix); err != nil {
+		t.Fatalf("failed to load profiles: %v", err)
+	}
+	if p["backups"].Endpoint != "backups:9000" || p["primary"].Endpoint != "" {
+		t.Fatalf("expected only the backups endpoint to be set, got %q and %q", p["backups"].Endpoint, p["primary"].Endpoint)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	regionPattern = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+	hostPattern   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+)
+
+// validateEndpoint checks that the endpoint is a host with an optional port,
+// which is what minio-go expects
+func validateEndpoint(endpoint string) error {
+	if strings.Contains(endpoint, "://") {
+		return fmt.Errorf("%w: %s must not include a scheme, set secure instead", ErrInvalidEndpoint, endpoint)
+	}
+	if strings.ContainsAny(endpoint, "/?#") {
+		return fmt.Errorf("%w: %s must not include a path", ErrInvalidEndpoint, endpoint)
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]")
+	if h, port, err := net.SplitHostPort(endpoint); err == nil {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%w: %s has an invalid port", ErrInvalidEndpoint, endpoint)
+		}
+		host = h
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if len(host) > 253 || !hostPattern.MatchString(host) {
+		return fmt.Errorf("%w: %s has an invalid host", ErrInvalidEndpoint, endpoint)
+	}
+	return nil
+}
+
+// validateBucket checks the naming rules of S3 buckets
+func validateBucket(bucket string) error {
+	if !bucketPattern.MatchString(bucket) {
+		return fmt.Errorf("%w: %s must be 3 to 63 lowercase letters, numbers, dots and hyphens, and start and end with a letter or number", ErrInvalidBucket, bucket)
+	}
+	if strings.Contains(bucket, "..") || strings.Contains(bucket, ".-") || strings.Contains(bucket, "-.") {
+		return fmt.Errorf("%w: %s must not have adjacent dots or hyphens next to dots", ErrInvalidBucket, bucket)
+	}
+	if net.ParseIP(bucket) != nil {
+		return fmt.Errorf("%w: %s must not be an IP address", ErrInvalidBucket, bucket)
+	}
+	if strings.HasPrefix(bucket, "xn--") || strings.HasSuffix(bucket, "-s3alias") || strings.HasSuffix(bucket, "--ol-s3") {
+		return fmt.Errorf("%w: %s uses a reserved prefix or suffix", ErrInvalidBucket, bucket)
+	}
+	return nil
+}
+
+// validateRegion checks that the region looks like us-east-1 or auto
+func validateRegion(region string) error {
+	if len(region) > 32 || !regionPattern.MatchString(region) {
+		return fmt.Errorf("%w: %s must be lowercase letters and numbers separated by hyphens", ErrInvalidRegion, region)
+	}
+	return nil
+}
+
+// validateNetwork checks that the network is one the dialer accepts
+func validateNetwork(network string) error {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+		return nil
+	}
+	return fmt.Errorf("%w: %s must be 'tcp4' or 'tcp6'", ErrInvalidNetwork, network)
+}
@@ -0,0 +1,114 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func validConfig() *Config {
+	c := New()
+	c.Endpoint = "s3.us-east-1.amazonaws.com"
+	c.Region = "us-east-1"
+	c.Bucket = "bucket"
+	c.AccessKey = "access"
+	c.SecretKey = "secret"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("expected a valid config: %v", err)
+	}
+
+	for _, endpoint := range []string{"localhost", "localhost:9000", "10.0.0.1:9000", "[::1]:9000", "::1"} {
+		c := validConfig()
+		c.Endpoint = endpoint
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected %s to be a valid endpoint: %v", endpoint, err)
+		}
+	}
+
+	// File endpoints need no region or credentials
+	c := New()
+	c.Endpoint, c.Bucket, c.Region = "file:///var/lib/s3", "bucket", ""
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected a file endpoint to be valid: %v", err)
+	}
+
+	c = New()
+	c.Disabled = true
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected a disabled config to be valid: %v", err)
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		modify   func(c *Config)
+		expected error
+	}{
+		{"no endpoint", func(c *Config) { c.Endpoint = "" }, ErrEndpointRequired},
+		{"no bucket", func(c *Config) { c.Bucket = "" }, ErrBucketRequired},
+		{"no region", func(c *Config) { c.Region = "" }, ErrRegionRequired},
+		{"scheme", func(c *Config) { c.Endpoint = "https://s3.amazonaws.com" }, ErrInvalidEndpoint},
+		{"path", func(c *Config) { c.Endpoint = "s3.amazonaws.com/bucket" }, ErrInvalidEndpoint},
+		{"port", func(c *Config) { c.Endpoint = "localhost:99999" }, ErrInvalidEndpoint},
+		{"host", func(c *Config) { c.Endpoint = "local_host:9000" }, ErrInvalidEndpoint},
+		{"failover endpoint", func(c *Config) { c.FailoverEndpoints = []string{"http://other"} }, ErrInvalidEndpoint},
+		{"read endpoint", func(c *Config) { c.ReadEndpoints = []string{"other/path"} }, ErrInvalidEndpoint},
+		{"uppercase bucket", func(c *Config) { c.Bucket = "Bucket" }, ErrInvalidBucket},
+		{"short bucket", func(c *Config) { c.Bucket = "ab" }, ErrInvalidBucket},
+		{"adjacent dots", func(c *Config) { c.Bucket = "my..bucket" }, ErrInvalidBucket},
+		{"ip bucket", func(c *Config) { c.Bucket = "192.168.0.1" }, ErrInvalidBucket},
+		{"reserved bucket", func(c *Config) { c.Bucket = "xn--bucket" }, ErrInvalidBucket},
+		{"region", func(c *Config) { c.Region = "US_EAST_1" }, ErrInvalidRegion},
+		{"network", func(c *Config) { c.Network = "udp" }, ErrInvalidNetwork},
+		{"no role", func(c *Config) { c.CredentialsSource = "assume_role" }, ErrRoleARNRequired},
+		{"no access key", func(c *Config) { c.AccessKey = "" }, ErrAccessKeyRequired},
+		{"no secret key", func(c *Config) { c.SecretKey = "" }, ErrSecretKeyRequired},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := validConfig()
+			test.modify(c)
+			if err := c.Validate(); !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	// Keys may come from files instead
+	c := validConfig()
+	c.AccessKey, c.SecretKey = "", ""
+	c.AccessKeyFile, c.SecretKeyFile = "/run/secrets/access", "/run/secrets/secret"
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected key files to be accepted: %v", err)
+	}
+
+	// Other credential sources need no static keys
+	for _, source := range []string{"env", "iam", "anonymous"} {
+		c = validConfig()
+		c.AccessKey, c.SecretKey, c.CredentialsSource = "", "", source
+		if err := c.Validate(); err != nil {
+			t.Fatalf("expected %s credentials without keys to be valid: %v", source, err)
+		}
+	}
+}
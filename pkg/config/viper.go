@@ -0,0 +1,113 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// BindViper binds the keys of the Config under key in v (s3.endpoint,
+// s3.access_key, ... for the key "s3") to the flags of the Config if they are
+// registered on flags, and to the environment variables of FromEnv with the
+// key as the prefix (S3_ENDPOINT, S3_ACCESS_KEY, ...). The current values of
+// the Config are used as the defaults.
+func (c *Config) BindViper(v *viper.Viper, key string, flags *pflag.FlagSet) error {
+	flagPrefix := c.flagPrefix
+	if flagPrefix == "" {
+		flagPrefix = DefaultFlagPrefix
+	}
+	envPrefix := EnvName("", key)
+
+	cv := reflect.ValueOf(c).Elem()
+	t := cv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := key + "." + tag
+
+		if field := cv.Field(i); (field.Kind() != reflect.Map && field.Kind() != reflect.Slice) || !field.IsNil() {
+			v.SetDefault(name, field.Interface())
+		}
+		if err := v.BindEnv(name, EnvName(envPrefix, tag)); err != nil {
+			return fmt.Errorf("failed to bind %s to the environment: %w", name, err)
+		}
+		if flags != nil {
+			if flag := flags.Lookup(flagName(flagPrefix, tag)); flag != nil {
+				if err := v.BindPFlag(name, flag); err != nil {
+					return fmt.Errorf("failed to bind %s to its flag: %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalViper sets the fields of the Config from the keys under key in v
+func (c *Config) UnmarshalViper(v *viper.Viper, key string) error {
+	values := make(map[string]interface{})
+	t := reflect.TypeOf(c).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if value := v.Get(key + "." + tag); value != nil {
+			values[tag] = value
+		}
+	}
+	return decodeMap(reflect.ValueOf(c).Elem(), values)
+}
+
+// BindCommand registers the flags of the Config on the persistent flags of
+// cmd, named after key (--s3-endpoint, ... for the key "s3"), and binds them
+// to v with BindViper. Before cmd or any of its subcommands run, the Config
+// is set from v and validated. Subcommands with their own persistent pre-run
+// hooks replace this one unless cobra.EnableTraverseRunHooks is set.
+func (c *Config) BindCommand(cmd *cobra.Command, v *viper.Viper, key string) error {
+	flags := cmd.PersistentFlags()
+	c.RootPersistentFlagsWithPrefix(flags, strings.ReplaceAll(key, ".", "-"))
+	if err := c.BindViper(v, key, flags); err != nil {
+		return err
+	}
+
+	preRunE, preRun := cmd.PersistentPreRunE, cmd.PersistentPreRun
+	cmd.PersistentPreRun = nil
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := c.UnmarshalViper(v, key); err != nil {
+			return err
+		}
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if preRunE != nil {
+			return preRunE(cmd, args)
+		}
+		if preRun != nil {
+			preRun(cmd, args)
+		}
+		return nil
+	}
+	return nil
+}
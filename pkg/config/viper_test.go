@@ -0,0 +1,106 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestBindViper(t *testing.T) {
+	c := New()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	c.RootPersistentFlags(flags)
+	c.Bucket = "default-bucket"
+	if err := flags.Parse([]string{"--s3-endpoint=flag:9000", "--s3-read-timeout=30s", "--s3-kms-context=team=storage"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	t.Setenv("S3_ENDPOINT", "env:9000")
+	t.Setenv("S3_REGION", "eu-west-1")
+
+	v := viper.New()
+	if err := c.BindViper(v, "s3", flags); err != nil {
+		t.Fatalf("failed to bind viper: %v", err)
+	}
+
+	next := New()
+	if err := next.UnmarshalViper(v, "s3"); err != nil {
+		t.Fatalf("failed to unmarshal viper: %v", err)
+	}
+	if next.Endpoint != "flag:9000" || next.Region != "eu-west-1" || next.Bucket != "default-bucket" {
+		t.Fatalf("expected the endpoint from the flags, the region from the environment and the bucket from the defaults, got %q, %q and %q", next.Endpoint, next.Region, next.Bucket)
+	}
+	if next.ReadTimeout != 30*time.Second || !reflect.DeepEqual(next.KMSContext, map[string]string{"team": "storage"}) {
+		t.Fatalf("expected typed flags to be unmarshalled, got %s and %v", next.ReadTimeout, next.KMSContext)
+	}
+}
+
+func TestBindCommand(t *testing.T) {
+	c := New()
+	v := viper.New()
+	ran := false
+	cmd := &cobra.Command{
+		Use: "test",
+		PersistentPreRun: func(*cobra.Command, []string) {
+			ran = true
+		},
+		RunE: func(*cobra.Command, []string) error {
+			return nil
+		},
+	}
+	if err := c.BindCommand(cmd, v, "storage"); err != nil {
+		t.Fatalf("failed to bind command: %v", err)
+	}
+
+	cmd.SetArgs([]string{"--storage-endpoint=localhost:9000", "--storage-bucket=bucket", "--storage-access-key=access", "--storage-secret-key=secret"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	if !ran {
+		t.Fatal("expected the existing pre-run hook to run")
+	}
+	if c.Endpoint != "localhost:9000" || c.Bucket != "bucket" {
+		t.Fatalf("expected the config to be set from the flags, got %q and %q", c.Endpoint, c.Bucket)
+	}
+
+	// The config is validated before the command runs
+	c, v, ran = New(), viper.New(), false
+	cmd = &cobra.Command{
+		Use: "test",
+		RunE: func(*cobra.Command, []string) error {
+			ran = true
+			return nil
+		},
+	}
+	if err := c.BindCommand(cmd, v, "storage"); err != nil {
+		t.Fatalf("failed to bind command: %v", err)
+	}
+	cmd.SilenceErrors, cmd.SilenceUsage = true, true
+	cmd.SetArgs([]string{"--storage-endpoint=localhost:9000"})
+	if err := cmd.Execute(); !errors.Is(err, ErrBucketRequired) {
+		t.Fatalf("expected ErrBucketRequired, got %v", err)
+	}
+	if ran {
+		t.Fatal("expected the command not to run with an invalid config")
+	}
+}
@@ -0,0 +1,112 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	DefaultWatchInterval = 10 * time.Second
+)
+
+// Watch checks the file at path for changes every interval until ctx is
+// done. After every change a new Config is loaded like Load, starting from
+// the defaults and the flags set on the command line so that settings
+// removed from the file are reset, and validated, then passed to fn, or the
+// error if loading it fails. If the file can't be checked the error is only
+// passed to fn once, until the file can be checked again. The Config itself
+// is never modified, so fn decides whether to apply the new one, for
+// example with S3.Reload:
+//
+//	go c.Watch(ctx, path, "S3", flags, config.DefaultWatchInterval, func(next *config.Config, err error) {
+//		if err == nil {
+//			err = client.Reload(next.GenerateOptions("s3"))
+//		}
+//		...
+//	})
+func (c *Config) Watch(ctx context.Context, path string, envPrefix string, flags *pflag.FlagSet, interval time.Duration, fn func(*Config, error)) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	last, _ := os.Stat(path)
+	failing := false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			if !failing {
+				fn(nil, fmt.Errorf("failed to check config file: %w", err))
+			}
+			// The file is loaded again once it is back, even if it has
+			// the same modification time and size
+			failing, last = true, nil
+			continue
+		}
+		failing = false
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+
+		next, err := c.reload(path, envPrefix, flags)
+		fn(next, err)
+	}
+}
+
+// reload loads the file at path into a default Config that keeps the values
+// of the flags set on the command line
+func (c *Config) reload(path string, envPrefix string, flags *pflag.FlagSet) (*Config, error) {
+	next := New()
+	next.flagPrefix = c.flagPrefix
+	if flags != nil {
+		flagPrefix := c.flagPrefix
+		if flagPrefix == "" {
+			flagPrefix = DefaultFlagPrefix
+		}
+		src, dst := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem()
+		t := src.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+			if tag != "" && tag != "-" && flags.Changed(flagName(flagPrefix, tag)) {
+				dst.Field(i).Set(src.Field(i))
+			}
+		}
+	}
+
+	if err := next.Load(path, envPrefix, flags); err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type reloaded struct {
+	config *Config
+	err    error
+}
+
+func waitReload(t *testing.T, reloads <-chan reloaded) reloaded {
+	t.Helper()
+	select {
+	case r := <-reloads:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the config to be reloaded")
+		return reloaded{}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := writeFile(t, "config.yaml", "endpoint: localhost:9000\nbucket: bucket\nread_timeout: 30s\n")
+	c := New()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	c.RootPersistentFlags(flags)
+	if err := flags.Parse([]string{"--s3-access-key=access", "--s3-secret-key=secret"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	if err := c.Load(path, "test", flags); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloads := make(chan reloaded, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Watch(ctx, path, "test", flags, 10*time.Millisecond, func(next *Config, err error) {
+			reloads <- reloaded{config: next, err: err}
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The file is compared to its state when watching starts
+	time.Sleep(50 * time.Millisecond)
+
+	// Settings removed from the file are reset to their defaults, and the
+	// flags set on the command line are kept
+	if err := os.WriteFile(path, []byte("endpoint: other:9000\nbucket: other\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	r := waitReload(t, reloads)
+	if r.err != nil {
+		t.Fatalf("failed to reload config: %v", r.err)
+	}
+	if r.config.Endpoint != "other:9000" || r.config.Bucket != "other" || r.config.ReadTimeout != 0 || r.config.AccessKey != "access" {
+		t.Fatalf("expected the new file with the flags, got %+v", r.config)
+	}
+	if c.Endpoint != "localhost:9000" {
+		t.Fatalf("expected the watched config to be left, got %q", c.Endpoint)
+	}
+
+	// Invalid configs are reported instead of passed on
+	if err := os.WriteFile(path, []byte("endpoint: other:9000\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	if r = waitReload(t, reloads); !errors.Is(r.err, ErrBucketRequired) || r.config != nil {
+		t.Fatalf("expected ErrBucketRequired, got %v", r.err)
+	}
+
+	// A missing file is only reported once, and loaded again once it is back
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("failed to remove config file: %v", err)
+	}
+	if r = waitReload(t, reloads); !errors.Is(r.err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file error, got %v", r.err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case r = <-reloads:
+		t.Fatalf("expected a missing file to be reported once, got %v", r.err)
+	default:
+	}
+	if err := os.WriteFile(path, []byte("endpoint: back:9000\nbucket: back\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	if r = waitReload(t, reloads); r.err != nil || r.config.Endpoint != "back:9000" {
+		t.Fatalf("expected the restored file to be loaded, got %v", r.err)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNameRequired = errors.New("election name is required")
+)
+
+const (
+	DefaultElectionTTL = time.Second * 30
+)
+
+// ElectorOptions configure a LeaderElector
+type ElectorOptions struct {
+	// Name is the name of the lock that is campaigned for
+	Name string
+
+	// TTL is the lease duration, defaults to DefaultElectionTTL
+	TTL time.Duration
+
+	// RenewInterval is how often the lease is renewed while leading, defaults to TTL/3.
+	// Leadership is given up RenewInterval (at most TTL/2) before the lease
+	// expires if it has not been renewed by then.
+	RenewInterval time.Duration
+
+	// RetryInterval is how often the lock is campaigned for while not leading, defaults to TTL/3
+	RetryInterval time.Duration
+
+	// OnElected is called in its own goroutine when leadership is acquired. The
+	// context is canceled as soon as leadership is lost or the elector is closed.
+	OnElected func(ctx context.Context)
+
+	// OnResigned is called after leadership has been lost or given up
+	OnResigned func()
+}
+
+// LeaderElector campaigns for a lock in the background and keeps its
+// lease renewed for as long as it is the leader
+type LeaderElector struct {
+	locker  *Locker
+	options ElectorOptions
+
+	leader atomic.Bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewLeaderElector creates a LeaderElector and starts campaigning immediately
+func NewLeaderElector(locker *Locker, options *ElectorOptions) (*LeaderElector, error) {
+	if options.Name == "" {
+		return nil, ErrNameRequired
+	}
+
+	opts := *options
+	if opts.TTL == 0 {
+		opts.TTL = DefaultElectionTTL
+	}
+	if opts.TTL < 0 {
+		return nil, ErrInvalidTTL
+	}
+	if opts.RenewInterval <= 0 {
+		opts.RenewInterval = opts.TTL / 3
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = opts.TTL / 3
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &LeaderElector{
+		locker:  locker,
+		options: opts,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	e.wg.Add(1)
+	go e.campaign()
+
+	return e, nil
+}
+
+// IsLeader returns whether this elector currently holds the lease
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Close stops campaigning, releasing the lease if it is held
+func (e *LeaderElector) Close() error {
+	e.cancel()
+	e.wg.Wait()
+	return nil
+}
+
+func (e *LeaderElector) campaign() {
+	defer e.wg.Done()
+	for {
+		l, err := e.locker.AcquireLock(e.ctx, e.options.Name, e.options.TTL)
+		if err == nil {
+			e.lead(l)
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-time.After(e.options.RetryInterval):
+		}
+	}
+}
+
+func (e *LeaderElector) lead(l *Lock) {
+	e.leader.Store(true)
+	leaderCtx, leaderCancel := context.WithCancel(e.ctx)
+	if e.options.OnElected != nil {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.options.OnElected(leaderCtx)
+		}()
+	}
+
+	defer func() {
+		leaderCancel()
+		e.leader.Store(false)
+		if e.options.OnResigned != nil {
+			e.options.OnResigned()
+		}
+	}()
+
+	// Leadership is given up a safety margin before the lease expires unless
+	// it has been renewed by then, so that another elector that acquires the
+	// expired lease never overlaps with this one
+	margin := min(e.options.RenewInterval, e.options.TTL/2)
+	deadline := l.Expires().Add(-margin)
+	stepDown := time.NewTimer(time.Until(deadline))
+	defer stepDown.Stop()
+
+	ticker := time.NewTicker(e.options.RenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.ctx.Done():
+			releaseCtx, releaseCancel := context.WithTimeout(context.Background(), e.options.RenewInterval)
+			_ = l.Release(releaseCtx)
+			releaseCancel()
+			return
+		case <-stepDown.C:
+			return
+		case <-ticker.C:
+			refreshCtx, refreshCancel := context.WithDeadline(e.ctx, deadline)
+			err := l.Refresh(refreshCtx, e.options.TTL)
+			refreshCancel()
+			if errors.Is(err, ErrNotHeld) {
+				return
+			}
+			// Transient errors are retried on the next tick until the
+			// step down timer fires
+			if err != nil {
+				continue
+			}
+			deadline = l.Expires().Add(-margin)
+			if !stepDown.Stop() {
+				<-stepDown.C
+			}
+			stepDown.Reset(time.Until(deadline))
+		}
+	}
+}
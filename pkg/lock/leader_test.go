@@ -0,0 +1,99 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+)
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLeaderElector(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, client s3.Storage) {
+		var elected, resigned atomic.Int32
+		options := &ElectorOptions{
+			Name:          "leader",
+			TTL:           time.Second,
+			RetryInterval: 50 * time.Millisecond,
+			OnElected: func(ctx context.Context) {
+				elected.Add(1)
+				<-ctx.Done()
+			},
+			OnResigned: func() {
+				resigned.Add(1)
+			},
+		}
+
+		a, err := NewLeaderElector(New(client, "elections", "a"), options)
+		if err != nil {
+			t.Fatalf("failed to create elector: %v", err)
+		}
+		defer a.Close()
+		b, err := NewLeaderElector(New(client, "elections", "b"), options)
+		if err != nil {
+			t.Fatalf("failed to create elector: %v", err)
+		}
+		defer b.Close()
+
+		waitFor(t, 5*time.Second, func() bool { return a.IsLeader() || b.IsLeader() })
+		leader, follower := a, b
+		if b.IsLeader() {
+			leader, follower = b, a
+		}
+
+		// Leadership is kept across several renewals
+		time.Sleep(1500 * time.Millisecond)
+		if !leader.IsLeader() || follower.IsLeader() {
+			t.Fatalf("expected exactly one stable leader, got %v and %v", leader.IsLeader(), follower.IsLeader())
+		}
+
+		if err = leader.Close(); err != nil {
+			t.Fatalf("failed to close elector: %v", err)
+		}
+		if leader.IsLeader() {
+			t.Fatal("expected closed elector to give up leadership")
+		}
+		if resigned.Load() != 1 {
+			t.Fatalf("expected OnResigned to be called once, got %d", resigned.Load())
+		}
+
+		// The lease is released on close, so the follower takes over without
+		// waiting for it to expire
+		waitFor(t, time.Second, follower.IsLeader)
+		waitFor(t, time.Second, func() bool { return elected.Load() == 2 })
+	})
+}
+
+func TestLeaderElectorInvalidTTL(t *testing.T) {
+	if _, err := NewLeaderElector(New(nil, "elections", ""), &ElectorOptions{Name: "leader", TTL: -time.Second}); err != ErrInvalidTTL {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+}
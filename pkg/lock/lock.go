@@ -0,0 +1,295 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package lock provides a distributed lock built on top of S3 conditional writes.
+//
+// Each lock is a small JSON object containing the owner and expiry of the lock.
+// Locks are acquired with a create-if-absent PUT, and refreshed with a PUT that
+// only succeeds if the lock object has not changed since it was last written.
+// Expired locks can be taken over by any other owner, so expiry is only as
+// accurate as the clocks of the participating processes.
+package lock
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/s3"
+)
+
+var (
+	ErrLocked     = errors.New("lock is held by another owner")
+	ErrNotHeld    = errors.New("lock is no longer held")
+	ErrInvalidTTL = errors.New("lock ttl must be positive")
+)
+
+const (
+	ContentType = "application/json"
+)
+
+// state is the content of a lock object
+type state struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Locker acquires locks stored under a prefix in an s3.Storage
+type Locker struct {
+	client s3.Storage
+	prefix string
+	owner  string
+}
+
+// Lock is a held lock
+type Lock struct {
+	locker *Locker
+	name   string
+
+	mu      sync.Mutex
+	etag    string
+	expires time.Time
+}
+
+// New returns a Locker that stores locks under the given prefix. If owner is
+// empty a random owner ID is generated.
+func New(client s3.Storage, prefix string, owner string) *Locker {
+	if owner == "" {
+		owner = randomOwner()
+	}
+	return &Locker{
+		client: client,
+		prefix: prefix,
+		owner:  owner,
+	}
+}
+
+// Owner returns the owner ID written into lock objects
+func (l *Locker) Owner() string {
+	return l.owner
+}
+
+// AcquireLock attempts to acquire the named lock for the given ttl, returning
+// ErrLocked if another owner currently holds an unexpired lock.
+func (l *Locker) AcquireLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+
+	expires := time.Now().Add(ttl)
+	body, err := l.encode(expires)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := l.client.PutObjectIfAbsent(ctx, l.prefix, name, bytes.NewReader(body), int64(len(body)), ContentType)
+	if err == nil {
+		return l.newLock(name, info.ETag, expires), nil
+	}
+	if !errors.Is(err, s3.ErrObjectExists) {
+		return nil, fmt.Errorf("failed to create lock object: %w", err)
+	}
+
+	current, etag, err := l.read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if current.Owner != l.owner && time.Now().Before(current.Expires) {
+		return nil, ErrLocked
+	}
+
+	// The lock is either expired or already ours, so take it over as long
+	// as nobody else has written it since we read it
+	info, err = l.client.PutObjectWithOptions(ctx, l.prefix, name, bytes.NewReader(body), int64(len(body)), s3.PutOptions{
+		ContentType: ContentType,
+		IfMatch:     etag,
+	})
+	if err != nil {
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("failed to take over lock object: %w", err)
+	}
+
+	return l.newLock(name, info.ETag, expires), nil
+}
+
+// Name returns the name of the lock
+func (k *Lock) Name() string {
+	return k.name
+}
+
+// Expires returns the time the lock expires unless it is refreshed
+func (k *Lock) Expires() time.Time {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.expires
+}
+
+// Refresh extends the lock by the given ttl, returning ErrNotHeld if
+// the lock has been released or taken over by another owner.
+func (k *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Without an ETag the write would be unconditional and overwrite the
+	// lock of whoever holds it now
+	if k.etag == "" {
+		return ErrNotHeld
+	}
+	expires := time.Now().Add(ttl)
+	body, err := k.locker.encode(expires)
+	if err != nil {
+		return err
+	}
+
+	info, err := k.locker.client.PutObjectWithOptions(ctx, k.locker.prefix, k.name, bytes.NewReader(body), int64(len(body)), s3.PutOptions{
+		ContentType: ContentType,
+		IfMatch:     k.etag,
+	})
+	if err != nil {
+		if errors.Is(err, s3.ErrObjectNotFound) || errors.Is(err, s3.ErrPreconditionFailed) {
+			return ErrNotHeld
+		}
+		return fmt.Errorf("failed to refresh lock object: %w", err)
+	}
+
+	k.etag = info.ETag
+	k.expires = expires
+	return nil
+}
+
+// Release deletes the lock object if it is still held by this lock,
+// returning ErrNotHeld otherwise.
+func (k *Lock) Release(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.etag == "" {
+		return ErrNotHeld
+	}
+	err := k.locker.client.DeleteObjectWithOptions(ctx, k.locker.prefix, k.name, s3.DeleteOptions{IfMatch: k.etag})
+	if err != nil {
+		if errors.Is(err, s3.ErrObjectNotFound) || errors.Is(err, s3.ErrPreconditionFailed) {
+			return ErrNotHeld
+		}
+		return fmt.Errorf("failed to delete lock object: %w", err)
+	}
+	k.etag = ""
+	return nil
+}
+
+func (l *Locker) newLock(name string, etag string, expires time.Time) *Lock {
+	return &Lock{
+		locker:  l,
+		name:    name,
+		etag:    etag,
+		expires: expires,
+	}
+}
+
+func (l *Locker) encode(expires time.Time) ([]byte, error) {
+	body, err := json.Marshal(&state{
+		Owner:   l.owner,
+		Expires: expires,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lock object: %w", err)
+	}
+	return body, nil
+}
+
+// read returns the state of a lock object and the ETag it was read at. Both
+// come from the same uncached response, so a stale body is never paired
+// with the ETag of a newer write.
+func (l *Locker) read(ctx context.Context, name string) (*state, string, error) {
+	obj, err := l.client.GetObjectWithOptions(ctx, l.prefix, name, s3.GetOptions{
+		NoCache: true,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get lock object: %w", err)
+	}
+
+	stater, ok := obj.(s3.ObjectStater)
+	if !ok {
+		// Storage implementations that don't report the info of the
+		// response are read again at the ETag of a stat, failing if the
+		// object changed in between
+		_ = obj.Close()
+		return l.readAt(ctx, name)
+	}
+	defer obj.Close()
+
+	info, err := stater.Stat()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stat lock object: %w", err)
+	}
+	s, err := decode(obj)
+	return s, info.ETag, err
+}
+
+// readAt reads a lock object with a stat and a GET conditional on its ETag
+func (l *Locker) readAt(ctx context.Context, name string) (*state, string, error) {
+	info, err := l.client.StatObject(ctx, l.prefix, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stat lock object: %w", err)
+	}
+
+	obj, err := l.client.GetObjectWithOptions(ctx, l.prefix, name, s3.GetOptions{
+		IfMatch: info.ETag,
+		NoCache: true,
+	})
+	if err != nil {
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			return nil, "", ErrLocked
+		}
+		return nil, "", fmt.Errorf("failed to get lock object: %w", err)
+	}
+	defer obj.Close()
+
+	s, err := decode(obj)
+	return s, info.ETag, err
+}
+
+func decode(obj io.Reader) (*state, error) {
+	body, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock object: %w", err)
+	}
+
+	s := new(state)
+	if err = json.Unmarshal(body, s); err != nil {
+		return nil, fmt.Errorf("failed to decode lock object: %w", err)
+	}
+	return s, nil
+}
+
+func randomOwner() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
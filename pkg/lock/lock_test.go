@@ -0,0 +1,195 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3mem"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+var storages = map[string]func(t *testing.T) s3.Storage{
+	"s3": func(t *testing.T) s3.Storage {
+		return s3test.NewServer(t)
+	},
+	"s3mem": func(t *testing.T) s3.Storage {
+		return s3mem.New("locks")
+	},
+}
+
+func forEachStorage(t *testing.T, fn func(t *testing.T, client s3.Storage)) {
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			fn(t, storage(t))
+		})
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, client s3.Storage) {
+		ctx := context.Background()
+		a, b := New(client, "locks", "a"), New(client, "locks", "b")
+
+		l, err := a.AcquireLock(ctx, "job", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to acquire lock: %v", err)
+		}
+		if _, err = b.AcquireLock(ctx, "job", time.Minute); !errors.Is(err, ErrLocked) {
+			t.Fatalf("expected ErrLocked while the lock is held, got %v", err)
+		}
+
+		// The owner can acquire its own lock again
+		again, err := a.AcquireLock(ctx, "job", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to acquire own lock again: %v", err)
+		}
+		if err = l.Release(ctx); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld for a lock that was acquired again, got %v", err)
+		}
+
+		if err = again.Release(ctx); err != nil {
+			t.Fatalf("failed to release lock: %v", err)
+		}
+		if err = again.Release(ctx); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld for a released lock, got %v", err)
+		}
+		if _, err = b.AcquireLock(ctx, "job", time.Minute); err != nil {
+			t.Fatalf("failed to acquire released lock: %v", err)
+		}
+	})
+}
+
+func TestAcquireExpiredLock(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, client s3.Storage) {
+		ctx := context.Background()
+		a, b := New(client, "locks", "a"), New(client, "locks", "b")
+
+		stale, err := a.AcquireLock(ctx, "job", 50*time.Millisecond)
+		if err != nil {
+			t.Fatalf("failed to acquire lock: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		l, err := b.AcquireLock(ctx, "job", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to take over expired lock: %v", err)
+		}
+
+		if err = stale.Refresh(ctx, time.Minute); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld refreshing a lock that was taken over, got %v", err)
+		}
+		// Releasing the stale lock must not delete the lock of the new owner
+		if err = stale.Release(ctx); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld releasing a lock that was taken over, got %v", err)
+		}
+		if _, err = a.AcquireLock(ctx, "job", time.Minute); !errors.Is(err, ErrLocked) {
+			t.Fatalf("expected the lock to still be held, got %v", err)
+		}
+		if err = l.Release(ctx); err != nil {
+			t.Fatalf("failed to release lock: %v", err)
+		}
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, client s3.Storage) {
+		ctx := context.Background()
+		l, err := New(client, "locks", "").AcquireLock(ctx, "job", time.Second)
+		if err != nil {
+			t.Fatalf("failed to acquire lock: %v", err)
+		}
+		expires := l.Expires()
+		if err = l.Refresh(ctx, time.Minute); err != nil {
+			t.Fatalf("failed to refresh lock: %v", err)
+		}
+		if !l.Expires().After(expires) {
+			t.Fatalf("expected expiry after %v, got %v", expires, l.Expires())
+		}
+		if err = l.Refresh(ctx, 0); !errors.Is(err, ErrInvalidTTL) {
+			t.Fatalf("expected ErrInvalidTTL, got %v", err)
+		}
+		if err = l.Release(ctx); err != nil {
+			t.Fatalf("failed to release refreshed lock: %v", err)
+		}
+	})
+}
+
+func TestRefreshReleased(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, client s3.Storage) {
+		ctx := context.Background()
+		a, b := New(client, "locks", "a"), New(client, "locks", "b")
+
+		released, err := a.AcquireLock(ctx, "job", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to acquire lock: %v", err)
+		}
+		if err = released.Release(ctx); err != nil {
+			t.Fatalf("failed to release lock: %v", err)
+		}
+		if err = released.Refresh(ctx, time.Minute); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld refreshing a released lock, got %v", err)
+		}
+
+		// Refreshing a released lock must not overwrite the lock of the next owner
+		l, err := b.AcquireLock(ctx, "job", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to acquire released lock: %v", err)
+		}
+		if err = released.Refresh(ctx, time.Minute); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld refreshing a released lock, got %v", err)
+		}
+		if err = l.Refresh(ctx, time.Minute); err != nil {
+			t.Fatalf("failed to refresh lock of the next owner: %v", err)
+		}
+		if _, err = a.AcquireLock(ctx, "job", time.Minute); !errors.Is(err, ErrLocked) {
+			t.Fatalf("expected the lock to still be held, got %v", err)
+		}
+	})
+}
+
+func TestRefreshTakenOver(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, client s3.Storage) {
+		ctx := context.Background()
+		a, b := New(client, "locks", "a"), New(client, "locks", "b")
+
+		stale, err := a.AcquireLock(ctx, "job", 50*time.Millisecond)
+		if err != nil {
+			t.Fatalf("failed to acquire lock: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		l, err := b.AcquireLock(ctx, "job", time.Minute)
+		if err != nil {
+			t.Fatalf("failed to take over expired lock: %v", err)
+		}
+
+		if err = stale.Refresh(ctx, time.Minute); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld refreshing a lock that was taken over, got %v", err)
+		}
+		if err = l.Release(ctx); err != nil {
+			t.Fatalf("failed to release lock of the new owner: %v", err)
+		}
+		// The lock object is gone, which must not be mistaken for a failure
+		if err = stale.Refresh(ctx, time.Minute); !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("expected ErrNotHeld refreshing a lock that was taken over and released, got %v", err)
+		}
+	})
+}
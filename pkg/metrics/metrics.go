@@ -0,0 +1,147 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package metrics records Prometheus metrics for S3 operations.
+//
+// Metrics implements s3.Observer and is added to s3.Options.Observers:
+//
+//	m, err := metrics.New(prometheus.DefaultRegisterer, "myservice")
+//	options.Observers = append(options.Observers, m)
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/loopholelabs/s3"
+)
+
+var _ s3.Observer = (*Metrics)(nil)
+
+// Metrics is an s3.Observer that records Prometheus metrics
+type Metrics struct {
+	requests   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	uploaded   *prometheus.CounterVec
+	downloaded *prometheus.CounterVec
+	retries    *prometheus.CounterVec
+	inFlight   *prometheus.GaugeVec
+}
+
+// New creates the metrics in the given namespace and registers them with registerer
+func New(registerer prometheus.Registerer, namespace string) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "operations_total",
+			Help:      "The number of completed S3 operations by operation and status.",
+		}, []string{"operation", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "operation_duration_seconds",
+			Help:      "The duration of S3 operations, including retries and reading the body of downloads.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"operation"}),
+		uploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "uploaded_bytes_total",
+			Help:      "The number of object bytes uploaded.",
+		}, []string{"operation"}),
+		downloaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "downloaded_bytes_total",
+			Help:      "The number of object bytes downloaded.",
+		}, []string{"operation"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "retries_total",
+			Help:      "The number of retried S3 operation attempts.",
+		}, []string{"operation"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "operations_in_flight",
+			Help:      "The number of S3 operations in progress.",
+		}, []string{"operation"}),
+	}
+
+	var err error
+	if m.requests, err = register(registerer, m.requests); err != nil {
+		return nil, err
+	}
+	if m.duration, err = register(registerer, m.duration); err != nil {
+		return nil, err
+	}
+	if m.uploaded, err = register(registerer, m.uploaded); err != nil {
+		return nil, err
+	}
+	if m.downloaded, err = register(registerer, m.downloaded); err != nil {
+		return nil, err
+	}
+	if m.retries, err = register(registerer, m.retries); err != nil {
+		return nil, err
+	}
+	if m.inFlight, err = register(registerer, m.inFlight); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// register registers collector, returning the existing collector if an
+// identical one was already registered (for example by another client)
+func register[T prometheus.Collector](registerer prometheus.Registerer, collector T) (T, error) {
+	if err := registerer.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+				return existing, nil
+			}
+		}
+		return collector, fmt.Errorf("failed to register metric: %w", err)
+	}
+	return collector, nil
+}
+
+func (m *Metrics) OperationStarted(ctx context.Context, op *s3.Operation) context.Context {
+	m.inFlight.WithLabelValues(op.Name).Inc()
+	return ctx
+}
+
+func (m *Metrics) OperationRetried(_ context.Context, op *s3.Operation, _ int, _ error) {
+	m.retries.WithLabelValues(op.Name).Inc()
+}
+
+func (m *Metrics) OperationFinished(_ context.Context, op *s3.Operation, err error) {
+	m.inFlight.WithLabelValues(op.Name).Dec()
+	m.requests.WithLabelValues(op.Name, s3.OperationStatus(err)).Inc()
+	m.duration.WithLabelValues(op.Name).Observe(time.Since(op.Start).Seconds())
+	if op.BytesSent > 0 {
+		m.uploaded.WithLabelValues(op.Name).Add(float64(op.BytesSent))
+	}
+	if op.BytesReceived > 0 {
+		m.downloaded.WithLabelValues(op.Name).Add(float64(op.BytesReceived))
+	}
+}
@@ -0,0 +1,394 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package migrate copies every object under a prefix from one client to
+// another, such as between buckets, providers or endpoints, optionally with
+// the versions, tags and metadata of the objects. Migrations can record
+// their progress in a checkpoint file, so an interrupted migration resumes
+// where it stopped instead of copying everything again.
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+)
+
+const (
+	DefaultConcurrency = 4
+)
+
+type Options struct {
+	// Concurrency is the number of objects copied at a time,
+	// DefaultConcurrency if zero
+	Concurrency int
+
+	// Versions copies every version of every object oldest first, so that
+	// the destination has the same history under new version IDs. Delete
+	// markers are copied by deleting the object at the destination.
+	Versions bool
+
+	// Tags copies the tags of objects
+	Tags bool
+
+	// Metadata copies the user metadata and content headers of objects.
+	// The content type is always copied, as is the metadata of
+	// transparently compressed objects, which are copied as stored.
+	Metadata bool
+
+	// Checkpoint is a file that every copied object is recorded in, so that
+	// objects copied by an earlier run with the same checkpoint are skipped.
+	// It should be removed once a migration has completed.
+	Checkpoint string
+
+	// Progress is called after every object has been processed, it
+	// is never called concurrently
+	Progress func(progress Progress)
+}
+
+// Progress is reported to Options.Progress
+type Progress struct {
+	Key       string
+	VersionID string
+	Migrated  int
+	Skipped   int
+	Failed    int
+}
+
+// Summary is the result of Migrate
+type Summary struct {
+	// Migrated and Skipped count versions if Options.Versions is set
+	Migrated int
+	Skipped  int
+
+	// Bytes is the number of bytes copied
+	Bytes int64
+
+	Failures []s3.ObjectFailure
+}
+
+// Migrator copies objects from one client to another
+type Migrator struct {
+	src *s3.S3
+	dst *s3.S3
+}
+
+// New returns a Migrator that copies objects from src to dst
+func New(src *s3.S3, dst *s3.S3) *Migrator {
+	return &Migrator{
+		src: src,
+		dst: dst,
+	}
+}
+
+// checkpointEntry is a line of a checkpoint file
+type checkpointEntry struct {
+	Key       string `json:"key"`
+	VersionID string `json:"version_id,omitempty"`
+}
+
+// checkpoint records copied objects in a file
+type checkpoint struct {
+	mu   sync.Mutex
+	done map[checkpointEntry]struct{}
+	file *os.File
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{
+		done: make(map[checkpointEntry]struct{}),
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	partial := false
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		reader := bufio.NewReader(f)
+		for {
+			var line []byte
+			line, err = reader.ReadBytes('\n')
+			if len(line) > 0 && line[len(line)-1] != '\n' {
+				// The last line is incomplete if the process was killed
+				// while writing it
+				partial = true
+				break
+			}
+			if err != nil {
+				break
+			}
+			var entry checkpointEntry
+			if json.Unmarshal(line, &entry) == nil {
+				c.done[entry] = struct{}{}
+			}
+		}
+		_ = f.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	c.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	if partial {
+		if _, err = c.file.Write([]byte{'\n'}); err != nil {
+			_ = c.file.Close()
+			return nil, fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *checkpoint) contains(entry checkpointEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.done[entry]
+	return ok
+}
+
+func (c *checkpoint) record(entry checkpointEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[entry] = struct{}{}
+	if c.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = c.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (c *checkpoint) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// Migrate copies every object under prefix from the source to the same key
+// at the destination, from up to opts.Concurrency workers at a time. Objects
+// that cannot be copied are reported in the summary, an error is only
+// returned if the source cannot be listed or the checkpoint cannot be used.
+func (m *Migrator) Migrate(ctx context.Context, prefix string, opts Options) (*Summary, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	done, err := openCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer done.Close()
+
+	summary := new(Summary)
+	var mu sync.Mutex
+	report := func(key string, versionID string, migrated bool, size int64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			summary.Failures = append(summary.Failures, s3.ObjectFailure{
+				Key: key,
+				Err: err,
+			})
+		case migrated:
+			summary.Migrated++
+			summary.Bytes += size
+		default:
+			summary.Skipped++
+		}
+		if opts.Progress != nil {
+			opts.Progress(Progress{
+				Key:       key,
+				VersionID: versionID,
+				Migrated:  summary.Migrated,
+				Skipped:   summary.Skipped,
+				Failed:    len(summary.Failures),
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	keys := make(chan []minio.ObjectInfo)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for versions := range keys {
+				m.migrateKey(ctx, prefix, versions, done, opts, report)
+			}
+		}()
+	}
+
+	err = m.list(ctx, prefix, opts.Versions, keys)
+	close(keys)
+	wg.Wait()
+	if err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// list sends the versions of every key under prefix to keys, oldest first.
+// Without versions, only the latest version of every key is sent.
+func (m *Migrator) list(ctx context.Context, prefix string, versions bool, keys chan<- []minio.ObjectInfo) error {
+	send := func(objects []minio.ObjectInfo) error {
+		select {
+		case keys <- objects:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if !versions {
+		for object := range m.src.ListObjectsRecursive(ctx, prefix) {
+			if object.Err != nil {
+				return fmt.Errorf("failed to list objects: %w", object.Err)
+			}
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			object.VersionID = ""
+			if err := send([]minio.ObjectInfo{object}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Versions are listed newest first and grouped by key
+	var group []minio.ObjectInfo
+	for object := range m.src.ListObjectVersions(ctx, prefix) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list object versions: %w", object.Err)
+		}
+		if len(group) > 0 && group[0].Key != object.Key {
+			if err := send(group); err != nil {
+				return err
+			}
+			group = nil
+		}
+		group = append([]minio.ObjectInfo{object}, group...)
+	}
+	if len(group) > 0 {
+		return send(group)
+	}
+	return nil
+}
+
+// migrateKey copies the versions of a key in order, stopping at the first
+// version that fails so that the history at the destination has no gaps
+func (m *Migrator) migrateKey(ctx context.Context, prefix string, versions []minio.ObjectInfo, done *checkpoint, opts Options, report func(key string, versionID string, migrated bool, size int64, err error)) {
+	for _, version := range versions {
+		entry := checkpointEntry{
+			Key:       version.Key,
+			VersionID: version.VersionID,
+		}
+		if done.contains(entry) {
+			report(version.Key, version.VersionID, false, 0, nil)
+			continue
+		}
+
+		size, err := m.migrateVersion(ctx, prefix, version, opts)
+		if err == nil {
+			err = done.record(entry)
+		}
+		report(version.Key, version.VersionID, true, size, err)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// migrateVersion copies a single version of an object, returning its size
+func (m *Migrator) migrateVersion(ctx context.Context, prefix string, version minio.ObjectInfo, opts Options) (int64, error) {
+	key := version.Key
+	if version.IsDeleteMarker {
+		return 0, m.dst.DeleteObject(ctx, prefix, key)
+	}
+
+	getOpts := s3.GetOptions{
+		VersionID: version.VersionID,
+	}
+	info, err := m.src.StatObjectWithOptions(ctx, prefix, key, getOpts)
+	if err != nil {
+		return 0, err
+	}
+
+	putOpts := s3.PutOptions{
+		ContentType: info.ContentType,
+		Metadata:    make(map[string]string),
+		Compression: s3.CompressionNone,
+	}
+	if opts.Metadata {
+		for name, value := range info.UserMetadata {
+			putOpts.Metadata[name] = value
+		}
+		putOpts.CacheControl = info.Metadata.Get("Cache-Control")
+		putOpts.ContentDisposition = info.Metadata.Get("Content-Disposition")
+		putOpts.ContentEncoding = info.Metadata.Get("Content-Encoding")
+		putOpts.ContentLanguage = info.Metadata.Get("Content-Language")
+	}
+	// The stored bytes are copied, so transparently compressed objects keep
+	// the metadata they are decompressed with
+	if size, ok := info.UserMetadata[s3.UncompressedSizeMetadata]; ok {
+		putOpts.Metadata[s3.UncompressedSizeMetadata] = size
+		putOpts.ContentEncoding = info.Metadata.Get("Content-Encoding")
+	}
+	if opts.Tags && info.UserTagCount > 0 {
+		putOpts.Tags, err = m.src.GetObjectTags(ctx, prefix, key, s3.WithVersionID(version.VersionID))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var body io.ReadCloser = io.NopCloser(strings.NewReader(""))
+	if info.Size > 0 {
+		// Ranged reads return the stored bytes of compressed objects
+		getOpts.Range = &s3.ByteRange{Start: 0, End: info.Size - 1}
+		body, err = m.src.GetObjectWithOptions(ctx, prefix, key, getOpts)
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer body.Close()
+
+	_, err = m.dst.PutObjectWithOptions(ctx, prefix, key, body, info.Size, putOpts)
+	return info.Size, err
+}
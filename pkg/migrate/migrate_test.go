@@ -0,0 +1,203 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func put(t *testing.T, client *s3.S3, prefix string, key string, data string, opts s3.PutOptions) {
+	t.Helper()
+	if _, err := client.PutObjectWithOptions(context.Background(), prefix, key, bytes.NewReader([]byte(data)), int64(len(data)), opts); err != nil {
+		t.Fatalf("failed to put %s/%s: %v", prefix, key, err)
+	}
+}
+
+func read(t *testing.T, client *s3.S3, prefix string, key string) string {
+	t.Helper()
+	reader, err := client.GetObject(context.Background(), prefix, key)
+	if err != nil {
+		t.Fatalf("failed to get %s/%s: %v", prefix, key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read %s/%s: %v", prefix, key, err)
+	}
+	return string(data)
+}
+
+func checkSummary(t *testing.T, summary *Summary, err error, migrated int, skipped int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	for _, failure := range summary.Failures {
+		t.Errorf("failed to migrate %s: %v", failure.Key, failure.Err)
+	}
+	if summary.Migrated != migrated || summary.Skipped != skipped {
+		t.Fatalf("expected %d migrated and %d skipped, got %d and %d", migrated, skipped, summary.Migrated, summary.Skipped)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src, dst := s3test.NewServer(t), s3test.NewServer(t)
+	put(t, src, "data", "a", "hello", s3.PutOptions{
+		ContentType:  "text/plain",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"Owner": "test"},
+		Tags:         map[string]string{"team": "storage"},
+	})
+	put(t, src, "data", "dir/b", "world", s3.PutOptions{ContentType: "text/plain"})
+	put(t, src, "other", "c", "other", s3.PutOptions{ContentType: "text/plain"})
+
+	var progress []Progress
+	summary, err := New(src, dst).Migrate(context.Background(), "data", Options{
+		Concurrency: 2,
+		Tags:        true,
+		Metadata:    true,
+		Progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	})
+	checkSummary(t, summary, err, 2, 0)
+	if summary.Bytes != 10 {
+		t.Fatalf("expected 10 bytes to be copied, got %d", summary.Bytes)
+	}
+	if len(progress) != 2 || progress[1].Migrated != 2 {
+		t.Fatalf("expected progress for every object, got %+v", progress)
+	}
+
+	if data := read(t, dst, "data", "a"); data != "hello" {
+		t.Fatalf("expected hello, got %q", data)
+	}
+	if data := read(t, dst, "data", "dir/b"); data != "world" {
+		t.Fatalf("expected world, got %q", data)
+	}
+	if _, err = dst.StatObject(context.Background(), "other", "c"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected objects outside of the prefix to be left, got %v", err)
+	}
+
+	info, err := dst.StatObject(context.Background(), "data", "a")
+	if err != nil {
+		t.Fatalf("failed to stat object: %v", err)
+	}
+	if info.ContentType != "text/plain" || info.UserMetadata["Owner"] != "test" || info.Metadata.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("expected content type and metadata to be copied, got %q, %v and %v", info.ContentType, info.UserMetadata, info.Metadata)
+	}
+	tags, err := dst.GetObjectTags(context.Background(), "data", "a")
+	if err != nil {
+		t.Fatalf("failed to get tags: %v", err)
+	}
+	if tags["team"] != "storage" {
+		t.Fatalf("expected tags to be copied, got %v", tags)
+	}
+}
+
+func TestMigrateWithoutMetadata(t *testing.T) {
+	src, dst := s3test.NewServer(t), s3test.NewServer(t)
+	put(t, src, "data", "a", "hello", s3.PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Owner": "test"},
+		Tags:        map[string]string{"team": "storage"},
+	})
+
+	summary, err := New(src, dst).Migrate(context.Background(), "data", Options{})
+	checkSummary(t, summary, err, 1, 0)
+
+	// The content type is always copied
+	info, err := dst.StatObject(context.Background(), "data", "a")
+	if err != nil {
+		t.Fatalf("failed to stat object: %v", err)
+	}
+	if info.ContentType != "text/plain" || len(info.UserMetadata) != 0 || info.UserTagCount != 0 {
+		t.Fatalf("expected only the content type to be copied, got %q, %v and %d tags", info.ContentType, info.UserMetadata, info.UserTagCount)
+	}
+}
+
+func TestMigrateCompressed(t *testing.T) {
+	src, dst := s3test.NewServer(t), s3test.NewServer(t)
+	data := string(bytes.Repeat([]byte("compressible data "), 4096))
+	put(t, src, "data", "a", data, s3.PutOptions{Compression: s3.CompressionGzip})
+
+	summary, err := New(src, dst).Migrate(context.Background(), "data", Options{})
+	checkSummary(t, summary, err, 1, 0)
+
+	// Compressed objects are copied as stored and still decompressed on read
+	srcInfo, err := src.StatObject(context.Background(), "data", "a")
+	if err != nil {
+		t.Fatalf("failed to stat source: %v", err)
+	}
+	dstInfo, err := dst.StatObject(context.Background(), "data", "a")
+	if err != nil {
+		t.Fatalf("failed to stat destination: %v", err)
+	}
+	if srcInfo.Size >= int64(len(data)) {
+		t.Fatalf("expected the source to be stored compressed, got %d bytes for %d", srcInfo.Size, len(data))
+	}
+	if dstInfo.Size != srcInfo.Size || summary.Bytes != srcInfo.Size {
+		t.Fatalf("expected the %d stored bytes to be copied, got %d and %d", srcInfo.Size, dstInfo.Size, summary.Bytes)
+	}
+	if read := read(t, dst, "data", "a"); read != data {
+		t.Fatalf("expected %d bytes of original data, got %d bytes", len(data), len(read))
+	}
+}
+
+func TestMigrateCheckpoint(t *testing.T) {
+	src, dst := s3test.NewServer(t), s3test.NewServer(t)
+	put(t, src, "data", "a", "hello", s3.PutOptions{})
+	put(t, src, "data", "b", "world", s3.PutOptions{})
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	m := New(src, dst)
+
+	summary, err := m.Migrate(context.Background(), "data", Options{Checkpoint: checkpoint})
+	checkSummary(t, summary, err, 2, 0)
+
+	// Objects recorded in the checkpoint are not copied again
+	if err = dst.DeleteObject(context.Background(), "data", "a"); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	summary, err = m.Migrate(context.Background(), "data", Options{Checkpoint: checkpoint})
+	checkSummary(t, summary, err, 0, 2)
+	if _, err = dst.StatObject(context.Background(), "data", "a"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected the checkpointed object to be skipped, got %v", err)
+	}
+
+	// A line torn by a crash is ignored and later entries are still read
+	f, err := os.OpenFile(checkpoint, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open checkpoint: %v", err)
+	}
+	if _, err = f.WriteString(`{"key":"da`); err != nil {
+		t.Fatalf("failed to write checkpoint: %v", err)
+	}
+	_ = f.Close()
+	put(t, src, "data", "c", "again", s3.PutOptions{})
+	summary, err = m.Migrate(context.Background(), "data", Options{Checkpoint: checkpoint})
+	checkSummary(t, summary, err, 1, 2)
+	summary, err = m.Migrate(context.Background(), "data", Options{Checkpoint: checkpoint})
+	checkSummary(t, summary, err, 0, 3)
+}
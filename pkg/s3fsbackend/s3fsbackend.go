@@ -0,0 +1,636 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package s3fsbackend provides an s3.Storage that keeps objects as files under
+// a local root directory, so applications can run locally without an S3
+// compatible server.
+//
+// Every key maps to the file at the same relative path under the root, with
+// "/" separating directories. Content types, metadata and ETags are kept in a
+// separate tree under the metadata directory in the root, and files placed in
+// the root by hand are served with their contents hashed on demand.
+package s3fsbackend
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+)
+
+const (
+	// Scheme is the URL scheme of file endpoints, such as file:///var/lib/s3
+	Scheme = "file"
+
+	// MetadataDir is the directory under the root that holds object metadata
+	MetadataDir = ".s3meta"
+
+	metadataSuffix = ".json"
+	tempPattern    = ".s3fsbackend-*.tmp"
+)
+
+var (
+	ErrInvalidEndpoint = errors.New("invalid file endpoint")
+)
+
+var _ s3.Storage = (*Storage)(nil)
+
+type metadata struct {
+	ETag         string            `json:"etag"`
+	Size         int64             `json:"size"`
+	ModTime      time.Time         `json:"mod_time"`
+	ContentType  string            `json:"content_type"`
+	Headers      http.Header       `json:"headers,omitempty"`
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+}
+
+// Storage is an s3.Storage backed by a local directory. Conditional writes
+// are only atomic with respect to other users of the same Storage.
+type Storage struct {
+	root   string
+	bucket string
+
+	mu sync.Mutex
+}
+
+// New returns a Storage that keeps objects under root, creating it if needed.
+// The bucket is only used in errors and presigned URLs.
+func New(root string, bucket string) (*Storage, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve root directory: %w", err)
+	}
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create root directory: %w", err)
+	}
+
+	return &Storage{
+		root:   root,
+		bucket: bucket,
+	}, nil
+}
+
+// IsEndpoint reports whether endpoint is a file endpoint, such as
+// file:///var/lib/s3 or file://./data
+func IsEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, Scheme+"://")
+}
+
+// NewFromEndpoint returns a Storage rooted at the directory a file endpoint
+// points to, keeping each bucket in its own subdirectory
+func NewFromEndpoint(endpoint string, bucket string) (*Storage, error) {
+	if !IsEndpoint(endpoint) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEndpoint, endpoint)
+	}
+	root := strings.TrimPrefix(endpoint, Scheme+"://")
+	if root == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEndpoint, endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("%w: bucket is required", ErrInvalidEndpoint)
+	}
+	return New(filepath.Join(filepath.FromSlash(root), bucket), bucket)
+}
+
+// PresignedGetObject returns a file URL for the object, which does not expire
+func (s *Storage) PresignedGetObject(_ context.Context, prefix string, key string, _ time.Duration) (*url.URL, error) {
+	objName := s3.JoinKey(prefix, key)
+	path, err := s.path(objName)
+	if err != nil {
+		return nil, s.operationError("PresignedGetObject", objName, err)
+	}
+	return &url.URL{
+		Scheme: Scheme,
+		Path:   filepath.ToSlash(path),
+	}, nil
+}
+
+func (s *Storage) GetObject(ctx context.Context, prefix string, key string, opts ...s3.ObjectOption) (io.ReadCloser, error) {
+	return s.GetObjectWithOptions(ctx, prefix, key, s3.ApplyGetOptions(s3.GetOptions{}, opts...))
+}
+
+func (s *Storage) GetObjectWithOptions(_ context.Context, prefix string, key string, opts s3.GetOptions) (io.ReadCloser, error) {
+	objName := s3.JoinKey(prefix, key)
+	info, err := s.stat("GetObject", objName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IfMatch != "" && !s3.MatchETag(info.ETag, opts.IfMatch) {
+		return nil, s.preconditionFailed("GetObject", objName)
+	}
+	if opts.IfNoneMatch != "" && s3.MatchETag(info.ETag, opts.IfNoneMatch) {
+		return nil, s.operationError("GetObject", objName, s3.ErrNotModified)
+	}
+	if !opts.IfModifiedSince.IsZero() && !info.LastModified.After(opts.IfModifiedSince) {
+		return nil, s.operationError("GetObject", objName, s3.ErrNotModified)
+	}
+
+	path, _ := s.path(objName)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, s.notFound("GetObject", objName)
+		}
+		return nil, s.operationError("GetObject", objName, fmt.Errorf("failed to open object: %w", err))
+	}
+	if opts.Range != nil {
+		start, end, ok := opts.Range.Offsets(info.Size)
+		if !ok {
+			_ = f.Close()
+			return nil, s.invalidRange("GetObject", objName)
+		}
+		return &rangeReader{
+			Reader: io.NewSectionReader(f, start, end-start+1),
+			Closer: f,
+		}, nil
+	}
+	return f, nil
+}
+
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (s *Storage) PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	return s.PutObjectWithOptions(ctx, prefix, key, reader, objectSize, s3.ApplyPutOptions(s3.PutOptions{
+		ContentType: contentType,
+	}, opts...))
+}
+
+func (s *Storage) PutObjectWithOptions(_ context.Context, prefix string, key string, reader io.Reader, objectSize int64, opts s3.PutOptions) (minio.UploadInfo, error) {
+	objName := s3.JoinKey(prefix, key)
+	path, err := s.path(objName)
+	if err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, err)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, fmt.Errorf("failed to create object directory: %w", err))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPattern)
+	if err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, fmt.Errorf("failed to create object file: %w", err))
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if objectSize >= 0 {
+		reader = io.LimitReader(reader, objectSize)
+	}
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, fmt.Errorf("failed to write object: %w", err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.stat("PutObject", objName)
+	exists := err == nil
+	if err != nil && !errors.Is(err, s3.ErrObjectNotFound) {
+		return minio.UploadInfo{}, err
+	}
+	if opts.IfMatch != "" && (!exists || !s3.MatchETag(existing.ETag, opts.IfMatch)) {
+		return minio.UploadInfo{}, s.preconditionFailed("PutObject", objName)
+	}
+	if opts.IfNoneMatch == "*" && exists {
+		return minio.UploadInfo{}, s.preconditionFailed("PutObject", objName)
+	}
+	if opts.IfNoneMatch != "" && opts.IfNoneMatch != "*" && exists && s3.MatchETag(existing.ETag, opts.IfNoneMatch) {
+		return minio.UploadInfo{}, s.preconditionFailed("PutObject", objName)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, fmt.Errorf("failed to store object: %w", err))
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, fmt.Errorf("failed to stat object: %w", err))
+	}
+
+	meta := newMetadata(hex.EncodeToString(hash.Sum(nil)), n, stat.ModTime(), opts)
+	if err = s.writeMetadata(objName, meta); err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, err)
+	}
+
+	return minio.UploadInfo{
+		Bucket:       s.bucket,
+		Key:          objName,
+		ETag:         meta.ETag,
+		Size:         meta.Size,
+		LastModified: meta.ModTime,
+	}, nil
+}
+
+func (s *Storage) PutObjectIfAbsent(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string) (minio.UploadInfo, error) {
+	info, err := s.PutObjectWithOptions(ctx, prefix, key, reader, objectSize, s3.PutOptions{
+		ContentType: contentType,
+		IfNoneMatch: "*",
+	})
+	if errors.Is(err, s3.ErrPreconditionFailed) {
+		return info, fmt.Errorf("%w: %w", s3.ErrObjectExists, err)
+	}
+	return info, err
+}
+
+func (s *Storage) StatObject(_ context.Context, prefix string, key string) (minio.ObjectInfo, error) {
+	return s.stat("StatObject", s3.JoinKey(prefix, key))
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, prefix string, key string, opts ...s3.ObjectOption) error {
+	return s.DeleteObjectWithOptions(ctx, prefix, key, s3.ApplyDeleteOptions(s3.DeleteOptions{}, opts...))
+}
+
+func (s *Storage) DeleteObjectWithOptions(_ context.Context, prefix string, key string, deleteOpts s3.DeleteOptions) error {
+	objName := s3.JoinKey(prefix, key)
+	path, err := s.path(objName)
+	if err != nil {
+		return s.operationError("DeleteObject", objName, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if deleteOpts.IfMatch != "" {
+		info, err := s.stat("DeleteObject", objName)
+		if err != nil {
+			return err
+		}
+		if !s3.MatchETag(info.ETag, deleteOpts.IfMatch) {
+			return s.operationError("DeleteObject", objName, s3.ErrPreconditionFailed)
+		}
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return s.operationError("DeleteObject", objName, fmt.Errorf("failed to remove object: %w", err))
+	}
+	metaPath := s.metadataPath(objName)
+	if err = os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return s.operationError("DeleteObject", objName, fmt.Errorf("failed to remove object metadata: %w", err))
+	}
+
+	// S3 has no directories, so prefixes disappear along with their last object
+	s.removeEmptyDirs(filepath.Dir(path), s.root)
+	s.removeEmptyDirs(filepath.Dir(metaPath), filepath.Join(s.root, MetadataDir))
+	return nil
+}
+
+func (s *Storage) CopyObject(ctx context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	srcName := s3.JoinKey(srcPrefix, srcKey)
+	copyOpts := s3.ApplyCopyOptions(s3.CopyOptions{}, opts...)
+	info, err := s.stat("CopyObject", srcName)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if copyOpts.SourceIfMatch != "" && !s3.MatchETag(info.ETag, copyOpts.SourceIfMatch) {
+		return minio.UploadInfo{}, s.preconditionFailed("CopyObject", srcName)
+	}
+
+	path, _ := s.path(srcName)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return minio.UploadInfo{}, s.notFound("CopyObject", srcName)
+		}
+		return minio.UploadInfo{}, s.operationError("CopyObject", srcName, fmt.Errorf("failed to open object: %w", err))
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	putOpts := putOptions(info)
+	if copyOpts.ContentType != "" {
+		putOpts.ContentType = copyOpts.ContentType
+	}
+	if copyOpts.StorageClass != "" {
+		putOpts.StorageClass = copyOpts.StorageClass
+	}
+	for name, value := range copyOpts.Metadata {
+		putOpts.Metadata[http.CanonicalHeaderKey(name)] = value
+	}
+	return s.PutObjectWithOptions(ctx, dstPrefix, dstKey, f, info.Size, putOpts)
+}
+
+// ListObjects lists the objects directly under prefix in lexical order,
+// returning subdirectories as common prefixes ending in "/"
+func (s *Storage) ListObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	out := make(chan minio.ObjectInfo, 1)
+	go func() {
+		defer close(out)
+
+		listPrefix := s3.JoinKey(prefix, "")
+		dir, err := s.path(listPrefix)
+		if err != nil {
+			out <- minio.ObjectInfo{Err: s.operationError("ListObjects", listPrefix, err)}
+			return
+		}
+
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				out <- minio.ObjectInfo{Err: s.operationError("ListObjects", listPrefix, fmt.Errorf("failed to read directory: %w", err))}
+			}
+			return
+		}
+
+		var entries []minio.ObjectInfo
+		for _, dirEntry := range dirEntries {
+			name := dirEntry.Name()
+			if (listPrefix == "" && name == MetadataDir) || isTemp(name) {
+				continue
+			}
+			if dirEntry.IsDir() {
+				entries = append(entries, minio.ObjectInfo{Key: listPrefix + name + "/"})
+				continue
+			}
+			info, err := s.stat("ListObjects", listPrefix+name)
+			if err != nil {
+				if errors.Is(err, s3.ErrObjectNotFound) {
+					continue
+				}
+				info = minio.ObjectInfo{Err: err}
+			}
+			entries = append(entries, info)
+		}
+
+		// Directory entries are sorted by name, but S3 sorts common prefixes
+		// by their full key including the trailing delimiter
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Key < entries[j].Key
+		})
+
+		for _, entry := range entries {
+			select {
+			case out <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+// path returns the file an object is kept in, rejecting names that would
+// resolve outside of the root or into the metadata directory
+func (s *Storage) path(objName string) (string, error) {
+	name := filepath.FromSlash(strings.TrimSuffix(objName, "/"))
+	if name == "" {
+		return s.root, nil
+	}
+	if !filepath.IsLocal(name) {
+		return "", &s3.KeyError{Key: objName, Reason: "key resolves outside of the root directory"}
+	}
+	if first, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(name)), "/"); first == MetadataDir || isTemp(first) {
+		return "", &s3.KeyError{Key: objName, Reason: "key is reserved by the file backend"}
+	}
+	return filepath.Join(s.root, name), nil
+}
+
+func (s *Storage) metadataPath(objName string) string {
+	return filepath.Join(s.root, MetadataDir, filepath.FromSlash(objName)+metadataSuffix)
+}
+
+func (s *Storage) stat(op string, objName string) (minio.ObjectInfo, error) {
+	path, err := s.path(objName)
+	if err != nil {
+		return minio.ObjectInfo{}, s.operationError(op, objName, err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil || stat.IsDir() {
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return minio.ObjectInfo{}, s.notFound(op, objName)
+		}
+		return minio.ObjectInfo{}, s.operationError(op, objName, fmt.Errorf("failed to stat object: %w", err))
+	}
+
+	meta, err := s.readMetadata(objName)
+	if err != nil || meta.Size != stat.Size() || !meta.ModTime.Equal(stat.ModTime()) {
+		// The file was created or changed outside of the backend
+		etag, err := hashFile(path)
+		if err != nil {
+			return minio.ObjectInfo{}, s.operationError(op, objName, err)
+		}
+		meta = newMetadata(etag, stat.Size(), stat.ModTime(), s3.PutOptions{})
+	}
+
+	headers := meta.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set("Content-Type", meta.ContentType)
+	userMetadata := make(minio.StringMap, len(meta.UserMetadata))
+	for name, value := range meta.UserMetadata {
+		userMetadata[name] = value
+		headers.Set("X-Amz-Meta-"+name, value)
+	}
+
+	return minio.ObjectInfo{
+		Key:          objName,
+		ETag:         meta.ETag,
+		Size:         meta.Size,
+		LastModified: meta.ModTime.UTC(),
+		ContentType:  meta.ContentType,
+		Metadata:     headers,
+		UserMetadata: userMetadata,
+		StorageClass: meta.StorageClass,
+	}, nil
+}
+
+func (s *Storage) readMetadata(objName string) (*metadata, error) {
+	data, err := os.ReadFile(s.metadataPath(objName))
+	if err != nil {
+		return nil, err
+	}
+	meta := new(metadata)
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *Storage) writeMetadata(objName string, meta *metadata) error {
+	path := s.metadataPath(objName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but not including, stop
+// for as long as they are empty
+func (s *Storage) removeEmptyDirs(dir string, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func newMetadata(etag string, size int64, modTime time.Time, opts s3.PutOptions) *metadata {
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	headers := http.Header{}
+	for name, value := range map[string]string{
+		"Cache-Control":       opts.CacheControl,
+		"Content-Disposition": opts.ContentDisposition,
+		"Content-Encoding":    opts.ContentEncoding,
+		"Content-Language":    opts.ContentLanguage,
+	} {
+		if value != "" {
+			headers.Set(name, value)
+		}
+	}
+
+	userMetadata := make(map[string]string)
+	for name, value := range opts.Metadata {
+		userMetadata[http.CanonicalHeaderKey(name)] = value
+	}
+	if opts.TTL > 0 {
+		userMetadata[s3.ExpiresAtMetadata] = time.Now().Add(opts.TTL).UTC().Format(time.RFC3339)
+	}
+
+	storageClass := opts.StorageClass
+	if storageClass == "" {
+		storageClass = s3.StorageClassStandard
+	}
+
+	return &metadata{
+		ETag:         etag,
+		Size:         size,
+		ModTime:      modTime,
+		ContentType:  contentType,
+		Headers:      headers,
+		UserMetadata: userMetadata,
+		StorageClass: storageClass,
+	}
+}
+
+// putOptions returns the options that recreate an object's metadata when
+// copying it. The expiry time in its user metadata is kept as is.
+func putOptions(info minio.ObjectInfo) s3.PutOptions {
+	metadata := make(map[string]string, len(info.UserMetadata))
+	for name, value := range info.UserMetadata {
+		metadata[name] = value
+	}
+	return s3.PutOptions{
+		Metadata:           metadata,
+		ContentType:        info.ContentType,
+		CacheControl:       info.Metadata.Get("Cache-Control"),
+		ContentDisposition: info.Metadata.Get("Content-Disposition"),
+		ContentEncoding:    info.Metadata.Get("Content-Encoding"),
+		ContentLanguage:    info.Metadata.Get("Content-Language"),
+		StorageClass:       info.StorageClass,
+	}
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open object: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash object: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isTemp(name string) bool {
+	matched, _ := filepath.Match(tempPattern, name)
+	return matched
+}
+
+func (s *Storage) operationError(op string, objName string, err error) error {
+	return &s3.OperationError{
+		Op:     op,
+		Bucket: s.bucket,
+		Key:    objName,
+		Err:    err,
+	}
+}
+
+func (s *Storage) notFound(op string, objName string) error {
+	return s.operationError(op, objName, fmt.Errorf("%w: %w", s3.ErrObjectNotFound, minio.ErrorResponse{
+		Code:       "NoSuchKey",
+		Message:    "The specified key does not exist.",
+		BucketName: s.bucket,
+		Key:        objName,
+		StatusCode: http.StatusNotFound,
+	}))
+}
+
+func (s *Storage) invalidRange(op string, objName string) error {
+	return s.operationError(op, objName, minio.ErrorResponse{
+		Code:       "InvalidRange",
+		Message:    "The requested range is not satisfiable",
+		BucketName: s.bucket,
+		Key:        objName,
+		StatusCode: http.StatusRequestedRangeNotSatisfiable,
+	})
+}
+
+func (s *Storage) preconditionFailed(op string, objName string) error {
+	return s.operationError(op, objName, fmt.Errorf("%w: %w", s3.ErrPreconditionFailed, minio.ErrorResponse{
+		Code:       "PreconditionFailed",
+		Message:    "At least one of the pre-conditions you specified did not hold",
+		BucketName: s.bucket,
+		Key:        objName,
+		StatusCode: http.StatusPreconditionFailed,
+	}))
+}
@@ -0,0 +1,196 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3fsbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestStorage(t *testing.T) {
+	s3test.RunStorageTests(t, func(t *testing.T) s3.Storage {
+		s, err := New(t.TempDir(), "bucket")
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		return s
+	})
+}
+
+func newStorage(t *testing.T, root string) *Storage {
+	t.Helper()
+	s, err := New(root, "bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return s
+}
+
+func TestPersistence(t *testing.T) {
+	root := t.TempDir()
+	data := []byte("hello world")
+	if _, err := newStorage(t, root).PutObjectWithOptions(context.Background(), "data", "dir/a", bytes.NewReader(data), int64(len(data)), s3.PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Owner": "test"},
+	}); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	// Objects are plain files under the root and survive a new Storage
+	stored, err := os.ReadFile(filepath.Join(root, "data", "dir", "a"))
+	if err != nil {
+		t.Fatalf("failed to read object file: %v", err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("expected %q in the object file, got %q", data, stored)
+	}
+	info, err := newStorage(t, root).StatObject(context.Background(), "data", "dir/a")
+	if err != nil {
+		t.Fatalf("failed to stat object: %v", err)
+	}
+	if info.ContentType != "text/plain" || info.UserMetadata["Owner"] != "test" {
+		t.Fatalf("expected content type and metadata to persist, got %q and %v", info.ContentType, info.UserMetadata)
+	}
+}
+
+func TestExternalFiles(t *testing.T) {
+	root := t.TempDir()
+	s := newStorage(t, root)
+	data := []byte("hello world")
+	if _, err := s.PutObject(context.Background(), "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	// Files written or changed outside of the backend are hashed on demand
+	changed := []byte("changed by hand")
+	if err := os.WriteFile(filepath.Join(root, "data", "a"), changed, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "data", "b"), data, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	for key, expected := range map[string][]byte{"a": changed, "b": data} {
+		sum := md5.Sum(expected)
+		info, err := s.StatObject(context.Background(), "data", key)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", key, err)
+		}
+		if info.ETag != hex.EncodeToString(sum[:]) || info.Size != int64(len(expected)) {
+			t.Fatalf("expected the ETag and size of the file contents for %s, got %s and %d", key, info.ETag, info.Size)
+		}
+		reader, err := s.GetObject(context.Background(), "data", key)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", key, err)
+		}
+		read, err := io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+		if !bytes.Equal(read, expected) {
+			t.Fatalf("expected %q for %s, got %q", expected, key, read)
+		}
+	}
+
+	// The metadata directory is never listed
+	for object := range s.ListObjects(context.Background(), "") {
+		if object.Err != nil {
+			t.Fatalf("failed to list objects: %v", object.Err)
+		}
+		if object.Key != "data/" {
+			t.Fatalf("expected only the data prefix at the root, got %s", object.Key)
+		}
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	root := t.TempDir()
+	s := newStorage(t, filepath.Join(root, "bucket"))
+	for _, key := range []string{"../escaped", "a/../../escaped", MetadataDir + "/a.json"} {
+		_, err := s.PutObject(context.Background(), "", key, bytes.NewReader([]byte("data")), 4, "text/plain")
+		var keyErr *s3.KeyError
+		if !errors.As(err, &keyErr) {
+			t.Fatalf("expected a KeyError for %s, got %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no file outside of the root, got %v", err)
+	}
+}
+
+func TestDeleteRemovesEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	s := newStorage(t, root)
+	for _, key := range []string{"dir/sub/a", "dir/b"} {
+		if _, err := s.PutObject(context.Background(), "data", key, bytes.NewReader([]byte("data")), 4, "text/plain"); err != nil {
+			t.Fatalf("failed to put object: %v", err)
+		}
+	}
+
+	if err := s.DeleteObject(context.Background(), "data", "dir/sub/a"); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "data", "dir", "sub")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the empty directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "data", "dir", "b")); err != nil {
+		t.Fatalf("expected the remaining object to be kept: %v", err)
+	}
+
+	if err := s.DeleteObject(context.Background(), "data", "dir/b"); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "data")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected all empty directories to be removed, got %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("expected the root to be kept: %v", err)
+	}
+}
+
+func TestNewFromEndpoint(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewFromEndpoint(Scheme+"://"+filepath.ToSlash(root), "bucket")
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if _, err = s.PutObject(context.Background(), "data", "a", bytes.NewReader([]byte("data")), 4, "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "bucket", "data", "a")); err != nil {
+		t.Fatalf("expected the object under the bucket directory: %v", err)
+	}
+
+	for _, endpoint := range []string{"http://localhost:9000", Scheme + "://"} {
+		if _, err = NewFromEndpoint(endpoint, "bucket"); !errors.Is(err, ErrInvalidEndpoint) {
+			t.Fatalf("expected ErrInvalidEndpoint for %s, got %v", endpoint, err)
+		}
+	}
+	if _, err = NewFromEndpoint(Scheme+"://"+filepath.ToSlash(root), ""); !errors.Is(err, ErrInvalidEndpoint) {
+		t.Fatalf("expected ErrInvalidEndpoint without a bucket, got %v", err)
+	}
+}
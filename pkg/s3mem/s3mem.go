@@ -0,0 +1,413 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package s3mem provides an in-memory implementation of s3.Storage for tests.
+//
+// Objects are joined from prefixes and keys in the same way as the S3 client,
+// listings group common prefixes by "/", and conditional reads and writes
+// return the same errors as S3 (such as s3.ErrObjectNotFound and
+// s3.ErrPreconditionFailed). Presigned URLs use the "s3mem" scheme and can
+// only be resolved with URLObject.
+package s3mem
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+)
+
+const (
+	Scheme = "s3mem"
+)
+
+var (
+	ErrInvalidURL = errors.New("invalid s3mem url")
+)
+
+var _ s3.Storage = (*Storage)(nil)
+
+type object struct {
+	data []byte
+	info minio.ObjectInfo
+}
+
+// Storage is an in-memory s3.Storage, safe for concurrent use
+type Storage struct {
+	bucket string
+
+	mu      sync.RWMutex
+	objects map[string]*object
+}
+
+// New returns an empty Storage for the given bucket name
+func New(bucket string) *Storage {
+	return &Storage{
+		bucket:  bucket,
+		objects: make(map[string]*object),
+	}
+}
+
+func (s *Storage) PresignedGetObject(_ context.Context, prefix string, key string, expires time.Duration) (*url.URL, error) {
+	query := url.Values{}
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	return &url.URL{
+		Scheme:   Scheme,
+		Host:     s.bucket,
+		Path:     "/" + s3.JoinKey(prefix, key),
+		RawQuery: query.Encode(),
+	}, nil
+}
+
+// URLObject returns the contents of the object a presigned URL points to
+func (s *Storage) URLObject(u *url.URL) ([]byte, error) {
+	if u.Scheme != Scheme || u.Host != s.bucket {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, u)
+	}
+	objName := strings.TrimPrefix(u.Path, "/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[objName]
+	if !ok {
+		return nil, s.notFound("GetObject", objName)
+	}
+	return bytes.Clone(obj.data), nil
+}
+
+func (s *Storage) GetObject(ctx context.Context, prefix string, key string, opts ...s3.ObjectOption) (io.ReadCloser, error) {
+	return s.GetObjectWithOptions(ctx, prefix, key, s3.ApplyGetOptions(s3.GetOptions{}, opts...))
+}
+
+func (s *Storage) GetObjectWithOptions(_ context.Context, prefix string, key string, opts s3.GetOptions) (io.ReadCloser, error) {
+	objName := s3.JoinKey(prefix, key)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[objName]
+	if !ok {
+		return nil, s.notFound("GetObject", objName)
+	}
+	if opts.IfMatch != "" && !s3.MatchETag(obj.info.ETag, opts.IfMatch) {
+		return nil, s.preconditionFailed("GetObject", objName)
+	}
+	if opts.IfNoneMatch != "" && s3.MatchETag(obj.info.ETag, opts.IfNoneMatch) {
+		return nil, s.operationError("GetObject", objName, s3.ErrNotModified)
+	}
+	if !opts.IfModifiedSince.IsZero() && !obj.info.LastModified.After(opts.IfModifiedSince) {
+		return nil, s.operationError("GetObject", objName, s3.ErrNotModified)
+	}
+	if opts.Range != nil {
+		start, end, ok := opts.Range.Offsets(obj.info.Size)
+		if !ok {
+			return nil, s.invalidRange("GetObject", objName)
+		}
+		return io.NopCloser(bytes.NewReader(obj.data[start : end+1])), nil
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (s *Storage) PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	return s.PutObjectWithOptions(ctx, prefix, key, reader, objectSize, s3.ApplyPutOptions(s3.PutOptions{
+		ContentType: contentType,
+	}, opts...))
+}
+
+func (s *Storage) PutObjectWithOptions(_ context.Context, prefix string, key string, reader io.Reader, objectSize int64, opts s3.PutOptions) (minio.UploadInfo, error) {
+	objName := s3.JoinKey(prefix, key)
+
+	if objectSize >= 0 {
+		reader = io.LimitReader(reader, objectSize)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return minio.UploadInfo{}, s.operationError("PutObject", objName, fmt.Errorf("failed to read object: %w", err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, exists := s.objects[objName]
+	if opts.IfMatch != "" && (!exists || !s3.MatchETag(existing.info.ETag, opts.IfMatch)) {
+		return minio.UploadInfo{}, s.preconditionFailed("PutObject", objName)
+	}
+	if opts.IfNoneMatch == "*" && exists {
+		return minio.UploadInfo{}, s.preconditionFailed("PutObject", objName)
+	}
+	if opts.IfNoneMatch != "" && opts.IfNoneMatch != "*" && exists && s3.MatchETag(existing.info.ETag, opts.IfNoneMatch) {
+		return minio.UploadInfo{}, s.preconditionFailed("PutObject", objName)
+	}
+
+	obj := newObject(objName, data, opts)
+	s.objects[objName] = obj
+	return minio.UploadInfo{
+		Bucket:       s.bucket,
+		Key:          objName,
+		ETag:         obj.info.ETag,
+		Size:         obj.info.Size,
+		LastModified: obj.info.LastModified,
+	}, nil
+}
+
+func (s *Storage) PutObjectIfAbsent(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string) (minio.UploadInfo, error) {
+	info, err := s.PutObjectWithOptions(ctx, prefix, key, reader, objectSize, s3.PutOptions{
+		ContentType: contentType,
+		IfNoneMatch: "*",
+	})
+	if errors.Is(err, s3.ErrPreconditionFailed) {
+		return info, fmt.Errorf("%w: %w", s3.ErrObjectExists, err)
+	}
+	return info, err
+}
+
+func (s *Storage) StatObject(_ context.Context, prefix string, key string) (minio.ObjectInfo, error) {
+	objName := s3.JoinKey(prefix, key)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[objName]
+	if !ok {
+		return minio.ObjectInfo{}, s.notFound("StatObject", objName)
+	}
+	return copyInfo(obj.info), nil
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, prefix string, key string, opts ...s3.ObjectOption) error {
+	return s.DeleteObjectWithOptions(ctx, prefix, key, s3.ApplyDeleteOptions(s3.DeleteOptions{}, opts...))
+}
+
+func (s *Storage) DeleteObjectWithOptions(_ context.Context, prefix string, key string, deleteOpts s3.DeleteOptions) error {
+	objName := s3.JoinKey(prefix, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if deleteOpts.IfMatch != "" {
+		obj, ok := s.objects[objName]
+		if !ok {
+			return s.notFound("DeleteObject", objName)
+		}
+		if !s3.MatchETag(obj.info.ETag, deleteOpts.IfMatch) {
+			return s.operationError("DeleteObject", objName, s3.ErrPreconditionFailed)
+		}
+	}
+	delete(s.objects, objName)
+	return nil
+}
+
+func (s *Storage) CopyObject(_ context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	srcName := s3.JoinKey(srcPrefix, srcKey)
+	dstName := s3.JoinKey(dstPrefix, dstKey)
+	copyOpts := s3.ApplyCopyOptions(s3.CopyOptions{}, opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.objects[srcName]
+	if !ok {
+		return minio.UploadInfo{}, s.notFound("CopyObject", srcName)
+	}
+	if copyOpts.SourceIfMatch != "" && !s3.MatchETag(src.info.ETag, copyOpts.SourceIfMatch) {
+		return minio.UploadInfo{}, s.preconditionFailed("CopyObject", srcName)
+	}
+
+	info := copyInfo(src.info)
+	info.Key = dstName
+	if copyOpts.ContentType != "" {
+		info.ContentType = copyOpts.ContentType
+		info.Metadata.Set("Content-Type", copyOpts.ContentType)
+	}
+	if copyOpts.StorageClass != "" {
+		info.StorageClass = copyOpts.StorageClass
+	}
+	for name, value := range copyOpts.Metadata {
+		setUserMetadata(info, name, value)
+	}
+	info.LastModified = time.Now().UTC()
+	s.objects[dstName] = &object{
+		data: src.data,
+		info: info,
+	}
+	return minio.UploadInfo{
+		Bucket:       s.bucket,
+		Key:          dstName,
+		ETag:         info.ETag,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// ListObjects lists the objects directly under prefix in lexical order,
+// returning deeper objects as common prefixes ending in "/"
+func (s *Storage) ListObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	listPrefix := s3.JoinKey(prefix, "")
+
+	s.mu.RLock()
+	var entries []minio.ObjectInfo
+	commonPrefixes := make(map[string]struct{})
+	for objName, obj := range s.objects {
+		if !strings.HasPrefix(objName, listPrefix) {
+			continue
+		}
+		if i := strings.Index(objName[len(listPrefix):], "/"); i >= 0 {
+			commonPrefix := objName[:len(listPrefix)+i+1]
+			if _, ok := commonPrefixes[commonPrefix]; !ok {
+				commonPrefixes[commonPrefix] = struct{}{}
+				entries = append(entries, minio.ObjectInfo{Key: commonPrefix})
+			}
+			continue
+		}
+		entries = append(entries, copyInfo(obj.info))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	out := make(chan minio.ObjectInfo, 1)
+	go func() {
+		defer close(out)
+		for _, entry := range entries {
+			select {
+			case out <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+func newObject(objName string, data []byte, opts s3.PutOptions) *object {
+	sum := md5.Sum(data)
+	now := time.Now().UTC()
+
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	metadata := http.Header{}
+	metadata.Set("Content-Type", contentType)
+	for name, value := range map[string]string{
+		"Cache-Control":       opts.CacheControl,
+		"Content-Disposition": opts.ContentDisposition,
+		"Content-Encoding":    opts.ContentEncoding,
+		"Content-Language":    opts.ContentLanguage,
+	} {
+		if value != "" {
+			metadata.Set(name, value)
+		}
+	}
+
+	storageClass := opts.StorageClass
+	if storageClass == "" {
+		storageClass = s3.StorageClassStandard
+	}
+
+	info := minio.ObjectInfo{
+		Key:          objName,
+		ETag:         hex.EncodeToString(sum[:]),
+		Size:         int64(len(data)),
+		LastModified: now,
+		ContentType:  contentType,
+		Metadata:     metadata,
+		UserMetadata: make(minio.StringMap),
+		StorageClass: storageClass,
+	}
+	for name, value := range opts.Metadata {
+		setUserMetadata(info, name, value)
+	}
+	if opts.TTL > 0 {
+		setUserMetadata(info, s3.ExpiresAtMetadata, now.Add(opts.TTL).Format(time.RFC3339))
+	}
+
+	return &object{
+		data: data,
+		info: info,
+	}
+}
+
+// setUserMetadata sets user metadata in the same format as minio-go returns it
+func setUserMetadata(info minio.ObjectInfo, name string, value string) {
+	name = http.CanonicalHeaderKey(name)
+	info.UserMetadata[name] = value
+	info.Metadata.Set("X-Amz-Meta-"+name, value)
+}
+
+func copyInfo(info minio.ObjectInfo) minio.ObjectInfo {
+	info.Metadata = info.Metadata.Clone()
+	userMetadata := make(minio.StringMap, len(info.UserMetadata))
+	for name, value := range info.UserMetadata {
+		userMetadata[name] = value
+	}
+	info.UserMetadata = userMetadata
+	return info
+}
+
+func (s *Storage) operationError(op string, objName string, err error) error {
+	return &s3.OperationError{
+		Op:     op,
+		Bucket: s.bucket,
+		Key:    objName,
+		Err:    err,
+	}
+}
+
+func (s *Storage) notFound(op string, objName string) error {
+	return s.operationError(op, objName, fmt.Errorf("%w: %w", s3.ErrObjectNotFound, minio.ErrorResponse{
+		Code:       "NoSuchKey",
+		Message:    "The specified key does not exist.",
+		BucketName: s.bucket,
+		Key:        objName,
+		StatusCode: http.StatusNotFound,
+	}))
+}
+
+func (s *Storage) invalidRange(op string, objName string) error {
+	return s.operationError(op, objName, minio.ErrorResponse{
+		Code:       "InvalidRange",
+		Message:    "The requested range is not satisfiable",
+		BucketName: s.bucket,
+		Key:        objName,
+		StatusCode: http.StatusRequestedRangeNotSatisfiable,
+	})
+}
+
+func (s *Storage) preconditionFailed(op string, objName string) error {
+	return s.operationError(op, objName, fmt.Errorf("%w: %w", s3.ErrPreconditionFailed, minio.ErrorResponse{
+		Code:       "PreconditionFailed",
+		Message:    "At least one of the pre-conditions you specified did not hold",
+		BucketName: s.bucket,
+		Key:        objName,
+		StatusCode: http.StatusPreconditionFailed,
+	}))
+}
@@ -0,0 +1,71 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3mem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestStorage(t *testing.T) {
+	s3test.RunStorageTests(t, func(t *testing.T) s3.Storage {
+		return New("bucket")
+	})
+}
+
+func TestPresignedGetObject(t *testing.T) {
+	storage := New("bucket")
+	data := []byte("hello world")
+	if _, err := storage.PutObject(context.Background(), "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	u, err := storage.PresignedGetObject(context.Background(), "data", "a", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to presign object: %v", err)
+	}
+	if u.Query().Get("X-Amz-Expires") != "60" {
+		t.Fatalf("expected the expiry in the URL, got %s", u)
+	}
+	read, err := storage.URLObject(u)
+	if err != nil {
+		t.Fatalf("failed to read presigned URL: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("expected %q, got %q", data, read)
+	}
+
+	if _, err = New("other").URLObject(u); !errors.Is(err, ErrInvalidURL) {
+		t.Fatalf("expected ErrInvalidURL for another bucket, got %v", err)
+	}
+	if _, err = storage.URLObject(&url.URL{Scheme: "https", Host: "bucket", Path: "/data/a"}); !errors.Is(err, ErrInvalidURL) {
+		t.Fatalf("expected ErrInvalidURL for another scheme, got %v", err)
+	}
+	if err = storage.DeleteObject(context.Background(), "data", "a"); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	if _, err = storage.URLObject(u); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound for a deleted object, got %v", err)
+	}
+}
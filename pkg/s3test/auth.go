@@ -0,0 +1,250 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signatureAlgorithm = "AWS4-HMAC-SHA256"
+	chunkAlgorithm     = "AWS4-HMAC-SHA256-PAYLOAD"
+	signedChunks       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	unsignedPayload    = "UNSIGNED-PAYLOAD"
+	amzDateFormat      = "20060102T150405Z"
+
+	// maxClockSkew is how far the date of a signed request may be from the
+	// time of the Server, like S3 allows
+	maxClockSkew = 15 * time.Minute
+)
+
+var errChunkSignature = errors.New("chunk signature does not match")
+
+var (
+	errAccessDenied          = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied."}
+	errExpiredRequest        = &s3Error{http.StatusForbidden, "AccessDenied", "Request has expired"}
+	errRequestTimeTooSkewed  = &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large."}
+	errInvalidAccessKeyID    = &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."}
+	errSignatureDoesNotMatch = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your key and signing method."}
+	errMalformedAuth         = &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	errContentSHA256Mismatch = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+)
+
+// signature is the verified Signature Version 4 of a request, which the
+// chunks of streaming uploads are signed with
+type signature struct {
+	key   []byte
+	date  string
+	scope string
+	seed  string
+}
+
+type signatureKey struct{}
+
+// authenticate verifies the Signature Version 4 of a request, sent either
+// in the Authorization header or in the query of a presigned URL, and the
+// hash of its payload if it was signed. The signature is added to the
+// context of the returned request for readBody.
+func authenticate(r *http.Request) (*http.Request, *s3Error) {
+	var sig *signature
+	var err *s3Error
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), signatureAlgorithm+" "):
+		sig, err = verifyHeader(r)
+	case r.URL.Query().Get("X-Amz-Algorithm") == signatureAlgorithm:
+		sig, err = verifyQuery(r)
+	default:
+		return nil, errAccessDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); len(hash) == sha256.Size*2 {
+		data, readErr := io.ReadAll(r.Body)
+		if readErr != nil {
+			return nil, errIncompleteBody
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+			return nil, errContentSHA256Mismatch
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	return r.WithContext(context.WithValue(r.Context(), signatureKey{}, sig)), nil
+}
+
+func verifyHeader(r *http.Request) (*signature, *s3Error) {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), signatureAlgorithm+" "), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, errMalformedAuth
+		}
+		fields[name] = value
+	}
+
+	date := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(amzDateFormat, date)
+	if err != nil {
+		return nil, errMalformedAuth
+	}
+	if skew := time.Since(t); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = unsignedPayload
+	}
+	return verify(r, r.URL.Query(), fields["Credential"], fields["SignedHeaders"], fields["Signature"], date, payload)
+}
+
+func verifyQuery(r *http.Request) (*signature, *s3Error) {
+	query := r.URL.Query()
+	date := query.Get("X-Amz-Date")
+	t, err := time.Parse(amzDateFormat, date)
+	if err != nil {
+		return nil, errMalformedAuth
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil {
+		return nil, errMalformedAuth
+	}
+	if time.Now().After(t.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredRequest
+	}
+
+	provided := query.Get("X-Amz-Signature")
+	query.Del("X-Amz-Signature")
+	return verify(r, query, query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), provided, date, unsignedPayload)
+}
+
+// verify recomputes the signature of a request from its canonical form
+func verify(r *http.Request, query url.Values, credential string, signedHeaders string, provided string, date string, payload string) (*signature, *s3Error) {
+	accessKey, scope, ok := strings.Cut(credential, "/")
+	if !ok || signedHeaders == "" || provided == "" {
+		return nil, errMalformedAuth
+	}
+	if accessKey != AccessKey {
+		return nil, errInvalidAccessKeyID
+	}
+	parts := strings.Split(scope, "/")
+	if len(parts) != 4 || parts[3] != "aws4_request" || !strings.HasPrefix(date, parts[0]) {
+		return nil, errMalformedAuth
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			values := r.Header.Values(name)
+			for i := range values {
+				values[i] = strings.Join(strings.Fields(values[i]), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		encodePath(r.URL.Path),
+		strings.ReplaceAll(query.Encode(), "+", "%20"),
+		headers.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	key := []byte("AWS4" + SecretKey)
+	for _, part := range parts {
+		key = hmacSHA256(key, part)
+	}
+	sig := &signature{
+		key:   key,
+		date:  date,
+		scope: scope,
+		seed:  hex.EncodeToString(hmacSHA256(key, signatureAlgorithm+"\n"+date+"\n"+scope+"\n"+sha256Hex([]byte(canonical)))),
+	}
+	if !hmac.Equal([]byte(sig.seed), []byte(provided)) {
+		return nil, errSignatureDoesNotMatch
+	}
+	return sig, nil
+}
+
+// verifyChunk checks the signature of a chunk of a streaming upload, which
+// is chained to the signature of the previous chunk
+func (s *signature) verifyChunk(data []byte, provided string) error {
+	expected := hex.EncodeToString(hmacSHA256(s.key, strings.Join([]string{
+		chunkAlgorithm,
+		s.date,
+		s.scope,
+		s.seed,
+		sha256Hex(nil),
+		sha256Hex(data),
+	}, "\n")))
+	if !hmac.Equal([]byte(expected), []byte(provided)) {
+		return errChunkSignature
+	}
+	s.seed = expected
+	return nil
+}
+
+// encodePath encodes a path like the canonical URI of a signature, which
+// escapes every byte except unreserved characters and slashes
+func encodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+)
+
+func newClient(t *testing.T, options *s3.Options) *s3.S3 {
+	t.Helper()
+	client, err := s3.NewWithLogger(options, nil)
+	if err != nil {
+		t.Fatalf("failed to create s3 client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func TestAuthentication(t *testing.T) {
+	ctx := context.Background()
+	server := StartServer()
+	defer server.Close()
+
+	client := newClient(t, server.Options("bkt"))
+	if err := client.MakeBucket(ctx, "bkt"); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	data := []byte("hello world")
+	if _, err := client.PutObject(ctx, "auth", "key", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	options := server.Options("bkt")
+	options.SecretKey = "wrong"
+	wrong := newClient(t, options)
+	if _, err := wrong.StatObject(ctx, "auth", "key"); err == nil || errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected a wrong secret key to be rejected, got %v", err)
+	}
+	if _, err := wrong.PutObject(ctx, "auth", "other", bytes.NewReader(data), int64(len(data)), "text/plain"); err == nil {
+		t.Fatal("expected a wrong secret key to be rejected")
+	}
+
+	u, err := client.PresignedGetObject(ctx, "auth", "key", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to presign object: %v", err)
+	}
+	if body := get(t, u.String(), http.StatusOK); !bytes.Equal(body, data) {
+		t.Fatalf("expected %q from presigned url, got %q", data, body)
+	}
+
+	// Tampering with the presigned url invalidates the signature
+	u.Path += "x"
+	get(t, u.String(), http.StatusForbidden)
+	u.RawQuery = ""
+	get(t, u.String(), http.StatusForbidden)
+}
+
+func get(t *testing.T, url string, status int) []byte {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("expected status %d for %s, got %d: %s", status, url, resp.StatusCode, body)
+	}
+	return body
+}
@@ -0,0 +1,303 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+)
+
+const (
+	// RecordEnv is the environment variable that switches NewRecordedClient
+	// from replaying fixtures to recording them
+	RecordEnv = "S3TEST_RECORD"
+
+	replayEndpoint = "s3test.invalid"
+)
+
+var (
+	ErrNotRecording = errors.New("recorder is not recording")
+)
+
+// Mode selects whether a Recorder records or replays interactions
+type Mode int
+
+const (
+	// ModeReplay serves responses from a fixture without sending any requests
+	ModeReplay Mode = iota
+
+	// ModeRecord sends requests and records them and their responses
+	ModeRecord
+)
+
+// Interaction is a recorded request and its response. Requests are matched
+// by method, path and query only, since their signatures and bodies change
+// with every run.
+type Interaction struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+type fixture struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records S3 interactions to a
+// fixture file or replays them from one. Replayed interactions are matched
+// in the order they were recorded, so repeated requests get the responses
+// they got while recording.
+type Recorder struct {
+	mode Mode
+	path string
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	replayed     []bool
+	misses       []string
+}
+
+// NewRecorder creates a Recorder for the fixture at path. In ModeRecord
+// requests are sent with next, or http.DefaultTransport if it is nil, and
+// the fixture is written by Save. In ModeReplay the fixture is loaded
+// immediately and must exist.
+func NewRecorder(path string, mode Mode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{
+		mode: mode,
+		path: path,
+		next: next,
+	}
+
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %w", err)
+		}
+		f := new(fixture)
+		if err = json.Unmarshal(data, f); err != nil {
+			return nil, fmt.Errorf("failed to decode fixture: %w", err)
+		}
+		r.interactions = f.Interactions
+		r.replayed = make([]bool, len(f.Interactions))
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == ModeRecord {
+		return r.record(req)
+	}
+	return r.replay(req), nil
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, &Interaction{
+		Method:     req.Method,
+		Path:       req.URL.EscapedPath(),
+		Query:      matchQuery(req.URL.Query()),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+	})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request) *http.Response {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+
+	path := req.URL.EscapedPath()
+	query := matchQuery(req.URL.Query())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		if r.replayed[i] || interaction.Method != req.Method || interaction.Path != path || interaction.Query != query {
+			continue
+		}
+		r.replayed[i] = true
+		return &http.Response{
+			Status:        strconv.Itoa(interaction.StatusCode) + " " + http.StatusText(interaction.StatusCode),
+			StatusCode:    interaction.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(interaction.Body)),
+			ContentLength: int64(len(interaction.Body)),
+			Request:       req,
+		}
+	}
+
+	// Misses are answered with an error that is not retried, so that
+	// tests fail quickly instead of backing off
+	miss := req.Method + " " + path
+	if query != "" {
+		miss += "?" + query
+	}
+	r.misses = append(r.misses, miss)
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: "NotImplemented", Message: "no recorded interaction matches " + miss})
+	return &http.Response{
+		Status:        "501 " + http.StatusText(http.StatusNotImplemented),
+		StatusCode:    http.StatusNotImplemented,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/xml"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Misses returns the requests that could not be replayed
+func (r *Recorder) Misses() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.misses...)
+}
+
+// Save writes the recorded interactions to the fixture
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return ErrNotRecording
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(&fixture{Interactions: r.interactions}, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %w", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	if err = os.WriteFile(r.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// NewRecordedClient returns a client that replays the interactions in the
+// fixture, so tests need neither network access nor credentials. If the
+// RecordEnv environment variable is set, requests are sent to the endpoint
+// in options instead and the fixture is rewritten when the test ends. The
+// bucket and prefixes used by the test must be the same in both modes.
+func NewRecordedClient(t testing.TB, path string, options *s3.Options, opts ...s3.Option) *s3.S3 {
+	t.Helper()
+
+	mode := ModeReplay
+	o := *options
+	if os.Getenv(RecordEnv) != "" {
+		mode = ModeRecord
+	} else {
+		if o.Endpoint == "" {
+			o.Endpoint = replayEndpoint
+		}
+		if o.Region == "" {
+			o.Region = Region
+		}
+	}
+
+	next := o.Transport
+	if mode == ModeRecord && next == nil {
+		transport, err := minio.DefaultTransport(o.Secure)
+		if err != nil {
+			t.Fatalf("failed to create transport: %v", err)
+		}
+		next = transport
+	}
+	recorder, err := NewRecorder(path, mode, next)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	o.Transport = recorder
+
+	client, err := s3.NewWithLogger(&o, nil, opts...)
+	if err != nil {
+		t.Fatalf("failed to create s3 client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		if mode == ModeRecord {
+			if err := recorder.Save(); err != nil {
+				t.Errorf("failed to save fixture: %v", err)
+			}
+			return
+		}
+		if misses := recorder.Misses(); len(misses) > 0 {
+			t.Errorf("requests missing from fixture %s:\n%s", path, strings.Join(misses, "\n"))
+		}
+	})
+	return client
+}
+
+// matchQuery encodes the parts of a query that identify a request, leaving
+// out the signing parameters of presigned URLs
+func matchQuery(query url.Values) string {
+	for name := range query {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			query.Del(name)
+		}
+	}
+	return query.Encode()
+}
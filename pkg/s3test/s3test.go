@@ -0,0 +1,131 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package s3test provides helpers for testing code that uses the S3 client,
+// against an in-process S3 compatible server without Docker or network
+// access, or against a real S3 compatible service such as MinIO if
+// EndpointEnv is set.
+package s3test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+)
+
+const (
+	// EndpointEnv switches NewServer from the in-process Server to the S3
+	// compatible service at the given host and port, for example MinIO
+	// started with docker run -p 9000:9000 minio/minio server /data. The
+	// service is used with the credentials in AccessKeyEnv and SecretKeyEnv,
+	// over TLS if SecureEnv is true, and in the region in RegionEnv or Region.
+	EndpointEnv  = "S3TEST_ENDPOINT"
+	AccessKeyEnv = "S3TEST_ACCESS_KEY"
+	SecretKeyEnv = "S3TEST_SECRET_KEY"
+	SecureEnv    = "S3TEST_SECURE"
+	RegionEnv    = "S3TEST_REGION"
+)
+
+// NewServer creates an empty bucket and returns a client for it, on a new
+// Server or on the service in EndpointEnv if it is set. The client and
+// Server are closed when the test ends, and buckets on the service are
+// emptied and removed.
+func NewServer(t testing.TB, opts ...s3.Option) *s3.S3 {
+	t.Helper()
+
+	bucket := "s3test-" + strings.ToLower(randomID())
+	options, external := externalOptions(t, bucket)
+	if !external {
+		server := StartServer()
+		t.Cleanup(server.Close)
+		options = server.Options(bucket)
+	}
+
+	client, err := s3.NewWithLogger(options, nil, opts...)
+	if err != nil {
+		t.Fatalf("failed to create s3 client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	ctx := context.Background()
+	if err = client.MakeBucket(ctx, bucket); err != nil {
+		t.Fatalf("failed to create s3 bucket: %v", err)
+	}
+	if external {
+		t.Cleanup(func() {
+			cleanup, err := s3.NewWithLogger(options, nil)
+			if err != nil {
+				t.Errorf("failed to create s3 client: %v", err)
+				return
+			}
+			defer cleanup.Close()
+			if _, err = cleanup.DeletePrefix(ctx, "", 4); err != nil {
+				t.Errorf("failed to empty s3 bucket: %v", err)
+			}
+			if err = cleanup.RemoveBucket(ctx, bucket); err != nil {
+				t.Errorf("failed to remove s3 bucket: %v", err)
+			}
+		})
+	}
+	return client
+}
+
+// externalOptions returns the options for a client of the given bucket on
+// the service in EndpointEnv, and false if it is not set
+func externalOptions(t testing.TB, bucket string) (*s3.Options, bool) {
+	endpoint := os.Getenv(EndpointEnv)
+	if endpoint == "" {
+		return nil, false
+	}
+	secure := false
+	if value := os.Getenv(SecureEnv); value != "" {
+		var err error
+		if secure, err = strconv.ParseBool(value); err != nil {
+			t.Fatalf("invalid %s: %v", SecureEnv, err)
+		}
+	}
+	region := os.Getenv(RegionEnv)
+	if region == "" {
+		region = Region
+	}
+	return &s3.Options{
+		Endpoint:  endpoint,
+		Secure:    secure,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: os.Getenv(AccessKeyEnv),
+		SecretKey: os.Getenv(SecretKeyEnv),
+	}, true
+}
+
+// Options returns options for a client of the given bucket on the Server.
+// The bucket is not created.
+func (s *Server) Options(bucket string) *s3.Options {
+	return &s3.Options{
+		Endpoint:  s.Endpoint(),
+		Secure:    false,
+		Region:    Region,
+		Bucket:    bucket,
+		AccessKey: AccessKey,
+		SecretKey: SecretKey,
+	}
+}
@@ -0,0 +1,944 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Region is the region the Server reports for every bucket
+	Region = "us-east-1"
+
+	// AccessKey and SecretKey are the only credentials the Server accepts
+	AccessKey = "s3test"
+	SecretKey = "s3test-secret"
+
+	maxKeys = 1000
+
+	streamingPayload = "STREAMING-"
+	xmlns            = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// storedHeaders are the request headers kept with an object and returned
+// when it is read, in addition to user metadata
+var storedHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"Expires",
+	"X-Amz-Storage-Class",
+	taggingHeader,
+}
+
+// taggingHeader holds the tags of an object as a query string, it is
+// returned by GetObjectTagging instead of with the object
+const taggingHeader = "X-Amz-Tagging"
+
+var errMalformedChunk = errors.New("malformed aws-chunked body")
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+	header  http.Header
+}
+
+type upload struct {
+	bucket string
+	key    string
+	header http.Header
+	parts  map[int]*object
+}
+
+type bucket struct {
+	created time.Time
+	objects map[string]*object
+}
+
+type s3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errNoSuchBucket       = &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey          = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errNoSuchUpload       = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist."}
+	errBucketExists       = &s3Error{http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it."}
+	errBucketNotEmpty     = &s3Error{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errPreconditionFailed = &s3Error{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+	errInvalidRange       = &s3Error{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+	errInvalidPart        = &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found."}
+	errInvalidObjectState = &s3Error{http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's storage class"}
+	errMalformedXML       = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	errIncompleteBody     = &s3Error{http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header."}
+	errNotImplemented     = &s3Error{http.StatusNotImplemented, "NotImplemented", "A header you provided implies functionality that is not implemented"}
+)
+
+// Server is an in-memory S3 compatible HTTP server implementing the subset
+// of the S3 API used by the S3 client: buckets, objects with metadata, tags
+// and conditional writes, ranged and conditional reads, copies, V1 and V2
+// listings, batch deletes and multipart uploads. Requests must be signed
+// with Signature Version 4 using AccessKey and SecretKey, including the
+// payload hashes and the chunks of streaming uploads.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	uploads map[string]*upload
+}
+
+// StartServer starts a Server on a local port, which must be closed with
+// Close
+func StartServer() *Server {
+	s := &Server{
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*upload),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Endpoint returns the host and port of the Server, for Options.Endpoint
+func (s *Server) Endpoint() string {
+	return s.Listener.Addr().String()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Amz-Request-Id", randomID())
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	signed, err := authenticate(r)
+	switch {
+	case err != nil:
+	case bucketName == "":
+		err = errNotImplemented
+	case key == "":
+		err = s.serveBucket(w, signed, bucketName, query)
+	default:
+		err = s.serveObject(w, signed, bucketName, key, query)
+	}
+	if err != nil {
+		writeError(w, r, err, bucketName, key)
+	}
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, bucketName string, query url.Values) *s3Error {
+	switch {
+	case r.Method == http.MethodHead:
+		return s.headBucket(w, bucketName)
+	case r.Method == http.MethodPut && len(query) == 0:
+		return s.makeBucket(w, bucketName)
+	case r.Method == http.MethodDelete && len(query) == 0:
+		return s.removeBucket(w, bucketName)
+	case r.Method == http.MethodGet && query.Has("location"):
+		return s.bucketLocation(w, bucketName)
+	case r.Method == http.MethodGet && (query.Get("list-type") == "2" || isListV1(query)):
+		return s.listObjects(w, bucketName, query)
+	case r.Method == http.MethodPost && query.Has("delete"):
+		return s.deleteObjects(w, r, bucketName)
+	}
+	return errNotImplemented
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, bucketName string, key string, query url.Values) *s3Error {
+	switch {
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		return s.objectTagging(w, bucketName, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return s.getObject(w, r, bucketName, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		return s.uploadPart(w, r, bucketName, key, query)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		return s.copyObject(w, r, bucketName, key)
+	case r.Method == http.MethodPut:
+		return s.putObject(w, r, bucketName, key)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		return s.createUpload(w, r, bucketName, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		return s.completeUpload(w, r, bucketName, key, query.Get("uploadId"))
+	case r.Method == http.MethodPost && query.Has("restore"):
+		return s.restoreObject(bucketName, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		return s.abortUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		return s.deleteObject(w, r, bucketName, key)
+	}
+	return errNotImplemented
+}
+
+func (s *Server) headBucket(w http.ResponseWriter, bucketName string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucketName]; !ok {
+		return errNoSuchBucket
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) makeBucket(w http.ResponseWriter, bucketName string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucketName]; ok {
+		return errBucketExists
+	}
+	s.buckets[bucketName] = &bucket{
+		created: time.Now().UTC(),
+		objects: make(map[string]*object),
+	}
+	w.Header().Set("Location", "/"+bucketName)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) removeBucket(w http.ResponseWriter, bucketName string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return errNoSuchBucket
+	}
+	if len(b.objects) > 0 {
+		return errBucketNotEmpty
+	}
+	delete(s.buckets, bucketName)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) bucketLocation(w http.ResponseWriter, bucketName string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucketName]; !ok {
+		return errNoSuchBucket
+	}
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"LocationConstraint"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string   `xml:",chardata"`
+	}{Xmlns: xmlns, Location: Region})
+	return nil
+}
+
+type listEntry struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type listPrefix struct {
+	Prefix string
+}
+
+// isListV1 returns whether the query is a ListObjects V1 request, which is
+// only told apart from other bucket GETs by its parameters
+func isListV1(query url.Values) bool {
+	for name := range query {
+		switch name {
+		case "prefix", "delimiter", "marker", "max-keys", "encoding-type":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, bucketName string, query url.Values) *s3Error {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	// ListObjects V1 pages with a marker instead of a continuation token
+	v1 := !query.Has("list-type")
+	start := query.Get("continuation-token")
+	if start == "" {
+		start = query.Get("start-after")
+	}
+	if v1 {
+		start = query.Get("marker")
+	}
+	limit := maxKeys
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n > 0 && n < maxKeys {
+		limit = n
+	}
+
+	s.mu.Lock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		s.mu.Unlock()
+		return errNoSuchBucket
+	}
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var contents []listEntry
+	var commonPrefixes []listPrefix
+	truncated := false
+	last := ""
+	for _, key := range keys {
+		name := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				name = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if name <= start || name == last {
+			continue
+		}
+		if len(contents)+len(commonPrefixes) == limit {
+			truncated = true
+			break
+		}
+		last = name
+		if name != key {
+			commonPrefixes = append(commonPrefixes, listPrefix{Prefix: name})
+			continue
+		}
+		obj := b.objects[key]
+		contents = append(contents, listEntry{
+			Key:          key,
+			LastModified: obj.modTime,
+			ETag:         quote(obj.etag),
+			Size:         int64(len(obj.data)),
+			StorageClass: storageClass(obj),
+		})
+	}
+	s.mu.Unlock()
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Marker                string `xml:",omitempty"`
+		NextMarker            string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		KeyCount              int
+		MaxKeys               int
+		Delimiter             string `xml:",omitempty"`
+		IsTruncated           bool
+		Contents              []listEntry
+		CommonPrefixes        []listPrefix
+	}{
+		Xmlns:             xmlns,
+		Name:              bucketName,
+		Prefix:            prefix,
+		Marker:            query.Get("marker"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		KeyCount:          len(contents) + len(commonPrefixes),
+		MaxKeys:           limit,
+		Delimiter:         delimiter,
+		IsTruncated:       truncated,
+		Contents:          contents,
+		CommonPrefixes:    commonPrefixes,
+	}
+	switch {
+	case truncated && v1:
+		result.NextMarker = last
+	case truncated:
+		result.NextContinuationToken = last
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucketName string) *s3Error {
+	var req struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+
+	type deleted struct {
+		Key string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Deleted []deleted
+	}{Xmlns: xmlns}
+
+	s.mu.Lock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		s.mu.Unlock()
+		return errNoSuchBucket
+	}
+	for _, obj := range req.Objects {
+		delete(b.objects, obj.Key)
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deleted{Key: obj.Key})
+		}
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) *s3Error {
+	s.mu.Lock()
+	obj, err := s.object(bucketName, key)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" && !matches(match, obj) {
+		return errPreconditionFailed
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && matches(match, obj) {
+		writeObjectHeaders(w, obj)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !obj.modTime.Truncate(time.Second).After(since) {
+		writeObjectHeaders(w, obj)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	data := obj.data
+	status := http.StatusOK
+	writeObjectHeaders(w, obj)
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, ok := parseRange(rangeHeader, int64(len(data)))
+		if !ok {
+			return errInvalidRange
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+	return nil
+}
+
+func (s *Server) objectTagging(w http.ResponseWriter, bucketName string, key string) *s3Error {
+	s.mu.Lock()
+	obj, err := s.object(bucketName, key)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	type tag struct {
+		Key   string
+		Value string
+	}
+	var tags []tag
+	values, _ := url.ParseQuery(obj.header.Get(taggingHeader))
+	for name := range values {
+		tags = append(tags, tag{Key: name, Value: values.Get(name)})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"Tagging"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Tags    []tag    `xml:"TagSet>Tag"`
+	}{Xmlns: xmlns, Tags: tags})
+	return nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) *s3Error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	obj := newObject(data, objectHeader(r.Header))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.store(r, bucketName, key, obj); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", quote(obj.etag))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) *s3Error {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return errNoSuchKey
+	}
+	source, _, _ = strings.Cut(source, "?")
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, s3Err := s.object(srcBucket, srcKey)
+	if s3Err != nil {
+		return s3Err
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && !matches(match, src) {
+		return errPreconditionFailed
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); match != "" && matches(match, src) {
+		return errPreconditionFailed
+	}
+
+	header := src.header.Clone()
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		header = objectHeader(r.Header)
+	}
+	obj := newObject(src.data, header)
+	if s3Err = s.store(r, bucketName, key, obj); s3Err != nil {
+		return s3Err
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		Xmlns        string   `xml:"xmlns,attr"`
+		LastModified time.Time
+		ETag         string
+	}{Xmlns: xmlns, LastModified: obj.modTime, ETag: quote(obj.etag)})
+	return nil
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return errNoSuchBucket
+	}
+	if match := r.Header.Get("If-Match"); match != "" {
+		obj, exists := b.objects[key]
+		if !exists {
+			return errNoSuchKey
+		}
+		if !matches(match, obj) {
+			return errPreconditionFailed
+		}
+	}
+	delete(b.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) restoreObject(bucketName string, key string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.object(bucketName, key); err != nil {
+		return err
+	}
+	// Every object is kept in the standard storage class
+	return errInvalidObjectState
+}
+
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request, bucketName string, key string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucketName]; !ok {
+		return errNoSuchBucket
+	}
+	uploadID := randomID()
+	s.uploads[uploadID] = &upload{
+		bucket: bucketName,
+		key:    key,
+		header: objectHeader(r.Header),
+		parts:  make(map[int]*object),
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Xmlns: xmlns, Bucket: bucketName, Key: key, UploadId: uploadID})
+	return nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucketName string, key string, query url.Values) *s3Error {
+	partNumber, convErr := strconv.Atoi(query.Get("partNumber"))
+	if convErr != nil || partNumber < 1 {
+		return errInvalidPart
+	}
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	part := newObject(data, nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[query.Get("uploadId")]
+	if !ok || u.bucket != bucketName || u.key != key {
+		return errNoSuchUpload
+	}
+	u.parts[partNumber] = part
+	w.Header().Set("ETag", quote(part.etag))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, bucketName string, key string, uploadID string) *s3Error {
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errMalformedXML
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[uploadID]
+	if !ok || u.bucket != bucketName || u.key != key {
+		return errNoSuchUpload
+	}
+
+	var data bytes.Buffer
+	sums := md5.New()
+	for _, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok || unquote(p.ETag) != part.etag {
+			return errInvalidPart
+		}
+		data.Write(part.data)
+		sum, _ := hex.DecodeString(part.etag)
+		sums.Write(sum)
+	}
+
+	obj := newObject(data.Bytes(), u.header)
+	obj.etag = fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), len(req.Parts))
+	if err := s.store(r, bucketName, key, obj); err != nil {
+		return err
+	}
+	delete(s.uploads, uploadID)
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Xmlns: xmlns, Location: "/" + bucketName + "/" + key, Bucket: bucketName, Key: key, ETag: quote(obj.etag)})
+	return nil
+}
+
+func (s *Server) abortUpload(w http.ResponseWriter, uploadID string) *s3Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[uploadID]; !ok {
+		return errNoSuchUpload
+	}
+	delete(s.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// object must be called with mu held
+func (s *Server) object(bucketName string, key string) (*object, *s3Error) {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, errNoSuchBucket
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, errNoSuchKey
+	}
+	return obj, nil
+}
+
+// store writes an object if the conditions in the request hold, and must be
+// called with mu held
+func (s *Server) store(r *http.Request, bucketName string, key string, obj *object) *s3Error {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return errNoSuchBucket
+	}
+	existing, exists := b.objects[key]
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || !matches(match, existing)) {
+		return errPreconditionFailed
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && exists && matches(match, existing) {
+		return errPreconditionFailed
+	}
+	b.objects[key] = obj
+	return nil
+}
+
+func newObject(data []byte, header http.Header) *object {
+	sum := md5.Sum(data)
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/octet-stream")
+	}
+	return &object{
+		data:    bytes.Clone(data),
+		etag:    hex.EncodeToString(sum[:]),
+		modTime: time.Now().UTC(),
+		header:  header,
+	}
+}
+
+// objectHeader returns the headers of a request that are stored with an object
+func objectHeader(requestHeader http.Header) http.Header {
+	header := http.Header{}
+	for _, name := range storedHeaders {
+		if value := requestHeader.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	// aws-chunked describes the request body rather than the object
+	if encoding := header.Get("Content-Encoding"); encoding != "" {
+		var encodings []string
+		for _, e := range strings.Split(encoding, ",") {
+			if e = strings.TrimSpace(e); e != "" && e != "aws-chunked" {
+				encodings = append(encodings, e)
+			}
+		}
+		if len(encodings) > 0 {
+			header.Set("Content-Encoding", strings.Join(encodings, ","))
+		} else {
+			header.Del("Content-Encoding")
+		}
+	}
+	for name, values := range requestHeader {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), "X-Amz-Meta-") {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return header
+}
+
+func writeObjectHeaders(w http.ResponseWriter, obj *object) {
+	for name, values := range obj.header {
+		if name != taggingHeader {
+			w.Header()[name] = values
+		}
+	}
+	if tags, _ := url.ParseQuery(obj.header.Get(taggingHeader)); len(tags) > 0 {
+		w.Header().Set("X-Amz-Tagging-Count", strconv.Itoa(len(tags)))
+	}
+	w.Header().Set("ETag", quote(obj.etag))
+	w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
+func storageClass(obj *object) string {
+	if class := obj.header.Get("X-Amz-Storage-Class"); class != "" {
+		return class
+	}
+	return "STANDARD"
+}
+
+// readBody reads a request body, decoding it if it uses the aws-chunked
+// encoding of streaming signatures
+func readBody(r *http.Request) ([]byte, *s3Error) {
+	var data []byte
+	var err error
+	if payload := r.Header.Get("X-Amz-Content-Sha256"); strings.HasPrefix(payload, streamingPayload) {
+		// Only the chunks of signed streaming uploads have signatures
+		sig, _ := r.Context().Value(signatureKey{}).(*signature)
+		if payload != signedChunks {
+			sig = nil
+		}
+		data, err = readChunked(bufio.NewReader(r.Body), sig)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if errors.Is(err, errChunkSignature) {
+		return nil, errSignatureDoesNotMatch
+	}
+	if err != nil {
+		return nil, errIncompleteBody
+	}
+	return data, nil
+}
+
+// readChunked decodes an aws-chunked body, verifying the signature of every
+// chunk if sig is set
+func readChunked(r *bufio.Reader, sig *signature) ([]byte, error) {
+	var data bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, extension, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 {
+			return nil, errMalformedChunk
+		}
+		start := data.Len()
+		if _, err = io.CopyN(&data, r, size); err != nil {
+			return nil, err
+		}
+		if sig != nil {
+			if err = sig.verifyChunk(data.Bytes()[start:], strings.TrimPrefix(extension, "chunk-signature=")); err != nil {
+				return nil, err
+			}
+		}
+		if size == 0 {
+			// Trailing headers are not needed, and the rest of the body is discarded
+			return data.Bytes(), nil
+		}
+		if _, err = r.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseRange parses a single range of a Range header, returning the first
+// and last byte offsets
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+// matches reports whether an If-Match or If-None-Match header matches an object
+func matches(header string, obj *object) bool {
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" || unquote(strings.TrimPrefix(etag, "W/")) == obj.etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(data)))
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err *s3Error, bucketName string, key string) {
+	// Responses to HEAD requests have no body, so clients rely on the status
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.status)
+		return
+	}
+	writeXML(w, err.status, struct {
+		XMLName    xml.Name `xml:"Error"`
+		Code       string
+		Message    string
+		BucketName string `xml:",omitempty"`
+		Key        string `xml:",omitempty"`
+		Resource   string
+		RequestId  string
+	}{
+		Code:       err.code,
+		Message:    err.message,
+		BucketName: bucketName,
+		Key:        key,
+		Resource:   r.URL.Path,
+		RequestId:  w.Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
+
+func unquote(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
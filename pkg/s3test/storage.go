@@ -0,0 +1,238 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+)
+
+// RunStorageTests runs the behavior that every s3.Storage implementation
+// shares with the S3 client against new empty storages, so that fakes like
+// s3mem behave the same as the service they stand in for
+func RunStorageTests(t *testing.T, newStorage func(t *testing.T) s3.Storage) {
+	t.Run("Objects", func(t *testing.T) {
+		testObjects(t, newStorage(t))
+	})
+	t.Run("Copy", func(t *testing.T) {
+		testCopy(t, newStorage(t))
+	})
+	t.Run("List", func(t *testing.T) {
+		testList(t, newStorage(t))
+	})
+	t.Run("Conditions", func(t *testing.T) {
+		testConditions(t, newStorage(t))
+	})
+}
+
+func readAll(t *testing.T, storage s3.Storage, prefix string, key string, opts s3.GetOptions) []byte {
+	t.Helper()
+	reader, err := storage.GetObjectWithOptions(context.Background(), prefix, key, opts)
+	if err != nil {
+		t.Fatalf("failed to get %s/%s: %v", prefix, key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read %s/%s: %v", prefix, key, err)
+	}
+	return data
+}
+
+func testObjects(t *testing.T, storage s3.Storage) {
+	ctx := context.Background()
+	data := []byte("hello world")
+	if _, err := storage.PutObjectWithOptions(ctx, "data", "dir/a.txt", bytes.NewReader(data), int64(len(data)), s3.PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Owner": "test"},
+	}); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	info, err := storage.StatObject(ctx, "data", "dir/a.txt")
+	if err != nil {
+		t.Fatalf("failed to stat object: %v", err)
+	}
+	if info.Size != int64(len(data)) || info.ContentType != "text/plain" || info.UserMetadata["Owner"] != "test" {
+		t.Fatalf("expected size, content type and metadata to be stored, got %d, %q and %v", info.Size, info.ContentType, info.UserMetadata)
+	}
+	if read := readAll(t, storage, "data", "dir/a.txt", s3.GetOptions{}); !bytes.Equal(read, data) {
+		t.Fatalf("expected %q, got %q", data, read)
+	}
+
+	for _, r := range []struct {
+		byteRange s3.ByteRange
+		expected  string
+	}{
+		{s3.ByteRange{Start: 0, End: 4}, "hello"},
+		{s3.ByteRange{Start: 6, End: -1}, "world"},
+		{s3.ByteRange{Start: -5}, "world"},
+	} {
+		byteRange := r.byteRange
+		if read := readAll(t, storage, "data", "dir/a.txt", s3.GetOptions{Range: &byteRange}); string(read) != r.expected {
+			t.Fatalf("expected %q for range %+v, got %q", r.expected, r.byteRange, read)
+		}
+	}
+
+	// Objects are only read back under the prefix they were written to
+	if _, err = storage.StatObject(ctx, "other", "dir/a.txt"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound under another prefix, got %v", err)
+	}
+	if _, err = storage.GetObject(ctx, "data", "missing"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound for a missing object, got %v", err)
+	}
+
+	if err = storage.DeleteObject(ctx, "data", "dir/a.txt"); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	if _, err = storage.StatObject(ctx, "data", "dir/a.txt"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected deleted object to be gone, got %v", err)
+	}
+	// Deleting a missing object is not an error, as in S3
+	if err = storage.DeleteObject(ctx, "data", "dir/a.txt"); err != nil {
+		t.Fatalf("expected deleting a missing object to succeed: %v", err)
+	}
+}
+
+func testCopy(t *testing.T, storage s3.Storage) {
+	ctx := context.Background()
+	data := []byte("hello world")
+	if _, err := storage.PutObjectWithOptions(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), s3.PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Owner": "test"},
+	}); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	if _, err := storage.CopyObject(ctx, "data", "a", "copy", "a"); err != nil {
+		t.Fatalf("failed to copy object: %v", err)
+	}
+	info, err := storage.StatObject(ctx, "copy", "a")
+	if err != nil {
+		t.Fatalf("failed to stat copy: %v", err)
+	}
+	if info.ContentType != "text/plain" || info.UserMetadata["Owner"] != "test" {
+		t.Fatalf("expected content type and metadata to be copied, got %q and %v", info.ContentType, info.UserMetadata)
+	}
+	if read := readAll(t, storage, "copy", "a", s3.GetOptions{}); !bytes.Equal(read, data) {
+		t.Fatalf("expected %q, got %q", data, read)
+	}
+
+	// Replaced metadata is merged with the metadata of the source
+	if _, err = storage.CopyObject(ctx, "data", "a", "copy", "b", s3.WithContentType("application/json"), s3.WithMetadata(map[string]string{"Reviewer": "other"})); err != nil {
+		t.Fatalf("failed to copy object: %v", err)
+	}
+	if info, err = storage.StatObject(ctx, "copy", "b"); err != nil {
+		t.Fatalf("failed to stat copy: %v", err)
+	}
+	if info.ContentType != "application/json" || info.UserMetadata["Owner"] != "test" || info.UserMetadata["Reviewer"] != "other" {
+		t.Fatalf("expected replaced content type and merged metadata, got %q and %v", info.ContentType, info.UserMetadata)
+	}
+
+	if _, err = storage.CopyObject(ctx, "data", "missing", "copy", "c"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound copying a missing object, got %v", err)
+	}
+}
+
+func testList(t *testing.T, storage s3.Storage) {
+	ctx := context.Background()
+	for _, key := range []string{"b", "a", "dir/c", "dir/sub/d"} {
+		if _, err := storage.PutObject(ctx, "data", key, bytes.NewReader([]byte(key)), int64(len(key)), "text/plain"); err != nil {
+			t.Fatalf("failed to put object: %v", err)
+		}
+	}
+	if _, err := storage.PutObject(ctx, "other", "e", bytes.NewReader([]byte("e")), 1, "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	list := func(prefix string) []string {
+		var keys []string
+		for object := range storage.ListObjects(ctx, prefix) {
+			if object.Err != nil {
+				t.Fatalf("failed to list objects: %v", object.Err)
+			}
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+	// Listings are in key order with common prefixes grouped by "/"
+	if keys := strings.Join(list("data"), ","); keys != "data/a,data/b,data/dir/" {
+		t.Fatalf("expected objects and common prefixes of data, got %s", keys)
+	}
+	if keys := strings.Join(list("data/dir"), ","); keys != "data/dir/c,data/dir/sub/" {
+		t.Fatalf("expected objects and common prefixes of data/dir, got %s", keys)
+	}
+	if keys := list("missing"); len(keys) != 0 {
+		t.Fatalf("expected no objects under a missing prefix, got %v", keys)
+	}
+}
+
+func testConditions(t *testing.T, storage s3.Storage) {
+	ctx := context.Background()
+	data := []byte("hello world")
+	info, err := storage.PutObject(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	// ETags match with and without quotes
+	for _, etag := range []string{unquote(info.ETag), quote(unquote(info.ETag))} {
+		reader, err := storage.GetObjectWithOptions(ctx, "data", "a", s3.GetOptions{IfMatch: etag})
+		if err != nil {
+			t.Fatalf("expected get with If-Match %s to succeed: %v", etag, err)
+		}
+		_ = reader.Close()
+		if _, err = storage.GetObjectWithOptions(ctx, "data", "a", s3.GetOptions{IfNoneMatch: etag}); !errors.Is(err, s3.ErrNotModified) {
+			t.Fatalf("expected ErrNotModified for get with If-None-Match %s, got %v", etag, err)
+		}
+		if _, err = storage.CopyObject(ctx, "data", "a", "copy", "a", s3.WithIfMatch(etag)); err != nil {
+			t.Fatalf("expected copy with If-Match %s to succeed: %v", etag, err)
+		}
+		if info, err = storage.PutObjectWithOptions(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), s3.PutOptions{IfMatch: etag}); err != nil {
+			t.Fatalf("expected put with If-Match %s to succeed: %v", etag, err)
+		}
+	}
+
+	const stale = `"0123456789abcdef0123456789abcdef"`
+	if _, err = storage.GetObjectWithOptions(ctx, "data", "a", s3.GetOptions{IfMatch: stale}); !errors.Is(err, s3.ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed for get with a stale ETag, got %v", err)
+	}
+	if _, err = storage.CopyObject(ctx, "data", "a", "copy", "a", s3.WithIfMatch(stale)); !errors.Is(err, s3.ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed for copy with a stale ETag, got %v", err)
+	}
+	if _, err = storage.PutObjectWithOptions(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), s3.PutOptions{IfMatch: stale}); !errors.Is(err, s3.ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed for put with a stale ETag, got %v", err)
+	}
+	if _, err = storage.PutObjectIfAbsent(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain"); !errors.Is(err, s3.ErrObjectExists) {
+		t.Fatalf("expected ErrObjectExists for put if absent, got %v", err)
+	}
+	if err = storage.DeleteObjectWithOptions(ctx, "data", "a", s3.DeleteOptions{IfMatch: stale}); !errors.Is(err, s3.ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed for delete with a stale ETag, got %v", err)
+	}
+	if err = storage.DeleteObjectWithOptions(ctx, "data", "a", s3.DeleteOptions{IfMatch: quote(unquote(info.ETag))}); err != nil {
+		t.Fatalf("expected delete with the current ETag to succeed: %v", err)
+	}
+	if _, err = storage.StatObject(ctx, "data", "a"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected deleted object to be gone, got %v", err)
+	}
+}
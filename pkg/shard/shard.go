@@ -0,0 +1,286 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package shard distributes objects across several buckets by consistent
+// hashing of their keys, to work around per-bucket rate limits.
+//
+// Every object is stored in exactly one shard, chosen from its full name
+// (the prefix joined with the key), and listings merge the listings of all
+// shards in key order. Adding or removing a shard moves roughly 1/N of the
+// keys to other shards, which are not rebalanced automatically.
+package shard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+)
+
+var (
+	ErrNoShards       = errors.New("at least one shard is required")
+	ErrDuplicateShard = errors.New("duplicate shard name")
+)
+
+const (
+	// DefaultVirtualNodes is the number of points every shard has on the
+	// hash ring, which evens out the share of keys per shard
+	DefaultVirtualNodes = 128
+)
+
+var _ s3.Storage = (*Storage)(nil)
+
+// Shard is one bucket of a sharded Storage. The name places the shard on
+// the hash ring, so it must stay the same for the keys to stay in place.
+type Shard struct {
+	Name    string
+	Storage s3.Storage
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// Storage is an s3.Storage that spreads objects across its shards
+type Storage struct {
+	shards []Shard
+	ring   []point
+}
+
+// New returns a Storage over the given shards
+func New(shards ...Shard) (*Storage, error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShards
+	}
+
+	s := &Storage{
+		shards: shards,
+		ring:   make([]point, 0, len(shards)*DefaultVirtualNodes),
+	}
+	names := make(map[string]bool, len(shards))
+	for i, shard := range shards {
+		if names[shard.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateShard, shard.Name)
+		}
+		names[shard.Name] = true
+		for n := 0; n < DefaultVirtualNodes; n++ {
+			s.ring = append(s.ring, point{
+				hash:  hash(shard.Name + "#" + strconv.Itoa(n)),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool {
+		return s.ring[i].hash < s.ring[j].hash
+	})
+	return s, nil
+}
+
+// Buckets returns a Storage with a shard for each bucket, all using
+// the connection and options of client
+func Buckets(client *s3.S3, buckets ...string) (*Storage, error) {
+	shards := make([]Shard, len(buckets))
+	for i, bucket := range buckets {
+		shards[i] = Shard{
+			Name:    bucket,
+			Storage: client.Bucket(bucket),
+		}
+	}
+	return New(shards...)
+}
+
+// hash returns the position of s on the ring, from a cryptographic hash so
+// that similar names and keys are spread evenly
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Locate returns the name of the shard that stores the object
+func (s *Storage) Locate(prefix string, key string) string {
+	return s.shards[s.locate(prefix, key)].Name
+}
+
+func (s *Storage) locate(prefix string, key string) int {
+	h := hash(s3.JoinKey(prefix, key))
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+func (s *Storage) shard(prefix string, key string) s3.Storage {
+	return s.shards[s.locate(prefix, key)].Storage
+}
+
+func (s *Storage) PresignedGetObject(ctx context.Context, prefix string, key string, expires time.Duration) (*url.URL, error) {
+	return s.shard(prefix, key).PresignedGetObject(ctx, prefix, key, expires)
+}
+
+func (s *Storage) GetObject(ctx context.Context, prefix string, key string, opts ...s3.ObjectOption) (io.ReadCloser, error) {
+	return s.shard(prefix, key).GetObject(ctx, prefix, key, opts...)
+}
+
+func (s *Storage) GetObjectWithOptions(ctx context.Context, prefix string, key string, opts s3.GetOptions) (io.ReadCloser, error) {
+	return s.shard(prefix, key).GetObjectWithOptions(ctx, prefix, key, opts)
+}
+
+func (s *Storage) PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	return s.shard(prefix, key).PutObject(ctx, prefix, key, reader, objectSize, contentType, opts...)
+}
+
+func (s *Storage) PutObjectWithOptions(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, opts s3.PutOptions) (minio.UploadInfo, error) {
+	return s.shard(prefix, key).PutObjectWithOptions(ctx, prefix, key, reader, objectSize, opts)
+}
+
+func (s *Storage) PutObjectIfAbsent(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string) (minio.UploadInfo, error) {
+	return s.shard(prefix, key).PutObjectIfAbsent(ctx, prefix, key, reader, objectSize, contentType)
+}
+
+func (s *Storage) StatObject(ctx context.Context, prefix string, key string) (minio.ObjectInfo, error) {
+	return s.shard(prefix, key).StatObject(ctx, prefix, key)
+}
+
+func (s *Storage) DeleteObject(ctx context.Context, prefix string, key string, opts ...s3.ObjectOption) error {
+	return s.shard(prefix, key).DeleteObject(ctx, prefix, key, opts...)
+}
+
+func (s *Storage) DeleteObjectWithOptions(ctx context.Context, prefix string, key string, opts s3.DeleteOptions) error {
+	return s.shard(prefix, key).DeleteObjectWithOptions(ctx, prefix, key, opts)
+}
+
+// CopyObject copies an object server-side if the source and destination are
+// in the same shard, and otherwise streams it from one shard to the other,
+// keeping its content type and metadata unless opts replace them
+func (s *Storage) CopyObject(ctx context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	src, dst := s.locate(srcPrefix, srcKey), s.locate(dstPrefix, dstKey)
+	if src == dst {
+		return s.shards[src].Storage.CopyObject(ctx, srcPrefix, srcKey, dstPrefix, dstKey, opts...)
+	}
+
+	info, err := s.shards[src].Storage.StatObject(ctx, srcPrefix, srcKey)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	reader, err := s.shards[src].Storage.GetObject(ctx, srcPrefix, srcKey, s3.WithIfMatch(info.ETag))
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer reader.Close()
+
+	// The reader is decompressed, so transparently compressed objects are
+	// copied with their original size and without the metadata they are
+	// decompressed with, and compressed again by the destination if it
+	// compresses uploads
+	metadata := make(map[string]string, len(info.UserMetadata))
+	for name, value := range info.UserMetadata {
+		if name != s3.UncompressedSizeMetadata {
+			metadata[name] = value
+		}
+	}
+	putOpts := s3.ApplyPutOptions(s3.PutOptions{
+		ContentType: info.ContentType,
+		Metadata:    metadata,
+	}, opts...)
+	return s.shards[dst].Storage.PutObjectWithOptions(ctx, dstPrefix, dstKey, reader, s3.ContentSize(info), putOpts)
+}
+
+// ListObjects lists the objects under prefix in all shards, merging them by
+// key. Common prefixes, which minio-go returns after the objects of every
+// page, are returned once even if they exist in several shards.
+func (s *Storage) ListObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	listings := make([]<-chan minio.ObjectInfo, len(s.shards))
+	for i, shard := range s.shards {
+		listings[i] = shard.Storage.ListObjects(ctx, prefix)
+	}
+
+	out := make(chan minio.ObjectInfo, 1)
+	go func() {
+		defer close(out)
+		heads := make([]*minio.ObjectInfo, len(listings))
+		next := func(i int) {
+			heads[i] = nil
+			if object, ok := <-listings[i]; ok {
+				heads[i] = &object
+			}
+		}
+		for i := range listings {
+			next(i)
+		}
+
+		prefixes := make(map[string]bool)
+		for {
+			lowest := -1
+			for i, head := range heads {
+				if head == nil {
+					continue
+				}
+				// Errors are forwarded first, each listing stops after its error
+				if head.Err != nil {
+					lowest = i
+					break
+				}
+				if lowest < 0 || head.Key < heads[lowest].Key {
+					lowest = i
+				}
+			}
+			if lowest < 0 {
+				return
+			}
+
+			object := *heads[lowest]
+			next(lowest)
+			if object.Err == nil && strings.HasSuffix(object.Key, s3.DefaultKeyDelimiter) {
+				if prefixes[object.Key] {
+					continue
+				}
+				prefixes[object.Key] = true
+			}
+			select {
+			case out <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Close closes every shard
+func (s *Storage) Close() error {
+	var errs []error
+	for _, shard := range s.shards {
+		if err := shard.Storage.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close shard %s: %w", shard.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,184 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package shard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3mem"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func memoryShards(t *testing.T, names ...string) *Storage {
+	t.Helper()
+	shards := make([]Shard, len(names))
+	for i, name := range names {
+		shards[i] = Shard{Name: name, Storage: s3mem.New(name)}
+	}
+	s, err := New(shards...)
+	if err != nil {
+		t.Fatalf("failed to create sharded storage: %v", err)
+	}
+	return s
+}
+
+// keyIn returns a key of the given prefix that is stored in the given shard
+func keyIn(t *testing.T, s *Storage, prefix string, shard int) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if s.locate(prefix, key) == shard {
+			return key
+		}
+	}
+	t.Fatalf("no key found in shard %d", shard)
+	return ""
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(); !errors.Is(err, ErrNoShards) {
+		t.Fatalf("expected ErrNoShards, got %v", err)
+	}
+	if _, err := New(Shard{Name: "a", Storage: s3mem.New("a")}, Shard{Name: "a", Storage: s3mem.New("b")}); !errors.Is(err, ErrDuplicateShard) {
+		t.Fatalf("expected ErrDuplicateShard, got %v", err)
+	}
+}
+
+func TestShardObjects(t *testing.T) {
+	ctx := context.Background()
+	s := memoryShards(t, "a", "b", "c")
+
+	counts := make(map[int]int)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if _, err := s.PutObject(ctx, "data", key, bytes.NewReader([]byte(key)), int64(len(key)), "text/plain"); err != nil {
+			t.Fatalf("failed to put object: %v", err)
+		}
+		shard := s.locate("data", key)
+		counts[shard]++
+		// Every object is stored in exactly one shard
+		for i, other := range s.shards {
+			_, err := other.Storage.StatObject(ctx, "data", key)
+			if (i == shard) != (err == nil) {
+				t.Fatalf("expected %s only in shard %d, got %v from shard %d", key, shard, err, i)
+			}
+		}
+	}
+	if len(counts) != 3 {
+		t.Fatalf("expected objects in all shards, got %v", counts)
+	}
+
+	// Listings are merged in key order
+	i := 0
+	for object := range s.ListObjects(ctx, "data") {
+		if object.Err != nil {
+			t.Fatalf("failed to list objects: %v", object.Err)
+		}
+		if expected := fmt.Sprintf("data/key-%02d", i); object.Key != expected {
+			t.Fatalf("expected %s, got %s", expected, object.Key)
+		}
+		i++
+	}
+	if i != 30 {
+		t.Fatalf("expected 30 objects, got %d", i)
+	}
+}
+
+func TestShardCopyObject(t *testing.T) {
+	ctx := context.Background()
+	s := memoryShards(t, "a", "b")
+	src, dst := keyIn(t, s, "data", 0), keyIn(t, s, "copy", 1)
+	data := []byte("hello world")
+	if _, err := s.PutObjectWithOptions(ctx, "data", src, bytes.NewReader(data), int64(len(data)), s3.PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Owner": "test"},
+	}); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	if _, err := s.CopyObject(ctx, "data", src, "copy", dst); err != nil {
+		t.Fatalf("failed to copy object across shards: %v", err)
+	}
+	info, err := s.StatObject(ctx, "copy", dst)
+	if err != nil {
+		t.Fatalf("failed to stat copy: %v", err)
+	}
+	if info.ContentType != "text/plain" || info.UserMetadata["Owner"] != "test" {
+		t.Fatalf("expected content type and metadata to be copied, got %q and %v", info.ContentType, info.UserMetadata)
+	}
+	checkObject(t, s, "copy", dst, data)
+}
+
+func TestShardCopyCompressedObject(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible data "), 4096)
+	for _, compression := range []s3.Compression{s3.CompressionGzip, s3.CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			ctx := context.Background()
+			compress := func(options *s3.Options) {
+				options.Compression = compression
+			}
+			s, err := New(
+				Shard{Name: "a", Storage: s3test.NewServer(t, compress)},
+				Shard{Name: "b", Storage: s3test.NewServer(t, compress)},
+			)
+			if err != nil {
+				t.Fatalf("failed to create sharded storage: %v", err)
+			}
+			src, dst := keyIn(t, s, "data", 0), keyIn(t, s, "copy", 1)
+			if _, err = s.PutObject(ctx, "data", src, bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+				t.Fatalf("failed to put object: %v", err)
+			}
+
+			if _, err = s.CopyObject(ctx, "data", src, "copy", dst); err != nil {
+				t.Fatalf("failed to copy object across shards: %v", err)
+			}
+			info, err := s.StatObject(ctx, "copy", dst)
+			if err != nil {
+				t.Fatalf("failed to stat copy: %v", err)
+			}
+			if info.Size >= int64(len(data)) {
+				t.Fatalf("expected copy to be stored compressed, got %d bytes for %d", info.Size, len(data))
+			}
+			if size := s3.ContentSize(info); size != int64(len(data)) {
+				t.Fatalf("expected content size %d, got %d", len(data), size)
+			}
+			checkObject(t, s, "copy", dst, data)
+		})
+	}
+}
+
+func checkObject(t *testing.T, s *Storage, prefix string, key string, data []byte) {
+	t.Helper()
+	reader, err := s.GetObject(context.Background(), prefix, key)
+	if err != nil {
+		t.Fatalf("failed to get object: %v", err)
+	}
+	defer reader.Close()
+	read, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read object: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("expected %d bytes, got %d bytes", len(data), len(read))
+	}
+}
@@ -0,0 +1,318 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrConflict              = errors.New("conflicting changes")
+	ErrMissingStateFile      = errors.New("missing state file")
+	ErrUnknownConflictPolicy = errors.New("unknown conflict policy")
+)
+
+// ConflictPolicy decides what Sync does with a key that changed both
+// locally and remotely since the last sync
+type ConflictPolicy string
+
+const (
+	// ConflictNewestWins keeps whichever side was modified last. A change
+	// always wins over a deletion.
+	ConflictNewestWins ConflictPolicy = "newest-wins"
+
+	// ConflictRemoteWins keeps the object and discards the local change
+	ConflictRemoteWins ConflictPolicy = "remote-wins"
+
+	// ConflictError leaves both sides untouched and reports ErrConflict in
+	// the summary, the conflict is found again by the next sync
+	ConflictError ConflictPolicy = "error"
+)
+
+type BidiOptions struct {
+	Options
+
+	// StateFile is where Sync keeps the files and objects it saw at the end
+	// of the last sync, which is how it tells a file that was deleted on one
+	// side from a file that was created on the other. It must be outside of
+	// the synced directory. Options.Delete is ignored, deletions are always
+	// synced.
+	StateFile string
+
+	// Conflict is the ConflictPolicy, ConflictNewestWins if empty
+	Conflict ConflictPolicy
+}
+
+// stateEntry is a key as it was on both sides after it was last synced
+type stateEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	ETag    string `json:"etag"`
+}
+
+// state is the contents of a state file
+type state struct {
+	mu      gosync.Mutex
+	Entries map[string]stateEntry `json:"entries"`
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{
+		Entries: make(map[string]stateEntry),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return st, nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err = json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	if st.Entries == nil {
+		st.Entries = make(map[string]stateEntry)
+	}
+	return st, nil
+}
+
+// save writes the state to a temporary file and renames it into place
+func (st *state) save(path string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+func (st *state) set(key string, size int64, modTime time.Time, etag string) {
+	st.mu.Lock()
+	st.Entries[key] = stateEntry{
+		Size:    size,
+		ModTime: modTime.UnixNano(),
+		ETag:    normalizeETag(etag),
+	}
+	st.mu.Unlock()
+}
+
+func (st *state) remove(key string) {
+	st.mu.Lock()
+	delete(st.Entries, key)
+	st.mu.Unlock()
+}
+
+func normalizeETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+// Sync syncs localDir and prefix in both directions. Files and objects
+// that changed on one side since the last sync are uploaded, downloaded or
+// deleted on the other, keys that changed on both sides are resolved with
+// BidiOptions.Conflict. The state file is updated for every key that was
+// synced, keys that failed are retried by the next sync.
+func (s *Syncer) Sync(ctx context.Context, localDir string, prefix string, opts BidiOptions) (*Summary, error) {
+	if opts.StateFile == "" {
+		return nil, ErrMissingStateFile
+	}
+	switch opts.Conflict {
+	case "":
+		opts.Conflict = ConflictNewestWins
+	case ConflictNewestWins, ConflictRemoteWins, ConflictError:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownConflictPolicy, opts.Conflict)
+	}
+
+	st, err := loadState(opts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	files, err := localFiles(localDir)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := s.remoteObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]struct{}, len(files)+len(objects)+len(st.Entries))
+	for key := range files {
+		keys[key] = struct{}{}
+	}
+	for key := range objects {
+		keys[key] = struct{}{}
+	}
+	for key := range st.Entries {
+		keys[key] = struct{}{}
+	}
+
+	var tasks []task
+	for _, key := range sortedKeys(keys) {
+		tasks = append(tasks, s.bidiTask(prefix, key, localDir, st, files, objects, opts))
+	}
+
+	summary := new(Summary)
+	run(ctx, tasks, opts.Options, summary)
+	if opts.DryRun {
+		return summary, nil
+	}
+	if err = st.save(opts.StateFile); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+func (s *Syncer) bidiTask(prefix string, key string, localDir string, st *state, files map[string]file, objects map[string]minio.ObjectInfo, opts BidiOptions) task {
+	f, local := files[key]
+	object, remote := objects[key]
+	entry, synced := st.Entries[key]
+
+	localChanged := local != synced
+	if local && synced {
+		localChanged = f.size != entry.Size || f.modTime.UnixNano() != entry.ModTime
+	}
+	remoteChanged := remote != synced
+	if remote && synced {
+		remoteChanged = normalizeETag(object.ETag) != entry.ETag
+	}
+
+	return func(ctx context.Context) Progress {
+		if localChanged && remoteChanged {
+			return s.resolve(ctx, prefix, key, localDir, st, f, local, object, remote, opts)
+		}
+		switch {
+		case localChanged && local:
+			return s.put(ctx, prefix, key, st, f, opts.Options)
+		case localChanged:
+			return s.delete(ctx, prefix, key, st, opts.Options)
+		case remoteChanged && remote:
+			return s.get(ctx, prefix, key, localDir, st, object, opts.Options)
+		case remoteChanged:
+			return unlink(key, st, f, opts.Options)
+		}
+		return Progress{Key: key, Action: ActionSkip}
+	}
+}
+
+// resolve handles a key that changed on both sides
+func (s *Syncer) resolve(ctx context.Context, prefix string, key string, localDir string, st *state, f file, local bool, object minio.ObjectInfo, remote bool, opts BidiOptions) Progress {
+	if !local && !remote {
+		if !opts.DryRun {
+			st.remove(key)
+		}
+		return Progress{Key: key, Action: ActionSkip}
+	}
+	if local && remote && f.size == object.Size {
+		same, ok, err := sameContent(f, object)
+		if err != nil {
+			return Progress{Key: key, Action: ActionSkip, Err: err}
+		}
+		if ok && same {
+			if !opts.DryRun {
+				st.set(key, f.size, f.modTime, object.ETag)
+			}
+			return Progress{Key: key, Action: ActionSkip}
+		}
+	}
+
+	useLocal := false
+	switch opts.Conflict {
+	case ConflictError:
+		return Progress{Key: key, Action: ActionSkip, Err: ErrConflict}
+	case ConflictNewestWins:
+		useLocal = !remote || (local && modifiedAfter(f.modTime, object.LastModified))
+	}
+
+	switch {
+	case useLocal:
+		return s.put(ctx, prefix, key, st, f, opts.Options)
+	case remote:
+		return s.get(ctx, prefix, key, localDir, st, object, opts.Options)
+	default:
+		return unlink(key, st, f, opts.Options)
+	}
+}
+
+func (s *Syncer) put(ctx context.Context, prefix string, key string, st *state, f file, opts Options) Progress {
+	if opts.DryRun {
+		return Progress{Key: key, Action: ActionUpload, Bytes: f.size}
+	}
+	info, err := s.upload(ctx, prefix, key, f)
+	if err != nil {
+		return Progress{Key: key, Action: ActionUpload, Err: err}
+	}
+	st.set(key, f.size, f.modTime, info.ETag)
+	return Progress{Key: key, Action: ActionUpload, Bytes: f.size}
+}
+
+func (s *Syncer) get(ctx context.Context, prefix string, key string, localDir string, st *state, object minio.ObjectInfo, opts Options) Progress {
+	path, err := localPath(localDir, key)
+	if err != nil {
+		return Progress{Key: key, Action: ActionDownload, Err: err}
+	}
+	if opts.DryRun {
+		return Progress{Key: key, Action: ActionDownload, Bytes: object.Size}
+	}
+	n, err := s.download(ctx, prefix, key, path, object)
+	if err != nil {
+		return Progress{Key: key, Action: ActionDownload, Err: err}
+	}
+	st.set(key, n, object.LastModified, object.ETag)
+	return Progress{Key: key, Action: ActionDownload, Bytes: n}
+}
+
+func (s *Syncer) delete(ctx context.Context, prefix string, key string, st *state, opts Options) Progress {
+	progress := s.deleteTask(prefix, key, opts)(ctx)
+	if progress.Err == nil && !opts.DryRun {
+		st.remove(key)
+	}
+	return progress
+}
+
+func unlink(key string, st *state, f file, opts Options) Progress {
+	progress := removeTask(key, f, opts)(context.Background())
+	if progress.Err == nil && !opts.DryRun {
+		st.remove(key)
+	}
+	return progress
+}
@@ -0,0 +1,140 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// SyncDown mirrors prefix to localDir, downloading the objects that are
+// missing or changed. Downloaded files get the modification time of their
+// object, so they are not downloaded again by the next sync. With
+// Options.Delete, files in localDir without an object under prefix are
+// removed. localDir is created if it does not exist.
+func (s *Syncer) SyncDown(ctx context.Context, prefix string, localDir string, opts Options) (*Summary, error) {
+	if !opts.DryRun {
+		if err := os.MkdirAll(localDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	files, err := localFiles(localDir)
+	if err != nil {
+		if !opts.DryRun || !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		files = make(map[string]file)
+	}
+	objects, err := s.remoteObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []task
+	for _, key := range sortedKeys(objects) {
+		tasks = append(tasks, s.downloadTask(prefix, key, localDir, objects[key], files, opts))
+	}
+	if opts.Delete {
+		for _, key := range sortedKeys(files) {
+			if _, ok := objects[key]; !ok {
+				tasks = append(tasks, removeTask(key, files[key], opts))
+			}
+		}
+	}
+
+	summary := new(Summary)
+	run(ctx, tasks, opts, summary)
+	return summary, nil
+}
+
+func (s *Syncer) downloadTask(prefix string, key string, localDir string, object minio.ObjectInfo, files map[string]file, opts Options) task {
+	return func(ctx context.Context) Progress {
+		if f, ok := files[key]; ok {
+			download, err := s.changed(ctx, prefix, key, f, object, opts.Checksum, func() bool {
+				return object.LastModified.After(f.modTime)
+			})
+			if err != nil {
+				return Progress{Key: key, Action: ActionDownload, Err: err}
+			}
+			if !download {
+				return Progress{Key: key, Action: ActionSkip}
+			}
+		}
+
+		path, err := localPath(localDir, key)
+		if err != nil {
+			return Progress{Key: key, Action: ActionDownload, Err: err}
+		}
+		if opts.DryRun {
+			return Progress{Key: key, Action: ActionDownload, Bytes: object.Size}
+		}
+		n, err := s.download(ctx, prefix, key, path, object)
+		if err != nil {
+			return Progress{Key: key, Action: ActionDownload, Err: err}
+		}
+		return Progress{Key: key, Action: ActionDownload, Bytes: n}
+	}
+}
+
+// download writes the object to a temporary file next to path and renames
+// it into place, so an interrupted download never leaves a partial file
+func (s *Syncer) download(ctx context.Context, prefix string, key string, path string, object minio.ObjectInfo) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	reader, err := s.client.GetObject(ctx, prefix, key)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err = os.Chtimes(tmp.Name(), object.LastModified, object.LastModified); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func removeTask(key string, f file, opts Options) task {
+	return func(context.Context) Progress {
+		if opts.DryRun {
+			return Progress{Key: key, Action: ActionDelete}
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return Progress{Key: key, Action: ActionDelete, Err: err}
+		}
+		return Progress{Key: key, Action: ActionDelete}
+	}
+}
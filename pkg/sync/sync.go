@@ -0,0 +1,312 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package sync mirrors local directories to and from prefixes, transferring
+// only the files that changed, with the semantics of mc mirror.
+//
+// Files are compared with objects by size and modification time, and
+// optionally by the MD5 of the file and the ETag of the object. Keys are
+// the slash-separated paths of the files relative to the directory.
+package sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+)
+
+var (
+	ErrNotDirectory = errors.New("not a directory")
+)
+
+const (
+	DefaultConcurrency = 4
+)
+
+// Action is what happened to a single file or object
+type Action string
+
+const (
+	ActionUpload   Action = "upload"
+	ActionDownload Action = "download"
+	ActionDelete   Action = "delete"
+	ActionSkip     Action = "skip"
+)
+
+type Options struct {
+	// Concurrency is the number of files transferred at a time,
+	// DefaultConcurrency if zero
+	Concurrency int
+
+	// Delete removes the objects (or files, when syncing down) that no
+	// longer exist on the other side
+	Delete bool
+
+	// Checksum compares the MD5 of files with the ETag of objects instead
+	// of their modification times, which also finds changes that keep the
+	// size and time. Objects uploaded in several parts have no MD5 ETag and
+	// are still compared by time.
+	Checksum bool
+
+	// DryRun reports what would be transferred and deleted without
+	// changing anything
+	DryRun bool
+
+	// Progress is called after every file or object has been processed,
+	// it is never called concurrently
+	Progress func(progress Progress)
+}
+
+// Progress is reported to Options.Progress
+type Progress struct {
+	Key    string
+	Action Action
+	Bytes  int64
+	Err    error
+}
+
+// Summary is the result of a sync
+type Summary struct {
+	Uploaded   int
+	Downloaded int
+	Deleted    int
+	Skipped    int
+
+	// Bytes is the number of bytes transferred
+	Bytes int64
+
+	Failures []s3.ObjectFailure
+}
+
+func (s *Summary) add(progress Progress) {
+	if progress.Err != nil {
+		s.Failures = append(s.Failures, s3.ObjectFailure{
+			Key: progress.Key,
+			Err: progress.Err,
+		})
+		return
+	}
+	switch progress.Action {
+	case ActionUpload:
+		s.Uploaded++
+	case ActionDownload:
+		s.Downloaded++
+	case ActionDelete:
+		s.Deleted++
+	case ActionSkip:
+		s.Skipped++
+	}
+	s.Bytes += progress.Bytes
+}
+
+// Syncer syncs local directories with prefixes of a client
+type Syncer struct {
+	client *s3.S3
+}
+
+// New returns a Syncer for the given client
+func New(client *s3.S3) *Syncer {
+	return &Syncer{
+		client: client,
+	}
+}
+
+// file is a regular file in a synced directory
+type file struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// localFiles returns the regular files below dir by their keys
+func localFiles(dir string) (map[string]file, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrNotDirectory, dir)
+	}
+
+	files := make(map[string]file)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = file{
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// localPath returns the path of the file for a listed key below dir,
+// rejecting keys that would escape it
+func localPath(dir string, key string) (string, error) {
+	if err := s3.ValidateKey(key); err != nil {
+		return "", err
+	}
+	root := filepath.Clean(dir)
+	path := filepath.Join(root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, root+string(os.PathSeparator)) {
+		return "", &s3.KeyError{Key: key, Reason: "key is outside of the directory"}
+	}
+	return path, nil
+}
+
+// remoteObjects returns the objects under prefix by their keys, skipping
+// directory markers
+func (s *Syncer) remoteObjects(ctx context.Context, prefix string) (map[string]minio.ObjectInfo, error) {
+	objects := make(map[string]minio.ObjectInfo)
+	for object := range s.client.ListObjectsRecursive(ctx, prefix) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		objects[object.Key] = object
+	}
+	return objects, nil
+}
+
+// sameContent compares the MD5 of the file with the ETag of the object,
+// returning false for ok if the ETag is not an MD5
+func sameContent(f file, object minio.ObjectInfo) (same bool, ok bool, err error) {
+	etag := strings.Trim(object.ETag, `"`)
+	if len(etag) != md5.Size*2 {
+		return false, false, nil
+	}
+	sum, err := md5File(f.path)
+	if err != nil {
+		return false, false, err
+	}
+	return sum == etag, true, nil
+}
+
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// modifiedAfter returns whether a file was modified after an object was
+// last modified, ignoring the fraction of a second that listings drop so that
+// files uploaded in the same second they were written aren't uploaded again
+func modifiedAfter(modTime time.Time, lastModified time.Time) bool {
+	return modTime.Truncate(time.Second).After(lastModified)
+}
+
+// changed returns whether the file and the object differ, using newer to
+// compare them if their sizes match and their checksums cannot be compared
+func (s *Syncer) changed(ctx context.Context, prefix string, key string, f file, object minio.ObjectInfo, checksum bool, newer func() bool) (bool, error) {
+	if f.size != object.Size {
+		// Listings have the stored size, which for transparently compressed
+		// objects is the compressed one, so their metadata decides
+		info, err := s.client.StatObject(ctx, prefix, key)
+		if err != nil {
+			return false, err
+		}
+		if f.size != s3.ContentSize(info) {
+			return true, nil
+		}
+		// The ETag is the checksum of the compressed data
+		return newer(), nil
+	}
+	if checksum {
+		same, ok, err := sameContent(f, object)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return !same, nil
+		}
+	}
+	return newer(), nil
+}
+
+// task transfers or deletes a single file or object
+type task func(ctx context.Context) Progress
+
+// run runs the tasks from up to concurrency workers at a time, adding their
+// results to the summary
+func run(ctx context.Context, tasks []task, opts Options, summary *Summary) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	var mu gosync.Mutex
+	var wg gosync.WaitGroup
+	queue := make(chan task)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				progress := t(ctx)
+				mu.Lock()
+				summary.add(progress)
+				if opts.Progress != nil {
+					opts.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range tasks {
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+}
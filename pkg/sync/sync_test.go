@@ -0,0 +1,232 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+}
+
+func checkFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	found, err := localFiles(dir)
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(found) != len(files) {
+		t.Fatalf("expected %d files, got %d", len(files), len(found))
+	}
+	for name, contents := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(data) != contents {
+			t.Fatalf("expected %s to contain %q, got %q", name, contents, data)
+		}
+	}
+}
+
+func checkSummary(t *testing.T, summary *Summary, err error, uploaded int, downloaded int, deleted int, skipped int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(summary.Failures) > 0 {
+		t.Fatalf("failed to sync %s: %v", summary.Failures[0].Key, summary.Failures[0].Err)
+	}
+	if summary.Uploaded != uploaded || summary.Downloaded != downloaded || summary.Deleted != deleted || summary.Skipped != skipped {
+		t.Fatalf("expected %d uploaded, %d downloaded, %d deleted and %d skipped, got %+v", uploaded, downloaded, deleted, skipped, summary)
+	}
+}
+
+func TestSyncUp(t *testing.T) {
+	for _, compression := range []s3.Compression{s3.CompressionNone, s3.CompressionGzip, s3.CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			ctx := context.Background()
+			client := s3test.NewServer(t, func(options *s3.Options) {
+				options.Compression = compression
+			})
+			syncer := New(client)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"a.txt":     "hello world",
+				"dir/b.txt": string(bytes.Repeat([]byte("compressible "), 1024)),
+			})
+
+			summary, err := syncer.SyncUp(ctx, dir, "data", Options{})
+			checkSummary(t, summary, err, 2, 0, 0, 0)
+			summary, err = syncer.SyncUp(ctx, dir, "data", Options{})
+			checkSummary(t, summary, err, 0, 0, 0, 2)
+
+			if err = os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+				t.Fatalf("failed to remove file: %v", err)
+			}
+			summary, err = syncer.SyncUp(ctx, dir, "data", Options{})
+			checkSummary(t, summary, err, 0, 0, 0, 1)
+			summary, err = syncer.SyncUp(ctx, dir, "data", Options{Delete: true})
+			checkSummary(t, summary, err, 0, 0, 1, 1)
+			if _, err = client.StatObject(ctx, "data", "a.txt"); !errors.Is(err, s3.ErrObjectNotFound) {
+				t.Fatalf("expected removed file to be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncUpDown(t *testing.T) {
+	for _, compression := range []s3.Compression{s3.CompressionNone, s3.CompressionGzip, s3.CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			ctx := context.Background()
+			syncer := New(s3test.NewServer(t, func(options *s3.Options) {
+				options.Compression = compression
+			}))
+			files := map[string]string{
+				"a.txt":     "hello world",
+				"dir/b.txt": string(bytes.Repeat([]byte("compressible "), 1024)),
+			}
+			src, dst := t.TempDir(), t.TempDir()
+			writeFiles(t, src, files)
+
+			summary, err := syncer.SyncUp(ctx, src, "data", Options{})
+			checkSummary(t, summary, err, 2, 0, 0, 0)
+			summary, err = syncer.SyncUp(ctx, src, "data", Options{})
+			checkSummary(t, summary, err, 0, 0, 0, 2)
+
+			summary, err = syncer.SyncDown(ctx, "data", dst, Options{})
+			checkSummary(t, summary, err, 0, 2, 0, 0)
+			checkFiles(t, dst, files)
+			summary, err = syncer.SyncDown(ctx, "data", dst, Options{})
+			checkSummary(t, summary, err, 0, 0, 0, 2)
+
+			if err = os.Remove(filepath.Join(src, "a.txt")); err != nil {
+				t.Fatalf("failed to remove file: %v", err)
+			}
+			delete(files, "a.txt")
+			summary, err = syncer.SyncUp(ctx, src, "data", Options{Delete: true})
+			checkSummary(t, summary, err, 0, 0, 1, 1)
+			summary, err = syncer.SyncDown(ctx, "data", dst, Options{Delete: true})
+			checkSummary(t, summary, err, 0, 0, 1, 1)
+			checkFiles(t, dst, files)
+		})
+	}
+}
+
+func TestSyncUpChecksum(t *testing.T) {
+	ctx := context.Background()
+	syncer := New(s3test.NewServer(t))
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "first"})
+
+	summary, err := syncer.SyncUp(ctx, dir, "data", Options{Checksum: true})
+	checkSummary(t, summary, err, 1, 0, 0, 0)
+	summary, err = syncer.SyncUp(ctx, dir, "data", Options{Checksum: true})
+	checkSummary(t, summary, err, 0, 0, 0, 1)
+
+	// Same size and older than the object, only the checksum differs
+	writeFiles(t, dir, map[string]string{"a.txt": "later"})
+	past := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(filepath.Join(dir, "a.txt"), past, past); err != nil {
+		t.Fatalf("failed to change modification time: %v", err)
+	}
+	summary, err = syncer.SyncUp(ctx, dir, "data", Options{})
+	checkSummary(t, summary, err, 0, 0, 0, 1)
+	summary, err = syncer.SyncUp(ctx, dir, "data", Options{Checksum: true})
+	checkSummary(t, summary, err, 1, 0, 0, 0)
+}
+
+func TestSyncDryRun(t *testing.T) {
+	ctx := context.Background()
+	client := s3test.NewServer(t)
+	syncer := New(client)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+
+	var progress []Progress
+	summary, err := syncer.SyncUp(ctx, dir, "data", Options{
+		DryRun: true,
+		Progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	})
+	checkSummary(t, summary, err, 1, 0, 0, 0)
+	if len(progress) != 1 || progress[0].Key != "a.txt" || progress[0].Action != ActionUpload {
+		t.Fatalf("expected upload progress for a.txt, got %+v", progress)
+	}
+	if _, err = client.StatObject(ctx, "data", "a.txt"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected dry run not to upload, got %v", err)
+	}
+}
+
+func TestSyncBidi(t *testing.T) {
+	ctx := context.Background()
+	client := s3test.NewServer(t)
+	syncer := New(client)
+	dir := t.TempDir()
+	options := BidiOptions{StateFile: filepath.Join(t.TempDir(), "state.json")}
+	writeFiles(t, dir, map[string]string{"local.txt": "local"})
+	if _, err := client.PutObject(ctx, "data", "remote.txt", bytes.NewReader([]byte("remote")), 6, "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	summary, err := syncer.Sync(ctx, dir, "data", options)
+	checkSummary(t, summary, err, 1, 1, 0, 0)
+	checkFiles(t, dir, map[string]string{"local.txt": "local", "remote.txt": "remote"})
+
+	// A file deleted locally since the last sync is deleted remotely
+	if err = os.Remove(filepath.Join(dir, "local.txt")); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	summary, err = syncer.Sync(ctx, dir, "data", options)
+	checkSummary(t, summary, err, 0, 0, 1, 1)
+	if _, err = client.StatObject(ctx, "data", "local.txt"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected deleted file to be deleted remotely, got %v", err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	if path, err := localPath(dir, "dir/a.txt"); err != nil || path != filepath.Join(dir, "dir", "a.txt") {
+		t.Fatalf("expected path below %s, got %q: %v", dir, path, err)
+	}
+	for _, key := range []string{"../a.txt", "dir/../../a.txt"} {
+		var keyErr *s3.KeyError
+		if _, err := localPath(dir, key); !errors.As(err, &keyErr) {
+			t.Fatalf("expected KeyError for %q, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package sync
+
+import (
+	"context"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// SyncUp mirrors localDir to prefix, uploading the files that are missing
+// or changed. With Options.Delete, objects under prefix without a file in
+// localDir are deleted. It only returns an error if localDir or prefix
+// cannot be listed, failures of single files are in the summary.
+func (s *Syncer) SyncUp(ctx context.Context, localDir string, prefix string, opts Options) (*Summary, error) {
+	files, err := localFiles(localDir)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := s.remoteObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []task
+	for _, key := range sortedKeys(files) {
+		tasks = append(tasks, s.uploadTask(prefix, key, files[key], objects, opts))
+	}
+	if opts.Delete {
+		for _, key := range sortedKeys(objects) {
+			if _, ok := files[key]; !ok {
+				tasks = append(tasks, s.deleteTask(prefix, key, opts))
+			}
+		}
+	}
+
+	summary := new(Summary)
+	run(ctx, tasks, opts, summary)
+	return summary, nil
+}
+
+func (s *Syncer) uploadTask(prefix string, key string, f file, objects map[string]minio.ObjectInfo, opts Options) task {
+	return func(ctx context.Context) Progress {
+		if object, ok := objects[key]; ok {
+			upload, err := s.changed(ctx, prefix, key, f, object, opts.Checksum, func() bool {
+				return modifiedAfter(f.modTime, object.LastModified)
+			})
+			if err != nil {
+				return Progress{Key: key, Action: ActionUpload, Err: err}
+			}
+			if !upload {
+				return Progress{Key: key, Action: ActionSkip}
+			}
+		}
+
+		if opts.DryRun {
+			return Progress{Key: key, Action: ActionUpload, Bytes: f.size}
+		}
+		if _, err := s.upload(ctx, prefix, key, f); err != nil {
+			return Progress{Key: key, Action: ActionUpload, Err: err}
+		}
+		return Progress{Key: key, Action: ActionUpload, Bytes: f.size}
+	}
+}
+
+func (s *Syncer) upload(ctx context.Context, prefix string, key string, f file) (minio.UploadInfo, error) {
+	reader, err := os.Open(f.path)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer reader.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(f.path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return s.client.PutObject(ctx, prefix, key, reader, f.size, contentType)
+}
+
+func (s *Syncer) deleteTask(prefix string, key string, opts Options) task {
+	return func(ctx context.Context) Progress {
+		if opts.DryRun {
+			return Progress{Key: key, Action: ActionDelete}
+		}
+		if err := s.client.DeleteObject(ctx, prefix, key); err != nil {
+			return Progress{Key: key, Action: ActionDelete, Err: err}
+		}
+		return Progress{Key: key, Action: ActionDelete}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
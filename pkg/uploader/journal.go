@@ -0,0 +1,154 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package uploader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	recordAdd  = "add"
+	recordDone = "done"
+)
+
+// record is a single line in the journal
+type record struct {
+	Op   string `json:"op"`
+	ID   string `json:"id,omitempty"`
+	Item *Item  `json:"item,omitempty"`
+}
+
+// journal is an append-only log of enqueued and completed items
+type journal struct {
+	path string
+	f    *os.File
+}
+
+// openJournal replays the journal at path, compacts it down to the items
+// that are still pending, and opens it for appending
+func openJournal(path string) (*journal, []Item, error) {
+	var pending []Item
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		pending, err = replay(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create journal: %w", err)
+	}
+	j := &journal{
+		path: path,
+		f:    tmp,
+	}
+	for i := range pending {
+		if err = j.add(pending[i]); err != nil {
+			_ = tmp.Close()
+			return nil, nil, err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, nil, fmt.Errorf("failed to replace journal: %w", err)
+	}
+
+	j.f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	return j, pending, nil
+}
+
+func replay(f *os.File) ([]Item, error) {
+	var order []string
+	items := make(map[string]Item)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A torn write at the end of the journal from a crash, every
+			// record before it is intact
+			break
+		}
+		switch r.Op {
+		case recordAdd:
+			if r.Item != nil {
+				if _, ok := items[r.Item.ID]; !ok {
+					order = append(order, r.Item.ID)
+				}
+				items[r.Item.ID] = *r.Item
+			}
+		case recordDone:
+			delete(items, r.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	pending := make([]Item, 0, len(items))
+	for _, id := range order {
+		if item, ok := items[id]; ok {
+			pending = append(pending, item)
+			delete(items, id)
+		}
+	}
+	return pending, nil
+}
+
+func (j *journal) add(item Item) error {
+	return j.write(record{Op: recordAdd, Item: &item})
+}
+
+func (j *journal) done(id string) error {
+	return j.write(record{Op: recordDone, ID: id})
+}
+
+func (j *journal) write(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record: %w", err)
+	}
+	if _, err = j.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err = j.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journal) close() error {
+	return j.f.Close()
+}
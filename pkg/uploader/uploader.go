@@ -0,0 +1,268 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package uploader provides a persistent background upload queue.
+//
+// Items are journaled to disk when they are enqueued and again when they
+// complete, so items that were still pending when the process stopped are
+// uploaded again when a new Uploader is created with the same journal.
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/s3"
+)
+
+var (
+	ErrJournalRequired = errors.New("journal path is required")
+	ErrInvalidItem     = errors.New("item must have exactly one of a path or a payload")
+	ErrClosed          = errors.New("uploader is closed")
+)
+
+const (
+	DefaultWorkers        = 4
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+)
+
+type Options struct {
+	// JournalPath is the file pending uploads are journaled to
+	JournalPath string
+
+	Workers        int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// OnComplete is called once for every item, with a nil error if the
+	// upload succeeded or the last error once all attempts have failed
+	OnComplete func(item Item, err error)
+}
+
+// Item is a single upload, with its content either read from the file at
+// Path when it is uploaded or stored inline in Payload
+type Item struct {
+	ID          string `json:"id"`
+	Prefix      string `json:"prefix"`
+	Key         string `json:"key"`
+	Path        string `json:"path,omitempty"`
+	Payload     []byte `json:"payload,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Uploader uploads enqueued items in the background
+type Uploader struct {
+	client  s3.Storage
+	options Options
+	journal *journal
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []Item
+	closed  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates an Uploader, replaying any items that were still pending in
+// the journal, and starts its workers
+func New(client s3.Storage, options *Options) (*Uploader, error) {
+	if options.JournalPath == "" {
+		return nil, ErrJournalRequired
+	}
+
+	opts := *options
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+
+	j, pending, err := openJournal(opts.JournalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &Uploader{
+		client:  client,
+		options: opts,
+		journal: j,
+		pending: pending,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	u.cond = sync.NewCond(&u.mu)
+
+	for i := 0; i < opts.Workers; i++ {
+		u.wg.Add(1)
+		go u.work()
+	}
+
+	return u, nil
+}
+
+// Enqueue journals an item and queues it for upload, returning its ID. An
+// ID is generated if the item does not have one.
+func (u *Uploader) Enqueue(item Item) (string, error) {
+	if (item.Path == "") == (item.Payload == nil) {
+		return "", ErrInvalidItem
+	}
+	if item.ID == "" {
+		item.ID = randomID()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return "", ErrClosed
+	}
+
+	if err := u.journal.add(item); err != nil {
+		return "", err
+	}
+	u.pending = append(u.pending, item)
+	u.cond.Signal()
+
+	return item.ID, nil
+}
+
+// Pending returns the number of items waiting to be uploaded
+func (u *Uploader) Pending() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.pending)
+}
+
+// Close stops the workers, waiting for in-flight attempts to finish. Items
+// that have not been uploaded yet stay in the journal.
+func (u *Uploader) Close() error {
+	u.mu.Lock()
+	u.closed = true
+	u.cond.Broadcast()
+	u.mu.Unlock()
+
+	u.cancel()
+	u.wg.Wait()
+	return u.journal.close()
+}
+
+func (u *Uploader) work() {
+	defer u.wg.Done()
+	for {
+		u.mu.Lock()
+		for len(u.pending) == 0 && !u.closed {
+			u.cond.Wait()
+		}
+		if u.closed {
+			u.mu.Unlock()
+			return
+		}
+		item := u.pending[0]
+		u.pending = u.pending[1:]
+		u.mu.Unlock()
+
+		err := u.upload(item)
+		if errors.Is(err, context.Canceled) && u.ctx.Err() != nil {
+			// Closed mid-upload, the item stays in the journal for the next run
+			return
+		}
+
+		u.mu.Lock()
+		journalErr := u.journal.done(item.ID)
+		u.mu.Unlock()
+		if err == nil && journalErr != nil {
+			err = journalErr
+		}
+
+		if u.options.OnComplete != nil {
+			u.options.OnComplete(item, err)
+		}
+	}
+}
+
+// upload attempts to upload an item with exponential backoff between attempts
+func (u *Uploader) upload(item Item) error {
+	backoff := u.options.InitialBackoff
+	var err error
+	for attempt := 1; attempt <= u.options.MaxAttempts; attempt++ {
+		if err = u.attempt(item); err == nil {
+			return nil
+		}
+		if attempt == u.options.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-u.ctx.Done():
+			return u.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > u.options.MaxBackoff {
+			backoff = u.options.MaxBackoff
+		}
+	}
+	return fmt.Errorf("failed to upload item after %d attempts: %w", u.options.MaxAttempts, err)
+}
+
+func (u *Uploader) attempt(item Item) error {
+	var reader io.Reader
+	var size int64
+	if item.Path != "" {
+		f, err := os.Open(item.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		reader, size = f, stat.Size()
+	} else {
+		reader, size = bytes.NewReader(item.Payload), int64(len(item.Payload))
+	}
+
+	_, err := u.client.PutObject(u.ctx, item.Prefix, item.Key, reader, size, item.ContentType)
+	return err
+}
+
+func randomID() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
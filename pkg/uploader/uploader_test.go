@@ -0,0 +1,239 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3mem"
+)
+
+var errUnavailable = errors.New("storage unavailable")
+
+// flakyStorage fails the first failures uploads
+type flakyStorage struct {
+	s3.Storage
+	failures int64
+	attempts atomic.Int64
+}
+
+func (s *flakyStorage) PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string, opts ...s3.ObjectOption) (minio.UploadInfo, error) {
+	if s.attempts.Add(1) <= s.failures {
+		return minio.UploadInfo{}, errUnavailable
+	}
+	return s.Storage.PutObject(ctx, prefix, key, reader, objectSize, contentType, opts...)
+}
+
+type completion struct {
+	item Item
+	err  error
+}
+
+func newUploader(t *testing.T, client s3.Storage, options Options) (*Uploader, <-chan completion) {
+	t.Helper()
+	completed := make(chan completion, 16)
+	options.OnComplete = func(item Item, err error) {
+		completed <- completion{item: item, err: err}
+	}
+	u, err := New(client, &options)
+	if err != nil {
+		t.Fatalf("failed to create uploader: %v", err)
+	}
+	return u, completed
+}
+
+func waitCompletion(t *testing.T, completed <-chan completion) completion {
+	t.Helper()
+	select {
+	case c := <-completed:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an upload to complete")
+		return completion{}
+	}
+}
+
+func checkObject(t *testing.T, client s3.Storage, prefix string, key string, expected []byte) {
+	t.Helper()
+	reader, err := client.GetObject(context.Background(), prefix, key)
+	if err != nil {
+		t.Fatalf("failed to get %s/%s: %v", prefix, key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read %s/%s: %v", prefix, key, err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected %q in %s/%s, got %q", expected, prefix, key, data)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	client := s3mem.New("bucket")
+	u, completed := newUploader(t, client, Options{JournalPath: filepath.Join(dir, "journal")})
+	defer u.Close()
+
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("from a file"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	fileID, err := u.Enqueue(Item{Prefix: "data", Key: "file", Path: path, ContentType: "text/plain"})
+	if err != nil {
+		t.Fatalf("failed to enqueue file: %v", err)
+	}
+	payloadID, err := u.Enqueue(Item{ID: "payload", Prefix: "data", Key: "payload", Payload: []byte("from a payload")})
+	if err != nil {
+		t.Fatalf("failed to enqueue payload: %v", err)
+	}
+	if fileID == "" || payloadID != "payload" {
+		t.Fatalf("expected a generated ID and the given ID, got %q and %q", fileID, payloadID)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		c := waitCompletion(t, completed)
+		if c.err != nil {
+			t.Fatalf("failed to upload %s: %v", c.item.ID, c.err)
+		}
+		seen[c.item.ID] = true
+	}
+	if !seen[fileID] || !seen[payloadID] {
+		t.Fatalf("expected completions for %s and %s, got %v", fileID, payloadID, seen)
+	}
+	checkObject(t, client, "data", "file", []byte("from a file"))
+	checkObject(t, client, "data", "payload", []byte("from a payload"))
+	if pending := u.Pending(); pending != 0 {
+		t.Fatalf("expected no pending items, got %d", pending)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	client := &flakyStorage{Storage: s3mem.New("bucket"), failures: 2}
+	u, completed := newUploader(t, client, Options{
+		JournalPath:    filepath.Join(t.TempDir(), "journal"),
+		Workers:        1,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	})
+	defer u.Close()
+
+	if _, err := u.Enqueue(Item{Prefix: "data", Key: "a", Payload: []byte("hello")}); err != nil {
+		t.Fatalf("failed to enqueue item: %v", err)
+	}
+	if c := waitCompletion(t, completed); c.err != nil {
+		t.Fatalf("expected the upload to succeed on the last attempt: %v", c.err)
+	}
+	if attempts := client.attempts.Load(); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+	checkObject(t, client, "data", "a", []byte("hello"))
+
+	// Once every attempt has failed the last error is reported
+	client.failures = 1 << 30
+	client.attempts.Store(0)
+	if _, err := u.Enqueue(Item{Prefix: "data", Key: "b", Payload: []byte("hello")}); err != nil {
+		t.Fatalf("failed to enqueue item: %v", err)
+	}
+	if c := waitCompletion(t, completed); !errors.Is(c.err, errUnavailable) {
+		t.Fatalf("expected the last upload error, got %v", c.err)
+	}
+	if attempts := client.attempts.Load(); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "journal")
+
+	// Closing while the item is waiting to be retried leaves it pending
+	failing := &flakyStorage{Storage: s3mem.New("bucket"), failures: 1 << 30}
+	u, completed := newUploader(t, failing, Options{
+		JournalPath:    journalPath,
+		InitialBackoff: time.Hour,
+	})
+	if _, err := u.Enqueue(Item{ID: "a", Prefix: "data", Key: "a", Payload: []byte("hello")}); err != nil {
+		t.Fatalf("failed to enqueue item: %v", err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatalf("failed to close uploader: %v", err)
+	}
+	if _, err := u.Enqueue(Item{Prefix: "data", Key: "b", Payload: []byte("hello")}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed after closing, got %v", err)
+	}
+	select {
+	case c := <-completed:
+		t.Fatalf("expected no completion for an interrupted upload, got %v", c.err)
+	default:
+	}
+
+	// A torn write at the end of the journal is ignored
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+	if _, err = f.WriteString(`{"op":"add","item":{"id":"torn"`); err != nil {
+		t.Fatalf("failed to write journal: %v", err)
+	}
+	_ = f.Close()
+
+	client := s3mem.New("bucket")
+	u, completed = newUploader(t, client, Options{JournalPath: journalPath})
+	if c := waitCompletion(t, completed); c.err != nil || c.item.ID != "a" {
+		t.Fatalf("expected the pending item to be uploaded, got %s and %v", c.item.ID, c.err)
+	}
+	checkObject(t, client, "data", "a", []byte("hello"))
+
+	// Completed items are not replayed again
+	if err = u.Close(); err != nil {
+		t.Fatalf("failed to close uploader: %v", err)
+	}
+	u, _ = newUploader(t, client, Options{JournalPath: journalPath})
+	defer u.Close()
+	if pending := u.Pending(); pending != 0 {
+		t.Fatalf("expected no pending items after replay, got %d", pending)
+	}
+}
+
+func TestInvalidItem(t *testing.T) {
+	if _, err := New(s3mem.New("bucket"), &Options{}); !errors.Is(err, ErrJournalRequired) {
+		t.Fatalf("expected ErrJournalRequired, got %v", err)
+	}
+
+	u, _ := newUploader(t, s3mem.New("bucket"), Options{JournalPath: filepath.Join(t.TempDir(), "journal")})
+	defer u.Close()
+	for _, item := range []Item{
+		{Prefix: "data", Key: "a"},
+		{Prefix: "data", Key: "a", Path: "file", Payload: []byte("hello")},
+	} {
+		if _, err := u.Enqueue(item); !errors.Is(err, ErrInvalidItem) {
+			t.Fatalf("expected ErrInvalidItem, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// deleteBatchSize is the maximum number of keys in a single bulk delete request
+	deleteBatchSize = 1000
+)
+
+// ObjectFailure is an object that could not be processed by a prefix-wide operation
+type ObjectFailure struct {
+	Key string
+	Err error
+}
+
+// OverwritePolicy decides what CopyPrefix does when a destination object already exists
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces existing destination objects
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever skips objects that already exist at the destination
+	OverwriteNever
+	// OverwriteIfChanged only replaces destination objects whose size or ETag differ
+	OverwriteIfChanged
+)
+
+// CopyPrefixOptions are the options for CopyPrefix
+type CopyPrefixOptions struct {
+	Concurrency int
+	Overwrite   OverwritePolicy
+
+	// Progress is called after every object has been processed, it
+	// is never called concurrently
+	Progress func(progress CopyProgress)
+}
+
+// CopyProgress is reported to CopyPrefixOptions.Progress
+type CopyProgress struct {
+	Key     string
+	Copied  int
+	Skipped int
+	Failed  int
+}
+
+// CopySummary is the result of CopyPrefix
+type CopySummary struct {
+	Copied   int
+	Skipped  int
+	Failures []ObjectFailure
+}
+
+// StorageClassStats is the usage of a single storage class
+type StorageClassStats struct {
+	Objects int64
+	Bytes   int64
+}
+
+// PrefixStats is the usage of a prefix, returned by S3.PrefixStats
+type PrefixStats struct {
+	Objects int64
+	Bytes   int64
+
+	// StorageClasses breaks the usage down by storage class
+	StorageClasses map[string]StorageClassStats
+}
+
+func (p *PrefixStats) add(object minio.ObjectInfo) {
+	p.Objects++
+	p.Bytes += object.Size
+	class := p.StorageClasses[object.StorageClass]
+	class.Objects++
+	class.Bytes += object.Size
+	p.StorageClasses[object.StorageClass] = class
+}
+
+func (p *PrefixStats) merge(other PrefixStats) {
+	p.Objects += other.Objects
+	p.Bytes += other.Bytes
+	for name, otherClass := range other.StorageClasses {
+		class := p.StorageClasses[name]
+		class.Objects += otherClass.Objects
+		class.Bytes += otherClass.Bytes
+		p.StorageClasses[name] = class
+	}
+}
+
+// DeleteSummary is the result of DeletePrefix
+type DeleteSummary struct {
+	Deleted  int
+	Failures []ObjectFailure
+}
+
+// DeletePrefix deletes every object under the given prefix, sending bulk
+// delete requests from up to concurrency workers at a time.
+func (e *S3) DeletePrefix(ctx context.Context, prefix string, concurrency int) (*DeleteSummary, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	e.logOperation("DeletePrefix", "deleting prefix", "prefix", prefix, "bucket", e.options().Bucket, "concurrency", concurrency)
+
+	summary := new(DeleteSummary)
+	err := e.deleteListed(ctx, prefix, concurrency, summary, nil)
+	return summary, err
+}
+
+// deleteListed lists the objects under the given prefix and deletes those
+// that selected returns true for, or all of them if it is nil, with bulk
+// delete requests from up to concurrency workers at a time. selected is
+// called by the listing goroutine only.
+func (e *S3) deleteListed(ctx context.Context, prefix string, concurrency int, summary *DeleteSummary, selected func(object minio.ObjectInfo) bool) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	batches := make(chan []minio.ObjectInfo)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				deleted, failures := e.deleteBatch(ctx, batch)
+				mu.Lock()
+				summary.Deleted += deleted
+				summary.Failures = append(summary.Failures, failures...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	var listErr error
+	batch := make([]minio.ObjectInfo, 0, deleteBatchSize)
+	for object := range e.listRecursive(listCtx, prefix) {
+		if object.Err != nil {
+			listErr = object.Err
+			break
+		}
+		if selected != nil && !selected(object) {
+			continue
+		}
+		batch = append(batch, object)
+		if len(batch) == deleteBatchSize {
+			batches <- batch
+			batch = make([]minio.ObjectInfo, 0, deleteBatchSize)
+		}
+	}
+	if len(batch) > 0 && listErr == nil {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+
+	if listErr != nil {
+		return fmt.Errorf("failed to list objects: %w", listErr)
+	}
+
+	return nil
+}
+
+// CopyPrefix does a server-side copy of every object under srcPrefix to the same
+// relative key under dstPrefix.
+func (e *S3) CopyPrefix(ctx context.Context, srcPrefix string, dstPrefix string, opts CopyPrefixOptions) (*CopySummary, error) {
+	e.logOperation("CopyPrefix", "copying prefix", "source", srcPrefix, "prefix", dstPrefix, "bucket", e.options().Bucket)
+
+	summary := new(CopySummary)
+	var mu sync.Mutex
+	srcRoot := e.listPrefix(srcPrefix)
+	dstRoot := e.listPrefix(dstPrefix)
+	err := e.forEachObject(ctx, srcPrefix, opts.Concurrency, func(ctx context.Context, object minio.ObjectInfo) {
+		dstName := dstRoot + strings.TrimPrefix(object.Key, srcRoot)
+		copied, err := e.copyWithPolicy(ctx, object, dstName, dstPrefix, e.relativeKey(dstRoot, dstName), opts.Overwrite)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			summary.Failures = append(summary.Failures, ObjectFailure{
+				Key: e.relativeName(object.Key),
+				Err: err,
+			})
+		case copied:
+			summary.Copied++
+		default:
+			summary.Skipped++
+		}
+		if opts.Progress != nil {
+			opts.Progress(CopyProgress{
+				Key:     e.relativeName(object.Key),
+				Copied:  summary.Copied,
+				Skipped: summary.Skipped,
+				Failed:  len(summary.Failures),
+			})
+		}
+	})
+
+	return summary, err
+}
+
+func (e *S3) copyWithPolicy(ctx context.Context, object minio.ObjectInfo, dstName string, dstPrefix string, dstKey string, policy OverwritePolicy) (bool, error) {
+	if policy != OverwriteAlways {
+		existing, err := e.statObject(ctx, dstName, GetOptions{})
+		switch {
+		case err == nil:
+			if policy == OverwriteNever || (existing.Size == object.Size && existing.ETag == object.ETag) {
+				return false, nil
+			}
+		case !errors.Is(err, ErrObjectNotFound):
+			return false, err
+		}
+	}
+
+	_, err := e.copyObject(ctx, object.Key, dstName, dstPrefix, dstKey, CopyOptions{})
+	return err == nil, err
+}
+
+// forEachObject lists every object under the given prefix and calls fn for
+// each of them from up to concurrency workers at a time. It only returns an
+// error if the listing itself fails.
+func (e *S3) forEachObject(ctx context.Context, prefix string, concurrency int, fn func(ctx context.Context, object minio.ObjectInfo)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	var wg sync.WaitGroup
+	objects := make(chan minio.ObjectInfo)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objects {
+				fn(ctx, object)
+			}
+		}()
+	}
+
+	var listErr error
+	for object := range e.listRecursive(listCtx, prefix) {
+		if object.Err != nil {
+			listErr = fmt.Errorf("failed to list objects: %w", object.Err)
+			break
+		}
+		objects <- object
+	}
+	close(objects)
+	wg.Wait()
+
+	return listErr
+}
+
+// PrefixStats counts the objects and bytes stored under the given prefix.
+// Every sub-prefix directly under the prefix is listed by its own worker,
+// with up to concurrency listings running at a time.
+func (e *S3) PrefixStats(ctx context.Context, prefix string, concurrency int) (*PrefixStats, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	e.logOperation("PrefixStats", "computing prefix stats", "prefix", prefix, "bucket", e.options().Bucket, "concurrency", concurrency)
+
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	stats := &PrefixStats{
+		StorageClasses: make(map[string]StorageClassStats),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var listErr error
+	fail := func(err error) {
+		mu.Lock()
+		if listErr == nil {
+			listErr = fmt.Errorf("failed to list objects: %w", err)
+			listCancel()
+		}
+		mu.Unlock()
+	}
+
+	subPrefixes := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for subPrefix := range subPrefixes {
+				local := PrefixStats{
+					StorageClasses: make(map[string]StorageClassStats),
+				}
+				for object := range e.listObjects(listCtx, subPrefix, true) {
+					if object.Err != nil {
+						fail(object.Err)
+						break
+					}
+					local.add(object)
+				}
+				mu.Lock()
+				stats.merge(local)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for object := range e.listObjects(listCtx, e.listPrefix(prefix), false) {
+		if object.Err != nil {
+			fail(object.Err)
+			break
+		}
+		if isCommonPrefix(object) {
+			subPrefixes <- object.Key
+			continue
+		}
+		mu.Lock()
+		stats.add(object)
+		mu.Unlock()
+	}
+	close(subPrefixes)
+	wg.Wait()
+
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	return stats, nil
+}
+
+func (e *S3) deleteBatch(ctx context.Context, batch []minio.ObjectInfo) (int, []ObjectFailure) {
+	keys := make([]string, len(batch))
+	for i, object := range batch {
+		keys[i] = object.Key
+	}
+	ctx, op := e.startOperationWith(ctx, Operation{
+		Name:   "DeleteObjects",
+		Bucket: e.options().Bucket,
+		Keys:   keys,
+	})
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+
+	objects := make(chan minio.ObjectInfo, len(batch))
+	for _, object := range batch {
+		objects <- object
+		e.invalidate(ctx, object.Key)
+	}
+	close(objects)
+
+	var failures []ObjectFailure
+	if !e.batchDeletes() {
+		for object := range objects {
+			err := e.retry(ctx, func() error {
+				return e.client().RemoveObject(ctx, e.options().Bucket, object.Key, e.removeOpts)
+			})
+			if err != nil {
+				failures = append(failures, ObjectFailure{
+					Key: e.relativeName(object.Key),
+					Err: &OperationError{
+						Op:     op.Name,
+						Bucket: op.Bucket,
+						Key:    object.Key,
+						Err:    wrapError(err),
+					},
+				})
+			}
+		}
+	} else {
+		for result := range e.client().RemoveObjects(ctx, e.options().Bucket, objects, minio.RemoveObjectsOptions{}) {
+			failures = append(failures, ObjectFailure{
+				Key: e.relativeName(result.ObjectName),
+				Err: &OperationError{
+					Op:     op.Name,
+					Bucket: op.Bucket,
+					Key:    result.ObjectName,
+					Err:    wrapError(result.Err),
+				},
+			})
+		}
+	}
+
+	if len(failures) > 0 {
+		_ = op.finish(failures[0].Err)
+	} else {
+		_ = op.finish(nil)
+	}
+	return len(batch) - len(failures), failures
+}
+
+// listRecursive lists every object under the given prefix, including
+// objects nested under further delimiters
+func (e *S3) listRecursive(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	return e.listObjects(ctx, e.listPrefix(prefix), true)
+}
+
+// listObjects lists objects whose full name starts with objPrefix
+func (e *S3) listObjects(ctx context.Context, objPrefix string, recursive bool) <-chan minio.ObjectInfo {
+	return e.list(ctx, minio.ListObjectsOptions{
+		Prefix:    objPrefix,
+		Recursive: recursive,
+	})
+}
+
+// isCommonPrefix returns whether a non-recursive listing entry is a common
+// prefix rather than an object
+func isCommonPrefix(object minio.ObjectInfo) bool {
+	return object.ETag == "" && strings.HasSuffix(object.Key, "/")
+}
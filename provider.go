@@ -0,0 +1,106 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown provider")
+)
+
+// Provider adapts the client to the quirks of an S3 compatible provider
+type Provider string
+
+const (
+	// ProviderGeneric makes no assumptions about the endpoint, and is the
+	// default if no provider is set
+	ProviderGeneric Provider = "generic"
+
+	// ProviderR2 is Cloudflare R2, which only has the region "auto", has
+	// no storage classes, SSE-KMS or archive tiers to restore from
+	ProviderR2 Provider = "r2"
+
+	// ProviderGCS is the XML API of Google Cloud Storage with HMAC keys as
+	// the access key and secret key. It is listed with ListObjects V1, has
+	// no batch deletes and no bucket locations to look up.
+	ProviderGCS Provider = "gcs"
+)
+
+const (
+	GCSEndpoint = "storage.googleapis.com"
+)
+
+// R2Endpoint returns the R2 endpoint of a Cloudflare account
+func R2Endpoint(accountID string) string {
+	return accountID + ".r2.cloudflarestorage.com"
+}
+
+// withProvider returns a copy of the options adapted to their provider
+func withProvider(options *Options) (*Options, error) {
+	switch options.Provider {
+	case "", ProviderGeneric:
+		return options, nil
+	case ProviderR2:
+		if options.KMSKeyID != "" {
+			return nil, fmt.Errorf("%w: r2 does not support sse-kms", ErrNotImplemented)
+		}
+		o := *options
+		if o.Region == "" {
+			o.Region = "auto"
+		}
+		o.StorageClass = ""
+		return &o, nil
+	case ProviderGCS:
+		o := *options
+		if o.Endpoint == "" {
+			o.Endpoint = GCSEndpoint
+		}
+		// The region is only used for signing, and setting it skips the
+		// bucket location lookup that GCS does not support
+		if o.Region == "" {
+			o.Region = "auto"
+		}
+		return &o, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, options.Provider)
+}
+
+// storageClasses returns whether the provider supports storage classes,
+// which are ignored otherwise
+func (e *S3) storageClasses() bool {
+	return e.options().Provider != ProviderR2
+}
+
+// listV1 returns whether objects must be listed with ListObjects V1
+func (e *S3) listV1() bool {
+	return e.options().Provider == ProviderGCS
+}
+
+// batchDeletes returns whether the provider supports deleting several
+// objects in one request
+func (e *S3) batchDeletes() bool {
+	return e.options().Provider != ProviderGCS
+}
+
+// restores returns whether the provider has archive tiers that objects can
+// be restored from
+func (e *S3) restores() bool {
+	return e.options().Provider != ProviderR2
+}
@@ -0,0 +1,68 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+// PublicURL returns the unsigned URL of an object, for buckets that allow
+// anonymous reads or are served by a CDN. The URL starts with
+// Options.PublicBaseURL if it is set, and is otherwise on the endpoint with
+// the bucket in the host name or the path as selected by Options.BucketLookup.
+func (e *S3) PublicURL(prefix string, key string) (*url.URL, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.options().PublicBaseURL != "" {
+		base, err := url.Parse(e.options().PublicBaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public base url: %w", err)
+		}
+		setObjectPath(base, strings.TrimSuffix(base.EscapedPath(), "/"), objName)
+		return base, nil
+	}
+
+	u := e.client().EndpointURL()
+	virtualHost := s3utils.IsVirtualHostSupported(*u, e.options().Bucket)
+	switch e.options().BucketLookup {
+	case BucketLookupPath:
+		virtualHost = false
+	case BucketLookupDNS:
+		virtualHost = true
+	}
+	if virtualHost {
+		u.Host = e.options().Bucket + "." + u.Host
+		setObjectPath(u, "", objName)
+	} else {
+		setObjectPath(u, "/"+s3utils.EncodePath(e.options().Bucket), objName)
+	}
+	return u, nil
+}
+
+// setObjectPath sets the path of u to an object name under an escaped path,
+// escaping the name like S3 does (a + in a path is otherwise read as a space)
+func setObjectPath(u *url.URL, escapedPrefix string, objName string) {
+	u.RawPath = escapedPrefix + "/" + s3utils.EncodePath(objName)
+	u.Path, _ = url.PathUnescape(u.RawPath)
+}
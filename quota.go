@@ -0,0 +1,186 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+const (
+	adminPrefix = "/minio/admin/v3"
+
+	// noSuchQuota is the error code MinIO returns for buckets without a quota
+	noSuchQuota = "XMinioAdminNoSuchQuotaConfiguration"
+)
+
+type QuotaType string
+
+const (
+	// QuotaHard rejects writes to the bucket once it reaches its quota
+	QuotaHard QuotaType = "hard"
+)
+
+// BucketQuota is the storage quota of a bucket on a MinIO endpoint. A Size
+// of zero means the bucket has no quota.
+type BucketQuota struct {
+	// Size is the quota in bytes
+	Size uint64
+
+	// Type is how the quota is enforced, QuotaHard if empty
+	Type QuotaType
+}
+
+// bucketQuota is the JSON form of a quota in the MinIO admin API, which
+// still reads the size from the deprecated quota field on older servers
+type bucketQuota struct {
+	Quota uint64    `json:"quota"`
+	Size  uint64    `json:"size"`
+	Type  QuotaType `json:"quotatype,omitempty"`
+}
+
+// adminError is an error response of the MinIO admin API
+type adminError struct {
+	Code      string `json:"Code"`
+	Message   string `json:"Message"`
+	RequestID string `json:"RequestId"`
+	HostID    string `json:"HostId"`
+}
+
+// SetBucketQuota sets the storage quota of the configured bucket through
+// the MinIO admin API, or removes it if quota.Size is zero. It only works
+// if the endpoint is MinIO and the credentials may administer quotas.
+func (e *S3) SetBucketQuota(ctx context.Context, quota BucketQuota) error {
+	ctx, op := e.startOperation(ctx, "SetBucketQuota", e.options().Bucket, "")
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+
+	if quota.Type == "" {
+		quota.Type = QuotaHard
+	}
+	body, err := json.Marshal(bucketQuota{
+		Quota: quota.Size,
+		Size:  quota.Size,
+		Type:  quota.Type,
+	})
+	if err != nil {
+		return op.finish(fmt.Errorf("failed to encode bucket quota: %w", err))
+	}
+
+	err = e.retry(ctx, func() error {
+		_, err := e.admin(ctx, http.MethodPut, "set-bucket-quota", body)
+		return err
+	})
+	return op.finish(err)
+}
+
+// GetBucketQuota returns the storage quota of the configured bucket through
+// the MinIO admin API, with a Size of zero if the bucket has no quota
+func (e *S3) GetBucketQuota(ctx context.Context) (BucketQuota, error) {
+	ctx, op := e.startOperation(ctx, "GetBucketQuota", e.options().Bucket, "")
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+	defer cancel()
+
+	var body []byte
+	err := e.retry(ctx, func() error {
+		var err error
+		body, err = e.admin(ctx, http.MethodGet, "get-bucket-quota", nil)
+		return err
+	})
+	if ErrorResponse(err).Code == noSuchQuota {
+		return BucketQuota{}, op.finish(nil)
+	}
+	if err != nil {
+		return BucketQuota{}, op.finish(err)
+	}
+
+	var quota bucketQuota
+	if err = json.Unmarshal(body, &quota); err != nil {
+		return BucketQuota{}, op.finish(fmt.Errorf("failed to decode bucket quota: %w", err))
+	}
+	if quota.Size == 0 {
+		quota.Size = quota.Quota
+	}
+	return BucketQuota{
+		Size: quota.Size,
+		Type: quota.Type,
+	}, op.finish(nil)
+}
+
+// admin sends a request for the configured bucket to the MinIO admin API,
+// signed with the current credentials, and returns the response body
+func (e *S3) admin(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	creds, err := e.conn.credentials.Load().Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+
+	u := *e.client().EndpointURL()
+	u.Path = adminPrefix + "/" + path
+	u.RawQuery = url.Values{"bucket": []string{e.options().Bucket}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create admin request: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req.ContentLength = int64(len(body))
+
+	region := e.options().Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	req = signer.SignV4(*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, region)
+
+	resp, err := e.conn.httpClient.Load().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var adminErr adminError
+		_ = json.Unmarshal(data, &adminErr)
+		if adminErr.RequestID == "" {
+			adminErr.RequestID = resp.Header.Get("X-Amz-Request-Id")
+		}
+		return nil, minio.ErrorResponse{
+			Code:       adminErr.Code,
+			Message:    adminErr.Message,
+			BucketName: e.options().Bucket,
+			RequestID:  adminErr.RequestID,
+			HostID:     adminErr.HostID,
+			StatusCode: resp.StatusCode,
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,275 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	ErrInvalidOffset = errors.New("invalid offset")
+	ErrReaderClosed  = errors.New("reader is closed")
+)
+
+const (
+	DefaultReadRangeSize = 8 << 20
+)
+
+// ObjectReader reads an object with ranged GETs, implementing io.ReaderAt and
+// io.ReadSeekCloser. When reads are sequential, the next Options.PrefetchWindow
+// ranges are fetched in the background so they are ready by the time they are read.
+type ObjectReader struct {
+	e       *S3
+	objName string
+	info    minio.ObjectInfo
+
+	rangeSize int64
+	window    int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	offset  int64
+	lastEnd int64
+	ranges  map[int64]*readRange
+	closed  bool
+}
+
+type readRange struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// OpenObject returns an ObjectReader for an object
+func (e *S3) OpenObject(ctx context.Context, prefix string, key string) (*ObjectReader, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return nil, err
+	}
+	e.logOperation("OpenObject", "opening object", "key", objName, "bucket", e.options().Bucket)
+	info, err := e.statObject(ctx, objName, GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	rangeSize := e.options().ReadRangeSize
+	if rangeSize <= 0 {
+		rangeSize = DefaultReadRangeSize
+	}
+
+	readerCtx, cancel := context.WithCancel(ctx)
+	return &ObjectReader{
+		e:         e,
+		objName:   objName,
+		info:      info,
+		rangeSize: rangeSize,
+		window:    e.options().PrefetchWindow,
+		ctx:       readerCtx,
+		cancel:    cancel,
+		ranges:    make(map[int64]*readRange),
+	}, nil
+}
+
+// Stat returns the info of the object as it was when it was opened
+func (r *ObjectReader) Stat() minio.ObjectInfo {
+	return r.info
+}
+
+// Size returns the size of the object
+func (r *ObjectReader) Size() int64 {
+	return r.info.Size
+}
+
+func (r *ObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrInvalidOffset
+	}
+	if off >= r.info.Size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < r.info.Size {
+		index := off / r.rangeSize
+		rr, err := r.getRange(index, off)
+		if err != nil {
+			return n, err
+		}
+		<-rr.done
+		if rr.err != nil {
+			r.mu.Lock()
+			delete(r.ranges, index)
+			r.mu.Unlock()
+			return n, rr.err
+		}
+
+		copied := copy(p[n:], rr.data[off-index*r.rangeSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	r.mu.Lock()
+	r.lastEnd = off
+	r.mu.Unlock()
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	off := r.offset
+	r.mu.Unlock()
+
+	n, err := r.ReadAt(p, off)
+	r.mu.Lock()
+	r.offset = off + int64(n)
+	r.mu.Unlock()
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, fmt.Errorf("%w: invalid whence %d", ErrInvalidOffset, whence)
+	}
+	if offset < 0 {
+		return 0, ErrInvalidOffset
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *ObjectReader) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.ranges = make(map[int64]*readRange)
+	r.mu.Unlock()
+
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
+
+// getRange returns the range with the given index, starting a fetch for it if
+// necessary. Reads that continue where the previous one ended also schedule the
+// ranges in the prefetch window, and ranges outside of the window are dropped.
+func (r *ObjectReader) getRange(index int64, off int64) (*readRange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, ErrReaderClosed
+	}
+
+	rr := r.fetch(index)
+	if off == r.lastEnd {
+		for i := index + 1; i <= index+int64(r.window) && i*r.rangeSize < r.info.Size; i++ {
+			r.fetch(i)
+		}
+	}
+	for i := range r.ranges {
+		if i < index || i > index+int64(r.window) {
+			delete(r.ranges, i)
+		}
+	}
+
+	return rr, nil
+}
+
+// fetch must be called with mu held
+func (r *ObjectReader) fetch(index int64) *readRange {
+	if rr, ok := r.ranges[index]; ok {
+		return rr
+	}
+
+	rr := &readRange{
+		done: make(chan struct{}),
+	}
+	r.ranges[index] = rr
+
+	start := index * r.rangeSize
+	end := start + r.rangeSize - 1
+	if end >= r.info.Size {
+		end = r.info.Size - 1
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(rr.done)
+		rr.data, rr.err = r.e.getRange(r.ctx, r.objName, start, end)
+	}()
+
+	return rr
+}
+
+// getRange reads the inclusive byte range [start, end] of an object
+func (e *S3) getRange(ctx context.Context, objName string, start int64, end int64) ([]byte, error) {
+	ctx, op := e.startOperation(ctx, "GetObjectRange", e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+	defer cancel()
+	var data []byte
+	err := e.readRetry(ctx, func(client *minio.Client) (err error) {
+		data, err = e.getRangeOnce(ctx, client, objName, start, end)
+		return err
+	})
+	op.BytesReceived = int64(len(data))
+	return data, op.finish(err)
+}
+
+func (e *S3) getRangeOnce(ctx context.Context, client *minio.Client, objName string, start int64, end int64) ([]byte, error) {
+	getOpts := minio.GetObjectOptions{}
+	if err := getOpts.SetRange(start, end); err != nil {
+		return nil, err
+	}
+
+	obj, err := client.GetObject(ctx, e.options().Bucket, objName, getOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data := make([]byte, end-start+1)
+	if _, err = io.ReadFull(obj, data); err != nil {
+		return nil, fmt.Errorf("failed to read range %d-%d: %w", start, end, err)
+	}
+	return data, nil
+}
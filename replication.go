@@ -0,0 +1,498 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+var (
+	ErrReplicationFailed      = errors.New("replication failed")
+	ErrUnknownReplicationMode = errors.New("unknown replication mode")
+)
+
+const (
+	// DefaultReplicationRetryInterval is how often failed asynchronous
+	// replications are retried
+	DefaultReplicationRetryInterval = time.Second * 10
+)
+
+type ReplicationMode string
+
+const (
+	// ReplicationSync replicates every write before it returns, failing
+	// the write with ErrReplicationFailed if any replica could not be
+	// written even though the object was stored in the primary bucket
+	ReplicationSync ReplicationMode = "sync"
+
+	// ReplicationAsync records every write in the replication journal and
+	// replicates it in the background, retrying until it succeeds
+	ReplicationAsync ReplicationMode = "async"
+)
+
+type replicationOp string
+
+const (
+	replicationPut    replicationOp = "put"
+	replicationDelete replicationOp = "delete"
+)
+
+// replicationEntry is a write that still has to be replicated to one
+// replica. The journal stores one JSON line per entry, and another line
+// with only the ID and Done once it has been replicated.
+type replicationEntry struct {
+	ID      uint64        `json:"id"`
+	Op      replicationOp `json:"op,omitempty"`
+	Prefix  string        `json:"prefix,omitempty"`
+	Key     string        `json:"key,omitempty"`
+	Replica int           `json:"replica"`
+	Done    bool          `json:"done,omitempty"`
+
+	// Encryption is the customer key of the object, which is never written
+	// to the journal, so these writes are lost if the process exits before
+	// they have been replicated
+	Encryption encrypt.ServerSide `json:"-"`
+}
+
+// replicationSource describes a put for its replication, so that replicas
+// can be written from the uploaded data instead of reading the object back
+// from the primary bucket
+type replicationSource struct {
+	// data is the uploaded object between offset and offset+size, if it can
+	// be read again
+	data   io.ReaderAt
+	offset int64
+	size   int64
+
+	putOpts minio.PutObjectOptions
+
+	// encryption is the customer key the object was encrypted with, if any,
+	// which is needed to read it and is used for the replicas as well
+	encryption encrypt.ServerSide
+}
+
+// newReplicationSource returns the source of a put of reader, which is only
+// kept if it can be read again from its current offset
+func newReplicationSource(reader io.Reader, objectSize int64, putOpts minio.PutObjectOptions) *replicationSource {
+	src := &replicationSource{
+		putOpts:    putOpts,
+		encryption: customerKey(putOpts.ServerSideEncryption),
+	}
+	data, ok := reader.(io.ReaderAt)
+	seeker, seekable := reader.(io.Seeker)
+	if !ok || !seekable || objectSize < 0 {
+		return src
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return src
+	}
+	src.data, src.offset, src.size = data, offset, objectSize
+	return src
+}
+
+// customerKey returns encryption if it is a customer key (SSE-C), which
+// has to be sent again to read the object
+func customerKey(encryption encrypt.ServerSide) encrypt.ServerSide {
+	if encryption != nil && encryption.Type() == encrypt.SSEC {
+		return encryption
+	}
+	return nil
+}
+
+// replicator keeps the writes that are waiting for asynchronous replication
+type replicator struct {
+	mu      sync.Mutex
+	nextID  uint64
+	pending []replicationEntry
+	journal *os.File
+	wake    chan struct{}
+}
+
+// newReplicator validates the replication options and opens the journal,
+// returning nil if nothing is replicated asynchronously
+func newReplicator(options *Options, logger Logger) (*replicator, error) {
+	switch options.ReplicationMode {
+	case "", ReplicationSync:
+		return nil, nil
+	case ReplicationAsync:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownReplicationMode, options.ReplicationMode)
+	}
+	if len(options.Replicas) == 0 {
+		return nil, nil
+	}
+
+	r := &replicator{
+		nextID: 1,
+		wake:   make(chan struct{}, 1),
+	}
+	if options.ReplicationJournal == "" {
+		return r, nil
+	}
+
+	f, err := os.OpenFile(options.ReplicationJournal, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replication journal: %w", err)
+	}
+	entries := make(map[uint64]replicationEntry)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry replicationEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A partially written last line is left over from a crash
+			logger.Warn("skipping invalid replication journal entry", "journal", options.ReplicationJournal, "error", err)
+			continue
+		}
+		if entry.ID >= r.nextID {
+			r.nextID = entry.ID + 1
+		}
+		if entry.Done {
+			delete(entries, entry.ID)
+			continue
+		}
+		if entry.Replica >= len(options.Replicas) {
+			logger.Warn("dropping replication journal entry for a removed replica", "journal", options.ReplicationJournal, "replica", entry.Replica, "key", entry.Key)
+			continue
+		}
+		entries[entry.ID] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to read replication journal: %w", err)
+	}
+
+	for _, entry := range entries {
+		r.pending = append(r.pending, entry)
+	}
+	sort.Slice(r.pending, func(i, j int) bool {
+		return r.pending[i].ID < r.pending[j].ID
+	})
+	r.journal = f
+	if len(r.pending) > 0 {
+		logger.Info("resuming replication", "journal", options.ReplicationJournal, "pending", len(r.pending))
+		r.wake <- struct{}{}
+	}
+	return r, nil
+}
+
+// record adds a write for every replica to the journal
+func (r *replicator) record(op replicationOp, prefix string, key string, encryption encrypt.ServerSide, replicas int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]replicationEntry, replicas)
+	var lines []byte
+	for i := range entries {
+		entries[i] = replicationEntry{
+			ID:      r.nextID,
+			Op:      op,
+			Prefix:  prefix,
+			Key:     key,
+			Replica: i,
+
+			Encryption: encryption,
+		}
+		r.nextID++
+		line, err := json.Marshal(entries[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode replication journal entry: %w", err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := r.write(lines); err != nil {
+		return err
+	}
+
+	r.pending = append(r.pending, entries...)
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// complete removes replicated entries, and empties the journal once nothing
+// is pending anymore
+func (r *replicator) complete(done map[uint64]bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := r.pending[:0]
+	var lines []byte
+	for _, entry := range r.pending {
+		if !done[entry.ID] {
+			pending = append(pending, entry)
+			continue
+		}
+		line, err := json.Marshal(replicationEntry{
+			ID:   entry.ID,
+			Done: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode replication journal entry: %w", err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	r.pending = pending
+
+	if r.journal != nil && len(r.pending) == 0 {
+		if err := r.journal.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate replication journal: %w", err)
+		}
+		return nil
+	}
+	return r.write(lines)
+}
+
+// write appends lines to the journal and syncs it, it must be called with
+// the lock held
+func (r *replicator) write(lines []byte) error {
+	if r.journal == nil || len(lines) == 0 {
+		return nil
+	}
+	if _, err := r.journal.Write(lines); err != nil {
+		return fmt.Errorf("failed to write replication journal: %w", err)
+	}
+	if err := r.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync replication journal: %w", err)
+	}
+	return nil
+}
+
+// snapshot returns the pending entries
+func (r *replicator) snapshot() []replicationEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]replicationEntry(nil), r.pending...)
+}
+
+// PendingReplications returns the number of writes that are still waiting
+// to be replicated in ReplicationAsync mode, counting every replica
+func (e *S3) PendingReplications() int {
+	if e.replication == nil {
+		return 0
+	}
+	e.replication.mu.Lock()
+	defer e.replication.mu.Unlock()
+	return len(e.replication.pending)
+}
+
+// replicated replicates a write of the object to every replica, or records
+// it for the background replication in ReplicationAsync mode. src is nil
+// for deletes.
+func (e *S3) replicated(ctx context.Context, op replicationOp, prefix string, key string, src *replicationSource) error {
+	replicas := e.options().Replicas
+	if len(replicas) == 0 {
+		return nil
+	}
+	if e.replication != nil {
+		var encryption encrypt.ServerSide
+		if src != nil {
+			encryption = src.encryption
+		}
+		if err := e.replication.record(op, prefix, key, encryption, len(replicas)); err != nil {
+			return fmt.Errorf("%w: %w", ErrReplicationFailed, err)
+		}
+		return nil
+	}
+
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for i, replica := range replicas {
+		wg.Add(1)
+		go func(i int, replica *S3) {
+			defer wg.Done()
+			if err := e.replicate(ctx, replica, op, prefix, key, src); err != nil {
+				errs[i] = fmt.Errorf("%w to %s: %w", ErrReplicationFailed, replica.options().Bucket, err)
+			}
+		}(i, replica)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// replicate applies a write of the object to the replica. Puts are written
+// from the uploaded data if src has it, copied server-side if the replica is
+// on the same endpoint, and otherwise copied from the primary bucket.
+func (e *S3) replicate(ctx context.Context, replica *S3, op replicationOp, prefix string, key string, src *replicationSource) error {
+	replicaName, err := replica.objectKey(prefix, key)
+	if err != nil {
+		return err
+	}
+	defer replica.invalidate(ctx, replicaName)
+
+	if op == replicationDelete {
+		ctx, replicaOp := replica.startOperation(ctx, "ReplicateDeleteObject", replica.options().Bucket, replicaName)
+		err = replica.retry(ctx, func() error {
+			return replica.client().RemoveObject(ctx, replica.options().Bucket, replicaName, replica.removeOpts)
+		})
+		return replicaOp.finish(err)
+	}
+
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		src = &replicationSource{}
+	}
+	ctx, replicaOp := replica.startOperation(ctx, "ReplicatePutObject", replica.options().Bucket, replicaName)
+
+	if src.data != nil {
+		putOpts := replica.replicaPutOptions(src.putOpts, src.encryption)
+		_, err = replica.putObject(ctx, replicaName, io.NewSectionReader(src.data, src.offset, src.size), src.size, putOpts)
+		replicaOp.BytesSent = src.size
+		return replicaOp.finish(err)
+	}
+
+	if e.sameEndpoint(replica) {
+		// The copy fails if the credentials of the replica can't read the
+		// primary bucket, or for objects larger than 5 GiB, in which case
+		// the object is copied through the client instead
+		err = replica.retry(ctx, func() error {
+			_, err := replica.client().CopyObject(ctx, minio.CopyDestOptions{
+				Bucket:     replica.options().Bucket,
+				Object:     replicaName,
+				Encryption: replica.encryptionOrDefault(src.encryption),
+			}, minio.CopySrcOptions{
+				Bucket:     e.options().Bucket,
+				Object:     objName,
+				Encryption: src.encryption,
+			})
+			return err
+		})
+		if err == nil || errors.Is(wrapError(err), ErrObjectNotFound) {
+			return replicaOp.finish(nil)
+		}
+		e.logger.Debug("failed to copy object to replica server-side", "replica", replica.options().Bucket, "key", objName, "error", err)
+	}
+
+	var object *minio.Object
+	var info minio.ObjectInfo
+	err = e.retry(ctx, func() (err error) {
+		object, err = e.client().GetObject(ctx, e.options().Bucket, objName, minio.GetObjectOptions{
+			ServerSideEncryption: src.encryption,
+		})
+		if err != nil {
+			return err
+		}
+		if info, err = object.Stat(); err != nil {
+			_ = object.Close()
+		}
+		return err
+	})
+	if errors.Is(wrapError(err), ErrObjectNotFound) {
+		// The object was deleted since, which is replicated separately
+		return replicaOp.finish(nil)
+	}
+	if err != nil {
+		return replicaOp.finish(err)
+	}
+	defer object.Close()
+
+	putOpts := replica.replicaPutOptions(minio.PutObjectOptions{
+		ContentType:        info.ContentType,
+		CacheControl:       info.Metadata.Get("Cache-Control"),
+		ContentDisposition: info.Metadata.Get("Content-Disposition"),
+		ContentEncoding:    info.Metadata.Get("Content-Encoding"),
+		ContentLanguage:    info.Metadata.Get("Content-Language"),
+		UserMetadata:       info.UserMetadata,
+	}, src.encryption)
+	_, err = replica.putObject(ctx, replicaName, object, info.Size, putOpts)
+	replicaOp.BytesSent = info.Size
+	return replicaOp.finish(err)
+}
+
+// replicaPutOptions returns the options for writing a replica of an object
+// stored with the given options, encrypting it with the same customer key
+func (e *S3) replicaPutOptions(stored minio.PutObjectOptions, encryption encrypt.ServerSide) minio.PutObjectOptions {
+	putOpts := e.putObjectOptions(PutOptions{
+		ContentType:        stored.ContentType,
+		CacheControl:       stored.CacheControl,
+		ContentDisposition: stored.ContentDisposition,
+		ContentEncoding:    stored.ContentEncoding,
+		ContentLanguage:    stored.ContentLanguage,
+		Encryption:         encryption,
+	})
+	putOpts.UserMetadata = mergeMetadata(nil, stored.UserMetadata)
+	return putOpts
+}
+
+// sameEndpoint returns whether the replica is on the same endpoint as the
+// client, so that objects can be copied to it server-side
+func (e *S3) sameEndpoint(replica *S3) bool {
+	return e.options().Endpoint == replica.options().Endpoint && e.options().Secure == replica.options().Secure
+}
+
+// replicateAsync replicates the writes recorded in the journal in the
+// background until the client is closed
+func (e *S3) replicateAsync() {
+	defer e.wg.Done()
+	defer func() {
+		if e.replication.journal != nil {
+			_ = e.replication.journal.Close()
+		}
+	}()
+	ticker := time.NewTicker(DefaultReplicationRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.replication.wake:
+		case <-ticker.C:
+		}
+
+		// Entries for a replica are applied in order, so a failed entry
+		// holds back the later writes to the same replica until it is retried
+		done := make(map[uint64]bool)
+		failed := make(map[int]bool)
+		for _, entry := range e.replication.snapshot() {
+			if failed[entry.Replica] {
+				continue
+			}
+			err := e.replicate(e.ctx, e.options().Replicas[entry.Replica], entry.Op, entry.Prefix, entry.Key, &replicationSource{
+				encryption: entry.Encryption,
+			})
+			if err != nil {
+				if e.ctx.Err() != nil {
+					return
+				}
+				e.logger.Warn("failed to replicate object", "replica", entry.Replica, "key", entry.Key, "error", err)
+				failed[entry.Replica] = true
+				continue
+			}
+			done[entry.ID] = true
+		}
+		if err := e.replication.complete(done); err != nil {
+			e.logger.Error("failed to update replication journal", "error", err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func readObject(t *testing.T, client *s3.S3, prefix string, key string) []byte {
+	t.Helper()
+	reader, err := client.GetObject(context.Background(), prefix, key)
+	if err != nil {
+		t.Fatalf("failed to get %s/%s: %v", prefix, key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read %s/%s: %v", prefix, key, err)
+	}
+	return data
+}
+
+func TestReplicatedCopies(t *testing.T) {
+	ctx := context.Background()
+	replica := s3test.NewServer(t)
+	client := s3test.NewServer(t, func(options *s3.Options) {
+		options.Replicas = []*s3.S3{replica}
+	})
+	data := []byte("hello world")
+	if _, err := client.PutObject(ctx, "data", "a", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("failed to put object: %v", err)
+	}
+
+	if _, err := client.CopyObject(ctx, "data", "a", "copy", "a"); err != nil {
+		t.Fatalf("failed to copy object: %v", err)
+	}
+	if read := readObject(t, replica, "copy", "a"); !bytes.Equal(read, data) {
+		t.Fatalf("expected copy to be replicated, got %q", read)
+	}
+
+	if _, err := client.MoveObject(ctx, "data", "a", "moved", "a"); err != nil {
+		t.Fatalf("failed to move object: %v", err)
+	}
+	if read := readObject(t, replica, "moved", "a"); !bytes.Equal(read, data) {
+		t.Fatalf("expected moved object to be replicated, got %q", read)
+	}
+	if _, err := replica.StatObject(ctx, "data", "a"); !errors.Is(err, s3.ErrObjectNotFound) {
+		t.Fatalf("expected source of the move to be deleted from the replica, got %v", err)
+	}
+
+	summary, err := client.CopyPrefix(ctx, "moved", "prefix", s3.CopyPrefixOptions{})
+	if err != nil || summary.Copied != 1 {
+		t.Fatalf("failed to copy prefix: %+v, %v", summary, err)
+	}
+	if read := readObject(t, replica, "prefix", "a"); !bytes.Equal(read, data) {
+		t.Fatalf("expected copied prefix to be replicated, got %q", read)
+	}
+}
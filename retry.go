@@ -0,0 +1,239 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	DefaultRetryInitialBackoff = time.Millisecond * 100
+	DefaultRetryMaxBackoff     = time.Second * 10
+)
+
+// RetryPolicy configures how failed operations are retried by the wrapper.
+// Retries happen on top of minio-go's own retries for failed connections.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per operation, retries
+	// are disabled if it is zero or one
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry, which doubles
+	// after every attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Jitter randomizes every delay by up to the given fraction of itself
+	Jitter float64
+
+	// Retryable decides which errors are retried, DefaultRetryable if nil
+	Retryable func(err error) bool
+}
+
+// DefaultRetryable retries throttling, server-side errors, timeouts and
+// network errors, but never context cancellation or client errors
+func DefaultRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) {
+		switch errResp.Code {
+		case "SlowDown", "RequestTimeout", "RequestTimeTooSkewed", "InternalError", "ServiceUnavailable", "Throttling", "ThrottlingException":
+			return true
+		}
+		return errResp.StatusCode == http.StatusTooManyRequests || errResp.StatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retries returns whether the wrapper retries failed operations, on the
+// same endpoint or by failing over to another one
+func (e *S3) retries() bool {
+	return e.options().Retry.MaxAttempts > 1 || e.endpoints() > 1 || e.conn.readers.Load() != nil
+}
+
+// retry calls fn until it succeeds, returns an error that isn't
+// retryable, or the retry policy runs out of attempts. Attempts that fail
+// over to another endpoint are sent again immediately and don't count
+// against the policy.
+func (e *S3) retry(ctx context.Context, fn func() error) error {
+	policy := e.options().Retry
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	var err error
+	failovers := 0
+	for attempt := 1; ; attempt++ {
+		client := e.client()
+		if err = fn(); err == nil {
+			return nil
+		}
+		if failovers < e.endpoints()-1 && e.failover(client, err) && ctx.Err() == nil {
+			failovers++
+			attempt--
+			continue
+		}
+		if attempt >= policy.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := e.backoff(attempt)
+		e.logger.Debug("retrying failed attempt", "attempt", attempt, "delay", delay, "error", err)
+		retried(ctx, attempt, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the delay after the given attempt
+func (e *S3) backoff(attempt int) time.Duration {
+	policy := e.options().Retry
+	delay := policy.InitialBackoff
+	if delay <= 0 {
+		delay = DefaultRetryInitialBackoff
+	}
+	maxDelay := policy.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxBackoff
+	}
+
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if policy.Jitter > 0 {
+		jitter := float64(delay) * policy.Jitter
+		delay += time.Duration(jitter * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// list lists objects, restarting the listing after the last received key if it
+// fails with a retryable error. Entries are forwarded in the same order as
+// minio-go returns them, and an error is only sent once all attempts have failed.
+func (e *S3) list(ctx context.Context, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	ctx, op := e.startOperation(ctx, "ListObjects", e.options().Bucket, opts.Prefix)
+	if e.listV1() {
+		opts.UseV1 = true
+	}
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.List)
+	out := make(chan minio.ObjectInfo, 1)
+	// Version listings return several entries per key, so they can't be
+	// restarted after the last key like other listings
+	if !e.retries() || opts.WithVersions {
+		go func() {
+			defer close(out)
+			defer cancel()
+			var err error
+			defer func() { _ = op.finish(err) }()
+			for object := range e.client().ListObjects(ctx, e.options().Bucket, opts) {
+				if object.Err != nil {
+					object.Err = op.wrap(object.Err)
+					err = object.Err
+				}
+				select {
+				case out <- object:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+		var err error
+		defer func() { _ = op.finish(err) }()
+		lastKey := ""
+		failovers := 0
+		for attempt := 1; ; attempt++ {
+			var failed *minio.ObjectInfo
+			client := e.client()
+			for object := range client.ListObjects(ctx, e.options().Bucket, opts) {
+				if object.Err != nil {
+					failed = &object
+					break
+				}
+				// Restarted listings start after the last key, but a common
+				// prefix can be returned again if objects below it remain
+				if lastKey != "" && object.Key <= lastKey {
+					continue
+				}
+				lastKey = object.Key
+				select {
+				case out <- object:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if failed == nil {
+				return
+			}
+			if failovers < e.endpoints()-1 && e.failover(client, failed.Err) && ctx.Err() == nil {
+				failovers++
+				attempt--
+				opts.StartAfter = lastKey
+				continue
+			}
+
+			retryable := e.options().Retry.Retryable
+			if retryable == nil {
+				retryable = DefaultRetryable
+			}
+			if attempt >= e.options().Retry.MaxAttempts || !retryable(failed.Err) {
+				failed.Err = op.wrap(failed.Err)
+				err = failed.Err
+				select {
+				case out <- *failed:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			retried(ctx, attempt, failed.Err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(e.backoff(attempt)):
+			}
+			opts.StartAfter = lastKey
+		}
+	}()
+	return out
+}
@@ -0,0 +1,176 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// readLatencyWeight is the weight of the latest read in the moving
+	// average latency of a read endpoint
+	readLatencyWeight = 0.2
+
+	// maxReadEndpointBackoff is the longest time a failed read endpoint is
+	// skipped for, the time doubles with every consecutive failure
+	maxReadEndpointBackoff = time.Second * 32
+)
+
+// readEndpoint is an endpoint that reads are routed to, with its moving
+// average latency and health
+type readEndpoint struct {
+	// client is nil for the main endpoint, which reads with the active client
+	client *minio.Client
+
+	latency   atomic.Int64
+	failures  atomic.Int32
+	downUntil atomic.Int64
+}
+
+// succeeded records the latency of a read that got a response
+func (r *readEndpoint) succeeded(latency time.Duration) {
+	r.failures.Store(0)
+	r.downUntil.Store(0)
+	for {
+		old := r.latency.Load()
+		average := int64(latency)
+		if old > 0 {
+			average = int64(readLatencyWeight*float64(latency) + (1-readLatencyWeight)*float64(old))
+		}
+		if r.latency.CompareAndSwap(old, average) {
+			return
+		}
+	}
+}
+
+// failed skips the endpoint for a backoff that grows with every
+// consecutive failure
+func (r *readEndpoint) failed() {
+	failures := r.failures.Add(1)
+	backoff := maxReadEndpointBackoff
+	if failures <= 6 {
+		backoff = time.Second << (failures - 1)
+	}
+	r.downUntil.Store(time.Now().Add(backoff).UnixNano())
+}
+
+// ReadEndpointStatus is the routing state of an endpoint that reads are
+// routed to
+type ReadEndpointStatus struct {
+	Endpoint string
+
+	// Latency is the moving average latency of reads, zero until the
+	// first read
+	Latency time.Duration
+
+	// Healthy is false while the endpoint is skipped after failed reads
+	Healthy bool
+}
+
+// ReadEndpoints returns the routing state of the main endpoint and
+// Options.ReadEndpoints, or nil if reads are not routed
+func (e *S3) ReadEndpoints() []ReadEndpointStatus {
+	readers := e.conn.readers.Load()
+	if readers == nil {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	statuses := make([]ReadEndpointStatus, len(*readers))
+	for i, r := range *readers {
+		client := r.client
+		if client == nil {
+			client = e.client()
+		}
+		statuses[i] = ReadEndpointStatus{
+			Endpoint: client.EndpointURL().Host,
+			Latency:  time.Duration(r.latency.Load()),
+			Healthy:  r.downUntil.Load() <= now,
+		}
+	}
+	return statuses
+}
+
+// route returns the index of the healthy endpoint with the lowest latency
+// that has not been tried, preferring endpoints without measurements so
+// that they are measured. If every untried endpoint is unhealthy it returns
+// the one that recovers first.
+func route(readers []*readEndpoint, tried map[int]bool) int {
+	now := time.Now().UnixNano()
+	best := -1
+	for i, r := range readers {
+		if tried[i] || r.downUntil.Load() > now {
+			continue
+		}
+		if best < 0 || r.latency.Load() < readers[best].latency.Load() {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return best
+	}
+	for i, r := range readers {
+		if tried[i] {
+			continue
+		}
+		if best < 0 || r.downUntil.Load() < readers[best].downUntil.Load() {
+			best = i
+		}
+	}
+	return best
+}
+
+// readRetry is retry for reads, which calls fn with the client of the
+// endpoint that the read is routed to. Reads that fail with an endpoint
+// failure are sent to the next endpoint right away, and the retry policy
+// only applies once every endpoint has failed.
+func (e *S3) readRetry(ctx context.Context, fn func(client *minio.Client) error) error {
+	readers := e.conn.readers.Load()
+	if readers == nil {
+		return e.retry(ctx, func() error {
+			return fn(e.client())
+		})
+	}
+
+	return e.retry(ctx, func() error {
+		tried := make(map[int]bool, len(*readers))
+		for {
+			i := route(*readers, tried)
+			r := (*readers)[i]
+			client := r.client
+			if client == nil {
+				client = e.client()
+			}
+
+			start := time.Now()
+			err := fn(client)
+			if err == nil || !failoverable(err) {
+				r.succeeded(time.Since(start))
+				return err
+			}
+			r.failed()
+			tried[i] = true
+			if len(tried) == len(*readers) || ctx.Err() != nil {
+				return err
+			}
+			e.logger.Debug("routing read to another endpoint", "failed", client.EndpointURL().Host, "error", err)
+		}
+	})
+}
@@ -14,6 +14,11 @@
 	limitations under the License.
 */
 
+// Package s3 is a client for S3 compatible object storage built on minio-go.
+//
+// Bucket quotas are managed through the MinIO admin API with requests that
+// are signed by minio-go, instead of through madmin-go, which would pull its
+// large dependency tree into every user of the package for two endpoints.
 package s3
 
 import (
@@ -21,17 +26,44 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	ErrDisabled = errors.New("s3 is disabled")
+	ErrDisabled    = errors.New("s3 is disabled")
+	ErrNotModified = errors.New("object not modified")
+
+	ErrObjectExists = errors.New("object already exists")
+)
+
+const (
+	DefaultKeyDelimiter  = "/"
+	DefaultPresignExpiry = 15 * time.Minute
+)
+
+const (
+	StorageClassStandard           = "STANDARD"
+	StorageClassReducedRedundancy  = "REDUCED_REDUNDANCY"
+	StorageClassStandardIA         = "STANDARD_IA"
+	StorageClassOnezoneIA          = "ONEZONE_IA"
+	StorageClassIntelligentTiering = "INTELLIGENT_TIERING"
+	StorageClassGlacier            = "GLACIER"
+	StorageClassGlacierIR          = "GLACIER_IR"
+	StorageClassDeepArchive        = "DEEP_ARCHIVE"
 )
 
 type Options struct {
@@ -43,107 +75,1406 @@ type Options struct {
 	Bucket    string
 	AccessKey string
 	SecretKey string
+
+	// BucketLookup selects whether the bucket is in the host name or the path
+	// of request URLs, BucketLookupAuto if empty
+	BucketLookup BucketLookup
+
+	// Provider adapts the client to the quirks of the provider of the
+	// endpoint, ProviderGeneric if empty
+	Provider Provider
+
+	// SessionToken is sent with AccessKey and SecretKey when they are
+	// temporary credentials issued by STS
+	SessionToken string
+
+	// AccessKeyFile and SecretKeyFile are files that the access key and
+	// secret key are read from instead, such as mounted Kubernetes secrets.
+	// If WatchSecretFiles is set, they are read again when they change.
+	AccessKeyFile    string
+	SecretKeyFile    string
+	WatchSecretFiles bool
+
+	// SignatureV2 signs requests with the legacy Signature V2 instead of V4,
+	// for appliances that do not support V4
+	SignatureV2 bool
+
+	// Credentials signs requests instead of AccessKey and SecretKey, for
+	// credentials that are fetched or rotated at runtime. See
+	// RotatingCredentials and NewFuncProvider.
+	Credentials *credentials.Credentials
+
+	// CredentialsSource selects where credentials come from if Credentials is
+	// not set, CredentialsStatic if empty. IAMEndpoint overrides the instance
+	// metadata endpoint used by CredentialsIAM.
+	CredentialsSource CredentialsSource
+	IAMEndpoint       string
+
+	// AssumeRoleARN is the role assumed with CredentialsAssumeRole and
+	// CredentialsWebIdentity, through STSEndpoint (DefaultSTSEndpoint if
+	// empty). The session lasts for AssumeRoleDuration, or an hour if zero.
+	AssumeRoleARN         string
+	AssumeRoleExternalID  string
+	AssumeRoleSessionName string
+	AssumeRoleDuration    time.Duration
+	STSEndpoint           string
+
+	// WebIdentityTokenFile is the token exchanged with CredentialsWebIdentity,
+	// taken from the AWS_WEB_IDENTITY_TOKEN_FILE variable if empty
+	WebIdentityTokenFile string
+
+	// SharedCredentialsFile is the AWS credentials file read by CredentialsFile
+	// and CredentialsChain, taken from AWS_SHARED_CREDENTIALS_FILE or
+	// ~/.aws/credentials if empty. SharedCredentialsProfile is the profile
+	// read from it, taken from AWS_PROFILE or "default" if empty.
+	SharedCredentialsFile    string
+	SharedCredentialsProfile string
+
+	// Logger receives the client's logs instead of the logger passed to New
+	// or NewWithLogger
+	Logger Logger
+
+	// StorageClass is the default storage class used by PutObject when
+	// the call does not specify one. An empty value uses the bucket default.
+	StorageClass string
+
+	// KMSKeyID enables SSE-KMS by default for uploads and copies that do not
+	// specify their own encryption, using the given key and encryption context
+	KMSKeyID   string
+	KMSContext map[string]string
+
+	// Compression transparently compresses uploaded objects. Objects are
+	// compressed in memory before they are uploaded, so this is meant for
+	// small, compressible payloads. Downloads decompress any object that was
+	// compressed this way, whether or not Compression is set.
+	Compression Compression
+
+	// ReaperInterval enables a background reaper that deletes objects whose
+	// TTL has passed at the given interval, for endpoints that don't support
+	// lifecycle rules. ReaperPrefix limits it to objects under a prefix.
+	ReaperInterval time.Duration
+	ReaperPrefix   string
+
+	// DiskCacheDir enables a local disk cache for GetObject in the given
+	// directory, holding up to DiskCacheMaxBytes (DefaultDiskCacheMaxBytes
+	// if zero). Cached objects are revalidated by ETag on every read.
+	DiskCacheDir      string
+	DiskCacheMaxBytes int64
+
+	// MemoryCacheMaxBytes enables an in-memory cache for GetObject holding
+	// up to the given number of bytes. Cached objects are served without
+	// contacting the endpoint for MemoryCacheTTL (DefaultMemoryCacheTTL if
+	// zero), and are invalidated when they are changed through this client.
+	MemoryCacheMaxBytes int64
+	MemoryCacheTTL      time.Duration
+
+	// Cache plugs a custom Cache into GetObject, it can't be combined with
+	// the built-in disk and memory caches
+	Cache Cache
+
+	// ReadRangeSize is the size of the ranges requested by ObjectReader,
+	// DefaultReadRangeSize if zero. PrefetchWindow is the number of ranges
+	// fetched ahead in the background while an ObjectReader is read sequentially.
+	ReadRangeSize  int64
+	PrefetchWindow int
+
+	// PartSize is the size of the parts of multipart uploads, chosen from the
+	// object size if zero. UploadConcurrency is the number of parts of an
+	// upload that are sent at once, 4 if zero.
+	PartSize          uint64
+	UploadConcurrency uint
+
+	// PresignExpiry is how long presigned URLs are valid for if no expiry is
+	// given, DefaultPresignExpiry if zero
+	PresignExpiry time.Duration
+
+	// RateLimit limits the client to the given number of requests per second
+	// across all operations, allowing bursts of up to RateBurst requests
+	// (defaults to one second's worth). Disabled if zero.
+	RateLimit float64
+	RateBurst int
+
+	// Retry configures how failed operations are retried
+	Retry RetryPolicy
+
+	// Timeouts are the default timeouts for operations whose context
+	// has no deadline
+	Timeouts Timeouts
+
+	// HealthCheckInterval enables a background monitor that pings the endpoint
+	// at the given interval, with the result reported by IsOnline. Each
+	// check times out after HealthCheckTimeout, or the interval if zero.
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// FailoverEndpoints are the secondary endpoints, such as the replicas of
+	// an active/passive MinIO deployment, which requests fail over to in
+	// order when the active endpoint fails with a server or network error
+	// or times out. While failed over, the primary endpoint is checked
+	// every FailbackInterval (DefaultFailbackInterval if zero), and requests
+	// fail back to it once it is healthy again. All endpoints share the other
+	// options, including the credentials.
+	FailoverEndpoints []string
+	FailbackInterval  time.Duration
+
+	// ReadEndpoints are other endpoints serving the same bucket, such as the
+	// sites of a replicated MinIO deployment. Reads are routed to whichever
+	// of them and the main endpoint is healthy with the lowest moving
+	// average latency, and failed reads are sent to the next endpoint.
+	ReadEndpoints []string
+
+	// Replicas are clients for other buckets, possibly on other endpoints,
+	// that objects written with PutObject and deleted with DeleteObject
+	// are replicated to, for redundancy on providers without native
+	// replication. Keys are resolved against the options of each replica,
+	// such as its namespace, and clients derived with Bucket or Scoped do
+	// not replicate. In ReplicationAsync mode writes are recorded in the
+	// ReplicationJournal file, or only in memory if it is empty, and
+	// replicated in the background. ReplicationSync is used if no mode is set.
+	//
+	// In ReplicationSync mode puts are replicated from the uploaded data if
+	// the reader implements io.ReaderAt and io.Seeker. Otherwise the object is
+	// copied server-side to replicas on the same endpoint, or read back from
+	// the primary bucket. Objects encrypted with a customer key are read and
+	// replicated with the same key.
+	Replicas           []*S3
+	ReplicationMode    ReplicationMode
+	ReplicationJournal string
+
+	// Transport replaces the default transport used for all requests to the
+	// endpoint, for example to control proxies, TLS, tracing or connection pooling
+	Transport http.RoundTripper
+
+	// ProxyURL sends all requests through the given HTTP proxy instead of the
+	// one configured in the environment, except for hosts matching NoProxy
+	// (a comma-separated list in the same format as the NO_PROXY variable)
+	ProxyURL string
+	NoProxy  string
+
+	// CACertFile adds the PEM encoded certificates in the file to the
+	// trusted roots, and ClientCertFile and ClientKeyFile set a client
+	// certificate for mTLS. InsecureSkipVerify disables certificate
+	// verification entirely and should only be used for development.
+	CACertFile         string
+	ClientCertFile     string
+	ClientKeyFile      string
+	InsecureSkipVerify bool
+
+	// MaxIdleConns, MaxIdleConnsPerHost, MaxConnsPerHost and IdleConnTimeout
+	// tune the connection pool of the transport, zero values keep the
+	// defaults (256 idle connections, 16 per host, no connection limit and
+	// a one-minute idle timeout)
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+
+	// DialTimeout and ResponseHeaderTimeout limit how long every request
+	// waits to connect and for the response headers, zero values keep the
+	// defaults (30 seconds and one minute)
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+
+	// DualStack uses the dual-stack (IPv4 and IPv6) endpoints of AWS, it has
+	// no effect on other endpoints
+	DualStack bool
+
+	// Network restricts connections to "tcp4" or "tcp6", such as for
+	// IPv6-only clusters, both are used if empty
+	Network string
+
+	// DNSServer is the address (host:port, port 53 if omitted) of the DNS
+	// server used to resolve the endpoint instead of the system resolver,
+	// such as in split-horizon DNS environments. Resolver takes precedence
+	// over it, and DialContext replaces the dialer, resolver and DialTimeout
+	// altogether.
+	DNSServer   string
+	Resolver    *net.Resolver
+	DialContext func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	// TraceRequests records DNS, connect, TLS and time-to-first-byte timings
+	// for every request and logs them at debug level, or passes them to
+	// OnRequestTrace if it is set (which also enables tracing)
+	TraceRequests  bool
+	OnRequestTrace func(RequestTrace)
+
+	// Observers are notified about the start, retries and result of every
+	// operation, see Observer
+	Observers []Observer
+
+	// TracerProvider records an OpenTelemetry span for every operation
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider records OpenTelemetry metrics for the duration, payload
+	// size, errors and retries of every operation
+	MeterProvider metric.MeterProvider
+
+	// AuditSink receives an AuditEvent for every mutation (puts, deletes,
+	// copies, restores and bucket changes), attributed to AuditIdentity or
+	// the access key if it is empty
+	AuditSink     AuditSink
+	AuditIdentity string
+
+	// RedactKeys redacts object keys, prefixes and request paths in log
+	// messages, including the keys in logged errors, keeping only a hash or
+	// the first RedactKeyLength characters (DefaultRedactKeyLength if zero).
+	// Credentials and presigned URLs are never logged. Keys are not redacted
+	// in traces or the audit log.
+	RedactKeys      KeyRedaction
+	RedactKeyLength int
+
+	// LogLevels overrides the level that operations are logged at, by
+	// operation name (such as "GetObject"), LogLevelOff disables them
+	LogLevels map[string]LogLevel
+
+	// SlowOperationThreshold logs a warning for every operation that takes
+	// longer than the threshold, which for GetObject includes reading the body
+	SlowOperationThreshold time.Duration
+
+	// LazyGetObject defers sending GetObject requests until the body is first
+	// read, as minio-go does, in which case errors such as a missing object
+	// are only returned by Read
+	LazyGetObject bool
+
+	// KeyDelimiter joins prefixes and keys into object names, DefaultKeyDelimiter
+	// if empty. Non-recursive listings always group common prefixes by "/".
+	KeyDelimiter string
+
+	// DisablePrefixing uses keys as the full object names and ignores the
+	// prefix argument of object operations, while the prefix of listings and
+	// prefix operations is used as a raw string prefix
+	DisablePrefixing bool
+
+	// LegacyKeyJoin joins prefixes and keys as prefix, delimiter and key
+	// without removing repeated delimiters, as versions before KeyDelimiter
+	// did, so that objects stored as "p//k" or "/k" stay reachable
+	LegacyKeyJoin bool
+
+	// ValidateKeys rejects object names that fail ValidateKey with a KeyError
+	// before sending any request. SanitizeKeys cleans up keys with SanitizeKey
+	// first, and implies ValidateKeys.
+	ValidateKeys bool
+	SanitizeKeys bool
+
+	// Namespace is prepended to the names of all objects used by the client
+	// and stripped from the keys returned by ListObjects, so that several
+	// applications can share a bucket
+	Namespace string
+
+	// PublicBaseURL is the URL that PublicURL puts object names under, such
+	// as a CDN in front of the bucket. PublicURL uses the endpoint if empty.
+	PublicBaseURL string
+}
+
+// PutOptions are the per-call options for PutObjectWithOptions
+type PutOptions struct {
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+
+	// Metadata is stored with the object as user metadata
+	Metadata map[string]string
+
+	// Tags are stored with the object as object tags
+	Tags map[string]string
+
+	// StorageClass overrides Options.StorageClass for this call
+	StorageClass string
+
+	// IfMatch only writes the object if its current ETag matches the given one
+	IfMatch string
+
+	// IfNoneMatch only writes the object if its current ETag differs from the
+	// given one, "*" only writes the object if it does not exist yet
+	IfNoneMatch string
+
+	// Checksum computes a checksum of the data and has the server verify and store
+	// it with the object. Non-seekable readers are buffered in memory to compute it,
+	// and the object is always uploaded in a single request.
+	Checksum minio.ChecksumType
+
+	// Encryption is the server-side encryption to store the object with, such
+	// as a per-tenant customer key created with encrypt.NewSSEC. It overrides
+	// the default SSE-KMS configuration in Options.
+	Encryption encrypt.ServerSide
+
+	// Compression overrides Options.Compression for this call
+	Compression Compression
+
+	// TTL stores an expiry time with the object, after which it is deleted by
+	// the background reaper (see Options.ReaperInterval)
+	TTL time.Duration
+}
+
+// GetOptions are the per-call options for GetObjectWithOptions
+type GetOptions struct {
+	// IfMatch only returns the object if its ETag matches the given one
+	IfMatch string
+
+	// IfNoneMatch only returns the object if its ETag differs from the given one
+	IfNoneMatch string
+
+	// IfModifiedSince only returns the object if it was modified after the given time
+	IfModifiedSince time.Time
+
+	// VerifyChecksum verifies the body against the checksum stored with the object
+	// while it is read, returning ErrChecksumMismatch from Read on corruption and
+	// ErrChecksumUnavailable from GetObjectWithOptions if there is nothing to verify
+	VerifyChecksum bool
+
+	// Encryption is the server-side encryption the object was stored with,
+	// required to read objects encrypted with a customer key (encrypt.NewSSEC)
+	Encryption encrypt.ServerSide
+
+	// Range only returns part of the object. Ranges are read from the stored
+	// bytes, so compressed objects are returned as is and can't be verified.
+	Range *ByteRange
+
+	// VersionID reads a specific version of an object in a versioned bucket
+	// instead of the latest one
+	VersionID string
+
+	// NoCache reads the object from the bucket even if a cache is enabled,
+	// and sends the request before returning even with
+	// Options.LazyGetObject, so that the reader implements ObjectStater
+	NoCache bool
+}
+
+// PresignOptions are the per-call options for PresignedGetObjectWithOptions
+// and Presign
+type PresignOptions struct {
+	// VersionID presigns a specific version of an object in a versioned
+	// bucket instead of the latest one
+	VersionID string
+
+	// ResponseContentDisposition, ResponseContentType and
+	// ResponseCacheControl override the headers of the response to the
+	// URL, for example to set the file name of a download with
+	// `attachment; filename="name"`
+	ResponseContentDisposition string
+	ResponseContentType        string
+	ResponseCacheControl       string
+
+	// QueryParams are added to the URL and signed with it
+	QueryParams url.Values
+
+	// Headers must be sent with the same values by requests to the URL, as
+	// they are part of the signature. Encryption adds the headers of a
+	// customer key (encrypt.NewSSEC) the same way.
+	Headers    http.Header
+	Encryption encrypt.ServerSide
+}
+
+// ByteRange is an inclusive range of bytes in an object. An End below
+// zero reads to the end of the object, and a Start below zero reads the
+// last -Start bytes.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Offsets returns the first and last byte of the range in an object of the
+// given size, and false if the range is not satisfiable
+func (r ByteRange) Offsets(size int64) (int64, int64, bool) {
+	if r.Start < 0 {
+		if size == 0 {
+			return 0, 0, false
+		}
+		return max(size+r.Start, 0), size - 1, true
+	}
+	if r.Start >= size || (r.End >= 0 && r.End < r.Start) {
+		return 0, 0, false
+	}
+	if r.End < 0 || r.End >= size {
+		return r.Start, size - 1, true
+	}
+	return r.Start, r.End, true
+}
+
+// CopyOptions are the per-call options for CopyObjectWithOptions
+type CopyOptions struct {
+	// SourceEncryption is the customer key the source object was encrypted with
+	SourceEncryption encrypt.ServerSide
+
+	// SourceIfMatch only copies the source if its ETag matches the given one
+	SourceIfMatch string
+
+	// ContentType, Metadata and StorageClass replace the values of the source
+	// object in the copy, the rest of its metadata is kept
+	ContentType  string
+	Metadata     map[string]string
+	StorageClass string
+
+	// Encryption is the server-side encryption to store the copy with,
+	// overriding the default SSE-KMS configuration in Options
+	Encryption encrypt.ServerSide
 }
 
 // S3 is a wrapper for the s3 client
 type S3 struct {
-	logger  *zerolog.Logger
-	options *Options
+	logger Logger
+
+	// current are the options of the client, which Reload replaces
+	current atomic.Pointer[Options]
+	reload  sync.Mutex
+
+	conn        *connection
+	cache       Cache
+	generations *cacheGenerations
+	encryption  encrypt.ServerSide
+	makeOpts    minio.MakeBucketOptions
+	removeOpts  minio.RemoveObjectOptions
+	observers   []Observer
 
-	client     *minio.Client
-	makeOpts   minio.MakeBucketOptions
-	getOpts    minio.GetObjectOptions
-	removeOpts minio.RemoveObjectOptions
+	replication *replicator
+
+	online atomic.Bool
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
-func New(options *Options, logger *zerolog.Logger) (*S3, error) {
+func New(options *Options, logger *zerolog.Logger, opts ...Option) (*S3, error) {
 	l := logger.With().Str(options.LogName, "S3").Logger()
+	return newS3(options, NewZerologLogger(&l), opts)
+}
+
+// NewWithLogger creates a client that logs to any Logger, such as a *slog.Logger.
+// If Options.LogName is set it is added as a field to every message, and a nil
+// logger discards all messages.
+func NewWithLogger(options *Options, logger Logger, opts ...Option) (*S3, error) {
+	return newS3(options, namedLogger(logger, options.LogName), opts)
+}
+
+// NewClient creates a client for a bucket at the given endpoint, configured
+// only through options such as WithCredentials and WithRegion. Requests are
+// sent over HTTPS unless WithSecure(false) is passed, and nothing is logged
+// without WithLogger.
+func NewClient(endpoint string, bucket string, opts ...Option) (*S3, error) {
+	return newS3(&Options{
+		Endpoint: endpoint,
+		Bucket:   bucket,
+		Secure:   true,
+	}, nopLogger{}, opts)
+}
+
+func newS3(options *Options, l Logger, opts []Option) (*S3, error) {
+	if len(opts) > 0 {
+		o := *options
+		for _, opt := range opts {
+			opt(&o)
+		}
+		options = &o
+	}
+
+	options, err := withProvider(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Logger != nil {
+		l = namedLogger(options.Logger, options.LogName)
+	}
+
+	if options.RedactKeys != RedactNone {
+		length := options.RedactKeyLength
+		if length <= 0 {
+			length = DefaultRedactKeyLength
+		}
+		l = &redactingLogger{
+			logger:    l,
+			redaction: options.RedactKeys,
+			length:    length,
+		}
+	}
+
 	if options.Disabled {
-		l.Warn().Msg("disabled")
+		l.Warn("disabled")
 		return nil, ErrDisabled
 	}
 
-	l.Debug().Msgf("connecting to s3 endpoint %s with bucket '%s'", options.Endpoint, options.Bucket)
+	l.Debug("connecting to s3", "endpoint", options.Endpoint, "bucket", options.Bucket)
 
-	client, err := minio.New(options.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(options.AccessKey, options.SecretKey, ""),
-		Secure: options.Secure,
-		Region: options.Region,
-	})
+	conn, err := newConnection(options, l)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+		return nil, err
+	}
+
+	var encryption encrypt.ServerSide
+	if options.KMSKeyID != "" {
+		var kmsContext interface{}
+		if len(options.KMSContext) > 0 {
+			kmsContext = options.KMSContext
+		}
+		encryption, err = encrypt.NewSSEKMS(options.KMSKeyID, kmsContext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create sse-kms configuration: %w", err)
+		}
+	}
+
+	cache, err := newCache(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var observers []Observer
+	if options.TracerProvider != nil {
+		// The span is started first so that it is in the context of all other observers
+		observers = append(observers, newTracingObserver(options.TracerProvider))
+	}
+	if options.MeterProvider != nil {
+		meter, err := newMeterObserver(options.MeterProvider)
+		if err != nil {
+			return nil, err
+		}
+		observers = append(observers, meter)
+	}
+	if options.AuditSink != nil {
+		identity := options.AuditIdentity
+		if identity == "" {
+			identity = options.AccessKey
+		}
+		observers = append(observers, &auditObserver{
+			sink:     options.AuditSink,
+			identity: identity,
+			logger:   l,
+		})
+	}
+	if options.SlowOperationThreshold > 0 {
+		observers = append(observers, &slowObserver{
+			threshold: options.SlowOperationThreshold,
+			logger:    l,
+		})
+	}
+	observers = append(observers, options.Observers...)
+
+	// The journal is opened last so that it is only left open if the
+	// client is created
+	replication, err := newReplicator(options, l)
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	e := &S3{
-		logger:     &l,
-		options:    options,
-		client:     client,
-		makeOpts:   minio.MakeBucketOptions{},
-		getOpts:    minio.GetObjectOptions{},
-		removeOpts: minio.RemoveObjectOptions{},
-		ctx:        ctx,
-		cancel:     cancel,
+		logger:      l,
+		conn:        conn,
+		cache:       cache,
+		generations: newCacheGenerations(),
+		encryption:  encryption,
+		makeOpts:    minio.MakeBucketOptions{},
+		removeOpts:  minio.RemoveObjectOptions{},
+		observers:   observers,
+		replication: replication,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	e.current.Store(options)
+
+	if options.ReaperInterval > 0 {
+		e.wg.Add(1)
+		go e.reap()
+	}
+
+	if options.HealthCheckInterval > 0 {
+		e.online.Store(true)
+		e.wg.Add(1)
+		go e.monitor()
+	}
+
+	if len(options.FailoverEndpoints) > 0 {
+		e.wg.Add(1)
+		go e.failback()
+	}
+
+	if replication != nil {
+		e.wg.Add(1)
+		go e.replicateAsync()
 	}
 
 	return e, nil
 }
 
 func (e *S3) PresignedGetObject(ctx context.Context, prefix string, key string, expires time.Duration) (*url.URL, error) {
-	objName := prefixedKey(prefix, key)
-	e.logger.Debug().Msgf("presigning object '%s' from bucket '%s' with expiry %s", objName, e.options.Bucket, expires)
-	return e.client.PresignedGetObject(ctx, e.options.Bucket, objName, expires, nil)
+	return e.PresignedGetObjectWithOptions(ctx, prefix, key, expires, PresignOptions{})
+}
+
+func (e *S3) PresignedGetObjectWithOptions(ctx context.Context, prefix string, key string, expires time.Duration, opts PresignOptions) (*url.URL, error) {
+	return e.presign(ctx, "PresignedGetObject", http.MethodGet, prefix, key, expires, opts)
+}
+
+// PresignedHeadObject returns a URL that gets the info of an object without
+// credentials until it expires
+func (e *S3) PresignedHeadObject(ctx context.Context, prefix string, key string, expires time.Duration) (*url.URL, error) {
+	return e.presign(ctx, "PresignedHeadObject", http.MethodHead, prefix, key, expires, PresignOptions{})
+}
+
+// PresignedDeleteObject returns a URL that deletes an object without
+// credentials until it expires
+func (e *S3) PresignedDeleteObject(ctx context.Context, prefix string, key string, expires time.Duration) (*url.URL, error) {
+	return e.presign(ctx, "PresignedDeleteObject", http.MethodDelete, prefix, key, expires, PresignOptions{})
+}
+
+// Presign returns a URL for a request with any method on an object, which
+// can be made without credentials until it expires. Requests to the URL must
+// send the headers in PresignOptions.Headers with the same values.
+func (e *S3) Presign(ctx context.Context, method string, prefix string, key string, expires time.Duration, opts PresignOptions) (*url.URL, error) {
+	return e.presign(ctx, "Presign", method, prefix, key, expires, opts)
+}
+
+func (e *S3) presign(ctx context.Context, operation string, method string, prefix string, key string, expires time.Duration, opts PresignOptions) (*url.URL, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return nil, err
+	}
+	if expires <= 0 {
+		expires = e.presignExpiry()
+	}
+	e.logOperation(operation, "presigning object", "method", method, "key", objName, "bucket", e.options().Bucket, "expires", expires)
+	ctx, op := e.startOperation(ctx, operation, e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Presign)
+	defer cancel()
+	u, err := e.client().PresignHeader(ctx, method, e.options().Bucket, objName, expires, presignParams(opts), presignHeaders(opts))
+	return u, op.finish(err)
+}
+
+func (e *S3) GetObject(ctx context.Context, prefix string, key string, opts ...ObjectOption) (io.ReadCloser, error) {
+	return e.GetObjectWithOptions(ctx, prefix, key, ApplyGetOptions(GetOptions{}, opts...))
+}
+
+// GetObjectWithOptions gets an object, returning ErrNotModified if
+// a conditional read was requested and the object has not changed.
+// The request is sent before returning so that errors such as
+// ErrObjectNotFound surface immediately, unless Options.LazyGetObject is set.
+func (e *S3) GetObjectWithOptions(ctx context.Context, prefix string, key string, opts GetOptions) (io.ReadCloser, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return nil, err
+	}
+	e.logOperation("GetObject", "getting object", "key", objName, "bucket", e.options().Bucket)
+	ctx, op := e.startOperation(ctx, "GetObject", e.options().Bucket, objName)
+	if e.cache != nil && cacheable(opts) {
+		body, err := e.getCached(ctx, objName)
+		if err != nil {
+			return nil, op.finish(err)
+		}
+		return op.finishOnClose(body), nil
+	}
+
+	prime := !e.options().LazyGetObject || opts.NoCache
+	body, info, err := e.getObject(ctx, objName, opts, prime)
+	if err != nil {
+		return nil, op.finish(err)
+	}
+	if prime {
+		return &statReadCloser{
+			ReadCloser: op.finishOnClose(body),
+			info:       info,
+		}, nil
+	}
+	return op.finishOnClose(body), nil
 }
 
-func (e *S3) GetObject(ctx context.Context, prefix string, key string) (io.ReadCloser, error) {
-	objName := prefixedKey(prefix, key)
-	e.logger.Debug().Msgf("getting object '%s' from bucket '%s'", objName, e.options.Bucket)
-	return e.client.GetObject(ctx, e.options.Bucket, objName, e.getOpts)
+// ObjectStater is implemented by the readers GetObjectWithOptions returns for
+// requests that were sent before returning, such as those with
+// GetOptions.NoCache, so that the info of the object being read can be
+// taken from the same response as its data
+type ObjectStater interface {
+	Stat() (minio.ObjectInfo, error)
 }
 
-func (e *S3) PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string) (minio.UploadInfo, error) {
-	objName := prefixedKey(prefix, key)
-	e.logger.Debug().Msgf("putting object '%s' into bucket '%s'", objName, e.options.Bucket)
-	return e.client.PutObject(ctx, e.options.Bucket, objName, reader, objectSize, minio.PutObjectOptions{
+type statReadCloser struct {
+	io.ReadCloser
+	info minio.ObjectInfo
+}
+
+func (s *statReadCloser) Stat() (minio.ObjectInfo, error) {
+	return s.info, nil
+}
+
+// getObject gets an object by its full name. The request is only sent
+// immediately if prime is set or the options need the response headers,
+// otherwise the returned info is empty and the request is sent on first read.
+func (e *S3) getObject(ctx context.Context, objName string, opts GetOptions, prime bool) (io.ReadCloser, minio.ObjectInfo, error) {
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+
+	var body io.ReadCloser
+	var info minio.ObjectInfo
+	var err error
+	if e.retries() {
+		// Errors only surface once the request is sent, so it has to be
+		// primed for them to be retried
+		err = e.readRetry(ctx, func(client *minio.Client) (err error) {
+			body, info, err = e.getObjectOnce(ctx, client, objName, opts, true)
+			return err
+		})
+	} else {
+		body, info, err = e.getObjectOnce(ctx, e.client(), objName, opts, prime)
+	}
+	if err != nil {
+		cancel()
+		return nil, info, err
+	}
+
+	return &cancelReadCloser{
+		ReadCloser: body,
+		cancel:     cancel,
+	}, info, nil
+}
+
+func (e *S3) getObjectOnce(ctx context.Context, client *minio.Client, objName string, opts GetOptions, prime bool) (io.ReadCloser, minio.ObjectInfo, error) {
+	getOpts, err := getObjectOptions(opts)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+
+	if opts.Range != nil {
+		return e.getObjectRange(ctx, client, objName, opts, getOpts)
+	}
+
+	obj, err := client.GetObject(ctx, e.options().Bucket, objName, getOpts)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+
+	// Whether the object is decompressed depends only on its own metadata,
+	// so objects compressed by other clients or before Options.Compression
+	// was changed are read the same way
+	if !prime && opts.IfMatch == "" && opts.IfNoneMatch == "" && opts.IfModifiedSince.IsZero() && !opts.VerifyChecksum {
+		return &lazyDecompressReader{obj: obj}, minio.ObjectInfo{}, nil
+	}
+
+	// minio-go only sends the request on first access, so we prime it here
+	// to surface a 304 or the stored checksum before the caller starts reading
+	info, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		if ErrorResponse(err).StatusCode == http.StatusNotModified {
+			return nil, minio.ObjectInfo{}, ErrNotModified
+		}
+		return nil, minio.ObjectInfo{}, err
+	}
+
+	var body io.ReadCloser = obj
+	if opts.VerifyChecksum {
+		checksum, expected, ok := objectChecksum(info)
+		if !ok {
+			_ = obj.Close()
+			return nil, minio.ObjectInfo{}, ErrChecksumUnavailable
+		}
+		body = newChecksumReader(body, checksum, expected)
+	}
+
+	contentEncoding := info.Metadata.Get("Content-Encoding")
+	if isCompressed(contentEncoding, info.UserMetadata) {
+		body, err = decompress(body, contentEncoding)
+		if err != nil {
+			_ = obj.Close()
+			return nil, minio.ObjectInfo{}, err
+		}
+	}
+
+	return body, info, nil
+}
+
+// getObjectRange sends a ranged GetObject request right away. minio-go drops
+// the range of lazy requests once their headers have been read with Stat, so
+// these go through minio.Core instead.
+func (e *S3) getObjectRange(ctx context.Context, client *minio.Client, objName string, opts GetOptions, getOpts minio.GetObjectOptions) (io.ReadCloser, minio.ObjectInfo, error) {
+	if opts.VerifyChecksum {
+		return nil, minio.ObjectInfo{}, ErrChecksumUnavailable
+	}
+	body, info, _, err := minio.Core{Client: client}.GetObject(ctx, e.options().Bucket, objName, getOpts)
+	if err != nil {
+		if ErrorResponse(err).StatusCode == http.StatusNotModified {
+			return nil, minio.ObjectInfo{}, ErrNotModified
+		}
+		return nil, minio.ObjectInfo{}, err
+	}
+	return body, info, nil
+}
+
+func (e *S3) PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string, opts ...ObjectOption) (minio.UploadInfo, error) {
+	return e.PutObjectWithOptions(ctx, prefix, key, reader, objectSize, ApplyPutOptions(PutOptions{
 		ContentType: contentType,
+	}, opts...))
+}
+
+func (e *S3) PutObjectWithOptions(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, opts PutOptions) (minio.UploadInfo, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	e.logOperation("PutObject", "putting object", "key", objName, "bucket", e.options().Bucket)
+	ctx, op := e.startOperation(ctx, "PutObject", e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+	putOpts := e.putObjectOptions(opts)
+	putOpts.UserMetadata = mergeMetadata(nil, opts.Metadata)
+
+	compression := opts.Compression
+	if compression == "" {
+		compression = e.options().Compression
+	}
+	if compression != "" && compression != CompressionNone {
+		compressed, originalSize, err := compress(reader, objectSize, compression)
+		if err != nil {
+			return minio.UploadInfo{}, op.finish(err)
+		}
+		reader, objectSize = compressed, compressed.Size()
+		putOpts.ContentEncoding = string(compression)
+		putOpts.UserMetadata[UncompressedSizeMetadata] = strconv.FormatInt(originalSize, 10)
+	}
+
+	if opts.TTL > 0 {
+		putOpts.UserMetadata[ExpiresAtMetadata] = time.Now().Add(opts.TTL).UTC().Format(time.RFC3339)
+	}
+
+	if opts.Checksum.IsSet() {
+		var sum string
+		var err error
+		reader, objectSize, sum, err = computeChecksum(reader, objectSize, opts.Checksum)
+		if err != nil {
+			return minio.UploadInfo{}, op.finish(err)
+		}
+		putOpts.UserMetadata[opts.Checksum.Key()] = sum
+		putOpts.DisableMultipart = true
+	}
+	var src *replicationSource
+	if len(e.options().Replicas) > 0 {
+		src = newReplicationSource(reader, objectSize, putOpts)
+	}
+	info, err := e.putObject(ctx, objName, reader, objectSize, putOpts)
+	e.invalidate(ctx, objName)
+	op.BytesSent = info.Size
+	if err == nil {
+		err = e.replicated(ctx, replicationPut, prefix, key, src)
+	}
+	return info, op.finish(err)
+}
+
+// putObject uploads an object, retrying failed uploads if the reader can be rewound
+func (e *S3) putObject(ctx context.Context, objName string, reader io.Reader, objectSize int64, putOpts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	seeker, ok := reader.(io.Seeker)
+	if !ok || !e.retries() {
+		return e.client().PutObject(ctx, e.options().Bucket, objName, reader, objectSize, putOpts)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return e.client().PutObject(ctx, e.options().Bucket, objName, reader, objectSize, putOpts)
+	}
+
+	var info minio.UploadInfo
+	err = e.retry(ctx, func() (err error) {
+		if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		info, err = e.client().PutObject(ctx, e.options().Bucket, objName, reader, objectSize, putOpts)
+		return err
+	})
+	return info, err
+}
+
+// PutObjectIfAbsent puts an object only if the key does not exist yet,
+// returning ErrObjectExists otherwise.
+func (e *S3) PutObjectIfAbsent(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string) (minio.UploadInfo, error) {
+	info, err := e.PutObjectWithOptions(ctx, prefix, key, reader, objectSize, PutOptions{
+		ContentType: contentType,
+		IfNoneMatch: "*",
+	})
+	if errors.Is(err, ErrPreconditionFailed) {
+		return info, &mappedError{
+			sentinel: ErrObjectExists,
+			err:      err,
+		}
+	}
+	return info, err
+}
+
+func (e *S3) StatObject(ctx context.Context, prefix string, key string) (minio.ObjectInfo, error) {
+	return e.StatObjectWithOptions(ctx, prefix, key, GetOptions{})
+}
+
+func (e *S3) StatObjectWithOptions(ctx context.Context, prefix string, key string, opts GetOptions) (minio.ObjectInfo, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return e.statObject(ctx, objName, opts)
+}
+
+// statObject gets the info of an object by its full name
+func (e *S3) statObject(ctx context.Context, objName string, opts GetOptions) (minio.ObjectInfo, error) {
+	e.logOperation("StatObject", "getting object info", "key", objName, "bucket", e.options().Bucket)
+	ctx, op := e.startOperation(ctx, "StatObject", e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+	defer cancel()
+	statOpts, err := getObjectOptions(opts)
+	if err != nil {
+		return minio.ObjectInfo{}, op.finish(err)
+	}
+	var info minio.ObjectInfo
+	err = e.readRetry(ctx, func(client *minio.Client) (err error) {
+		info, err = client.StatObject(ctx, e.options().Bucket, objName, statOpts)
+		return err
+	})
+	return info, op.finish(err)
+}
+
+func (e *S3) DeleteObject(ctx context.Context, prefix string, key string, opts ...ObjectOption) error {
+	return e.DeleteObjectWithOptions(ctx, prefix, key, ApplyDeleteOptions(DeleteOptions{}, opts...))
+}
+
+func (e *S3) DeleteObjectWithOptions(ctx context.Context, prefix string, key string, opts DeleteOptions) error {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return err
+	}
+	e.logOperation("DeleteObject", "deleting object", "key", objName, "bucket", e.options().Bucket)
+	ctx, op := e.startOperation(ctx, "DeleteObject", e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+	defer e.invalidate(ctx, objName)
+	if opts.IfMatch != "" {
+		// Services that ignore If-Match on deletes are still covered by
+		// checking the ETag first
+		var info minio.ObjectInfo
+		err = e.retry(ctx, func() (err error) {
+			info, err = e.client().StatObject(ctx, e.options().Bucket, objName, minio.StatObjectOptions{})
+			return err
+		})
+		if err != nil {
+			return op.finish(err)
+		}
+		if !MatchETag(info.ETag, opts.IfMatch) {
+			return op.finish(ErrPreconditionFailed)
+		}
+		ctx = withRequestHeader(ctx, "If-Match", `"`+strings.Trim(opts.IfMatch, `"`)+`"`)
+	}
+	err = e.retry(ctx, func() error {
+		return e.client().RemoveObject(ctx, e.options().Bucket, objName, e.removeOpts)
+	})
+	if err == nil {
+		err = e.replicated(ctx, replicationDelete, prefix, key, nil)
+	}
+	return op.finish(err)
+}
+
+// CopyObject does a server-side copy of an object, preserving its metadata and tags
+func (e *S3) CopyObject(ctx context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string, opts ...ObjectOption) (minio.UploadInfo, error) {
+	return e.CopyObjectWithOptions(ctx, srcPrefix, srcKey, dstPrefix, dstKey, ApplyCopyOptions(CopyOptions{}, opts...))
+}
+
+func (e *S3) CopyObjectWithOptions(ctx context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string, opts CopyOptions) (minio.UploadInfo, error) {
+	srcName, err := e.objectKey(srcPrefix, srcKey)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	dstName, err := e.objectKey(dstPrefix, dstKey)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	return e.copyObject(ctx, srcName, dstName, dstPrefix, dstKey, opts)
+}
+
+// copyObject copies an object by its full name, replicating the copy as the
+// object at dstPrefix and dstKey
+func (e *S3) copyObject(ctx context.Context, srcName string, dstName string, dstPrefix string, dstKey string, opts CopyOptions) (minio.UploadInfo, error) {
+	e.logOperation("CopyObject", "copying object", "source", srcName, "key", dstName, "bucket", e.options().Bucket)
+	ctx, op := e.startOperationWith(ctx, Operation{
+		Name:   "CopyObject",
+		Bucket: e.options().Bucket,
+		Key:    dstName,
+		Source: srcName,
+	})
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+	defer e.invalidate(ctx, dstName)
+	dstOpts := minio.CopyDestOptions{
+		Bucket:     e.options().Bucket,
+		Object:     dstName,
+		Encryption: e.encryptionOrDefault(opts.Encryption),
+	}
+	srcOpts := minio.CopySrcOptions{
+		Bucket:     e.options().Bucket,
+		Object:     srcName,
+		Encryption: opts.SourceEncryption,
+		MatchETag:  opts.SourceIfMatch,
+	}
+
+	if !e.storageClasses() {
+		opts.StorageClass = ""
+	}
+	if opts.ContentType != "" || len(opts.Metadata) > 0 || opts.StorageClass != "" {
+		// S3 can only replace all metadata at once, so the metadata of the
+		// source has to be copied over explicitly
+		var src minio.ObjectInfo
+		err := e.retry(ctx, func() (err error) {
+			src, err = e.client().StatObject(ctx, e.options().Bucket, srcName, minio.StatObjectOptions{
+				ServerSideEncryption: opts.SourceEncryption,
+			})
+			return err
+		})
+		if err != nil {
+			return minio.UploadInfo{}, op.finish(err)
+		}
+		dstOpts.ReplaceMetadata = true
+		dstOpts.UserMetadata = copyMetadata(src, opts)
+	}
+
+	var info minio.UploadInfo
+	err := e.retry(ctx, func() (err error) {
+		info, err = e.client().CopyObject(ctx, dstOpts, srcOpts)
+		return err
 	})
+	if err == nil {
+		err = e.replicated(ctx, replicationPut, dstPrefix, dstKey, &replicationSource{
+			encryption: customerKey(dstOpts.Encryption),
+		})
+	}
+	return info, op.finish(err)
 }
 
-func (e *S3) DeleteObject(ctx context.Context, prefix string, key string) error {
-	objName := prefixedKey(prefix, key)
-	e.logger.Debug().Msgf("deleting object '%s' from bucket '%s'", objName, e.options.Bucket)
-	return e.client.RemoveObject(ctx, e.options.Bucket, objName, e.removeOpts)
+// MoveObject copies an object to its new key and then deletes the original.
+// If the original cannot be deleted the copy is removed again, so that a
+// failed move never leaves the object at both keys.
+func (e *S3) MoveObject(ctx context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string) (minio.UploadInfo, error) {
+	info, err := e.CopyObject(ctx, srcPrefix, srcKey, dstPrefix, dstKey)
+	if err != nil {
+		return info, fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	if err = e.DeleteObject(ctx, srcPrefix, srcKey); err != nil {
+		if rollbackErr := e.DeleteObject(ctx, dstPrefix, dstKey); rollbackErr != nil {
+			e.logger.Error("failed to roll back copy of object", "key", e.objectName(dstPrefix, dstKey), "error", rollbackErr)
+			return info, fmt.Errorf("failed to delete source object: %w (rollback failed: %v)", err, rollbackErr)
+		}
+		return info, fmt.Errorf("failed to delete source object: %w", err)
+	}
+
+	return info, nil
+}
+
+func (e *S3) RestoreObject(ctx context.Context, prefix string, key string, days int, tier minio.TierType) error {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return err
+	}
+	e.logOperation("RestoreObject", "restoring object", "key", objName, "bucket", e.options().Bucket, "days", days, "tier", tier)
+	ctx, op := e.startOperation(ctx, "RestoreObject", e.options().Bucket, objName)
+	if !e.restores() {
+		return op.finish(fmt.Errorf("%w: %s has no archive tiers", ErrNotImplemented, e.options().Provider))
+	}
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+	req := minio.RestoreRequest{}
+	req.SetDays(days)
+	req.SetGlacierJobParameters(minio.GlacierJobParameters{Tier: tier})
+	err = e.retry(ctx, func() error {
+		return e.client().RestoreObject(ctx, e.options().Bucket, objName, "", req)
+	})
+	return op.finish(err)
 }
 
 func (e *S3) MakeBucket(ctx context.Context, bucket string) error {
-	e.logger.Debug().Msgf("making bucket '%s'", bucket)
-	return e.client.MakeBucket(ctx, bucket, e.makeOpts)
+	e.logOperation("MakeBucket", "making bucket", "bucket", bucket)
+	ctx, op := e.startOperation(ctx, "MakeBucket", bucket, "")
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+	err := e.retry(ctx, func() error {
+		return e.client().MakeBucket(ctx, bucket, e.makeOpts)
+	})
+	return op.finish(err)
 }
 
 func (e *S3) ListObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
-	e.logger.Debug().Msgf("listing objects with prefix '%s' in bucket '%s'", prefix, e.options.Bucket)
-	return e.client.ListObjects(ctx, e.options.Bucket, minio.ListObjectsOptions{
-		Prefix: prefixedKey(prefix, ""),
+	e.logOperation("ListObjects", "listing objects", "prefix", prefix, "bucket", e.options().Bucket)
+	objects := e.list(ctx, minio.ListObjectsOptions{
+		Prefix: e.listPrefix(prefix),
 	})
+	if e.options().Namespace == "" {
+		return objects
+	}
+
+	out := make(chan minio.ObjectInfo, 1)
+	go func() {
+		defer close(out)
+		for object := range objects {
+			object.Key = e.relativeName(object.Key)
+			select {
+			case out <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ListObjectsRecursive lists every object under prefix, including those
+// below nested delimiters, with keys relative to the prefix so that they can
+// be passed back to the other operations together with the same prefix
+func (e *S3) ListObjectsRecursive(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	e.logOperation("ListObjects", "listing objects recursively", "prefix", prefix, "bucket", e.options().Bucket)
+	root := e.listPrefix(prefix)
+	objects := e.listRecursive(ctx, prefix)
+
+	out := make(chan minio.ObjectInfo, 1)
+	go func() {
+		defer close(out)
+		for object := range objects {
+			if object.Err == nil {
+				object.Key = e.relativeKey(root, object.Key)
+			}
+			select {
+			case out <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
 }
 
 func (e *S3) RemoveBucket(ctx context.Context, bucket string) error {
-	e.logger.Debug().Msgf("removing bucket '%s'", bucket)
-	return e.client.RemoveBucket(ctx, bucket)
+	e.logOperation("RemoveBucket", "removing bucket", "bucket", bucket)
+	ctx, op := e.startOperation(ctx, "RemoveBucket", bucket, "")
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Write)
+	defer cancel()
+	err := e.retry(ctx, func() error {
+		return e.client().RemoveBucket(ctx, bucket)
+	})
+	return op.finish(err)
 }
 
 func (e *S3) Close() error {
-	e.logger.Debug().Msg("closing s3 client")
+	e.logger.Debug("closing s3 client")
 	e.cancel()
 	defer e.wg.Wait()
 	return nil
 }
 
-func prefixedKey(prefix string, key string) string {
-	return fmt.Sprintf("%s/%s", prefix, key)
+func getObjectOptions(opts GetOptions) (minio.GetObjectOptions, error) {
+	getOpts := minio.GetObjectOptions{
+		Checksum:             opts.VerifyChecksum,
+		ServerSideEncryption: opts.Encryption,
+		VersionID:            opts.VersionID,
+	}
+	if opts.IfMatch != "" {
+		if err := getOpts.SetMatchETag(opts.IfMatch); err != nil {
+			return getOpts, err
+		}
+	}
+	if opts.Range != nil {
+		if err := setRange(&getOpts, *opts.Range); err != nil {
+			return getOpts, err
+		}
+	}
+	if opts.IfNoneMatch != "" {
+		if err := getOpts.SetMatchETagExcept(opts.IfNoneMatch); err != nil {
+			return getOpts, err
+		}
+	}
+	if !opts.IfModifiedSince.IsZero() {
+		if err := getOpts.SetModified(opts.IfModifiedSince); err != nil {
+			return getOpts, err
+		}
+	}
+	return getOpts, nil
+}
+
+func (e *S3) putObjectOptions(opts PutOptions) minio.PutObjectOptions {
+	storageClass := opts.StorageClass
+	if storageClass == "" {
+		storageClass = e.options().StorageClass
+	}
+	if !e.storageClasses() {
+		storageClass = ""
+	}
+	putOpts := minio.PutObjectOptions{
+		ContentType:        opts.ContentType,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		StorageClass:       storageClass,
+		PartSize:           e.options().PartSize,
+		NumThreads:         e.options().UploadConcurrency,
+
+		ServerSideEncryption: e.encryptionOrDefault(opts.Encryption),
+		UserTags:             opts.Tags,
+	}
+	if opts.IfMatch != "" {
+		putOpts.SetMatchETag(opts.IfMatch)
+	}
+	if opts.IfNoneMatch != "" {
+		putOpts.SetMatchETagExcept(opts.IfNoneMatch)
+	}
+	return putOpts
+}
+
+// setRange sets a ByteRange on minio options, which use different conventions
+func setRange(getOpts *minio.GetObjectOptions, r ByteRange) error {
+	switch {
+	case r.Start < 0:
+		return getOpts.SetRange(0, r.Start)
+	case r.End < 0 && r.Start == 0:
+		return nil
+	case r.End < 0:
+		return getOpts.SetRange(r.Start, 0)
+	}
+	return getOpts.SetRange(r.Start, r.End)
+}
+
+// copyMetadata returns the metadata of a copy that replaces the metadata of
+// its source, in the format of minio.CopyDestOptions.UserMetadata
+func copyMetadata(src minio.ObjectInfo, opts CopyOptions) map[string]string {
+	metadata := make(map[string]string)
+	for name, value := range src.UserMetadata {
+		metadata[name] = value
+	}
+	for _, name := range []string{"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language"} {
+		if value := src.Metadata.Get(name); value != "" {
+			metadata[name] = value
+		}
+	}
+	metadata["Content-Type"] = src.ContentType
+	if opts.ContentType != "" {
+		metadata["Content-Type"] = opts.ContentType
+	}
+	storageClass := src.StorageClass
+	if opts.StorageClass != "" {
+		storageClass = opts.StorageClass
+	}
+	if storageClass != "" {
+		metadata["X-Amz-Storage-Class"] = storageClass
+	}
+	for name, value := range opts.Metadata {
+		metadata[name] = value
+	}
+	return metadata
+}
+
+// presignParams returns the query parameters of a presigned URL
+func presignParams(opts PresignOptions) url.Values {
+	params := make(url.Values)
+	for name, values := range opts.QueryParams {
+		params[name] = append([]string(nil), values...)
+	}
+	if opts.VersionID != "" {
+		params.Set("versionId", opts.VersionID)
+	}
+	if opts.ResponseContentDisposition != "" {
+		params.Set("response-content-disposition", opts.ResponseContentDisposition)
+	}
+	if opts.ResponseContentType != "" {
+		params.Set("response-content-type", opts.ResponseContentType)
+	}
+	if opts.ResponseCacheControl != "" {
+		params.Set("response-cache-control", opts.ResponseCacheControl)
+	}
+	return params
+}
+
+// presignHeaders returns the signed headers of a presigned URL
+func presignHeaders(opts PresignOptions) http.Header {
+	headers := opts.Headers.Clone()
+	if opts.Encryption != nil {
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		opts.Encryption.Marshal(headers)
+	}
+	return headers
+}
+
+// presignExpiry returns how long presigned URLs are valid for by default
+func (e *S3) presignExpiry() time.Duration {
+	if e.options().PresignExpiry > 0 {
+		return e.options().PresignExpiry
+	}
+	return DefaultPresignExpiry
+}
+
+func (e *S3) encryptionOrDefault(encryption encrypt.ServerSide) encrypt.ServerSide {
+	if encryption != nil {
+		return encryption
+	}
+	return e.encryption
+}
+
+// objectName returns the full name of the object with the given key under
+// prefix and the namespace. The prefix is ignored if prefixing is disabled.
+func (e *S3) objectName(prefix string, key string) string {
+	if e.options().DisablePrefixing {
+		return e.join(e.options().Namespace, key)
+	}
+	if e.options().LegacyKeyJoin {
+		return e.join(e.options().Namespace, "") + prefix + e.delimiter() + key
+	}
+	return e.join(e.options().Namespace, e.join(prefix, key))
+}
+
+// listPrefix returns the full prefix of the objects under prefix, which
+// is used as is (under the namespace) for listings if prefixing is disabled
+func (e *S3) listPrefix(prefix string) string {
+	if e.options().DisablePrefixing {
+		return e.join(e.options().Namespace, prefix)
+	}
+	return e.objectName(prefix, "")
+}
+
+// relativeKey returns the key of a full object name listed under root, the
+// listPrefix of a prefix
+func (e *S3) relativeKey(root string, objName string) string {
+	if e.options().DisablePrefixing {
+		return e.relativeName(objName)
+	}
+	return strings.TrimPrefix(objName, root)
+}
+
+// relativeName strips the namespace from a full object name
+func (e *S3) relativeName(objName string) string {
+	if e.options().Namespace == "" {
+		return objName
+	}
+	return strings.TrimPrefix(objName, e.join(e.options().Namespace, ""))
+}
+
+func (e *S3) join(prefix string, key string) string {
+	return joinKey(prefix, key, e.delimiter())
+}
+
+// JoinKey returns the full object name for a key under prefix in the same way
+// as the S3 client with the default options, for use by other Storage backends
+func JoinKey(prefix string, key string) string {
+	return joinKey(prefix, key, DefaultKeyDelimiter)
+}
+
+// joinKey joins prefix and key with a single delimiter, regardless of whether
+// the prefix ends or the key starts with one. The key is returned as is if
+// the prefix is empty.
+func joinKey(prefix string, key string, delimiter string) string {
+	for strings.HasSuffix(prefix, delimiter) {
+		prefix = strings.TrimSuffix(prefix, delimiter)
+	}
+	if prefix == "" {
+		return key
+	}
+	for strings.HasPrefix(key, delimiter) {
+		key = strings.TrimPrefix(key, delimiter)
+	}
+	return prefix + delimiter + key
+}
+
+func (e *S3) delimiter() string {
+	if e.options().KeyDelimiter == "" {
+		return DefaultKeyDelimiter
+	}
+	return e.options().KeyDelimiter
 }
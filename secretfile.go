@@ -0,0 +1,138 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// secretFileCheckInterval is how often watched secret files are checked
+	// for changes
+	secretFileCheckInterval = 5 * time.Second
+)
+
+// secretFiles is a credentials.Provider that reads the access key and secret
+// key from files, such as mounted Kubernetes secrets. If watch is set, the
+// files are read again when they change.
+type secretFiles struct {
+	accessKeyFile string
+	secretKeyFile string
+	watch         bool
+
+	mu      sync.Mutex
+	value   credentials.Value
+	modTime time.Time
+	checked time.Time
+}
+
+// secretFilesProvider returns a provider for the secret files in the
+// options, or nil if none are set
+func secretFilesProvider(options *Options) (*secretFiles, error) {
+	if options.AccessKeyFile == "" && options.SecretKeyFile == "" {
+		return nil, nil
+	}
+	s := &secretFiles{
+		accessKeyFile: options.AccessKeyFile,
+		secretKeyFile: options.SecretKeyFile,
+		watch:         options.WatchSecretFiles,
+		value: credentials.Value{
+			AccessKeyID:     options.AccessKey,
+			SecretAccessKey: options.SecretKey,
+			SessionToken:    options.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		},
+	}
+	if _, err := s.Retrieve(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *secretFiles) Retrieve() (credentials.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value := s.value
+	if s.accessKeyFile != "" {
+		accessKey, err := readSecretFile(s.accessKeyFile)
+		if err != nil {
+			return credentials.Value{}, err
+		}
+		value.AccessKeyID = accessKey
+	}
+	if s.secretKeyFile != "" {
+		secretKey, err := readSecretFile(s.secretKeyFile)
+		if err != nil {
+			return credentials.Value{}, err
+		}
+		value.SecretAccessKey = secretKey
+	}
+
+	s.value = value
+	s.modTime = s.latestModTime()
+	s.checked = time.Now()
+	return value, nil
+}
+
+func (s *secretFiles) IsExpired() bool {
+	if !s.watch {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checked) < secretFileCheckInterval {
+		return false
+	}
+	s.checked = time.Now()
+	return !s.latestModTime().Equal(s.modTime)
+}
+
+// latestModTime returns the last time one of the files changed
+func (s *secretFiles) latestModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{s.accessKeyFile, s.secretKeyFile} {
+		if name == "" {
+			continue
+		}
+		// Stat follows the symlinks that Kubernetes swaps when a secret is updated
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// readSecretFile reads a secret from a file, without surrounding whitespace
+func readSecretFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
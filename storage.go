@@ -0,0 +1,49 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Storage is the object storage interface implemented by *S3, so that code
+// can depend on it and swap in fakes or other backends
+type Storage interface {
+	PresignedGetObject(ctx context.Context, prefix string, key string, expires time.Duration) (*url.URL, error)
+
+	GetObject(ctx context.Context, prefix string, key string, opts ...ObjectOption) (io.ReadCloser, error)
+	GetObjectWithOptions(ctx context.Context, prefix string, key string, opts GetOptions) (io.ReadCloser, error)
+
+	PutObject(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string, opts ...ObjectOption) (minio.UploadInfo, error)
+	PutObjectWithOptions(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, opts PutOptions) (minio.UploadInfo, error)
+	PutObjectIfAbsent(ctx context.Context, prefix string, key string, reader io.Reader, objectSize int64, contentType string) (minio.UploadInfo, error)
+
+	StatObject(ctx context.Context, prefix string, key string) (minio.ObjectInfo, error)
+	DeleteObject(ctx context.Context, prefix string, key string, opts ...ObjectOption) error
+	DeleteObjectWithOptions(ctx context.Context, prefix string, key string, opts DeleteOptions) error
+	CopyObject(ctx context.Context, srcPrefix string, srcKey string, dstPrefix string, dstKey string, opts ...ObjectOption) (minio.UploadInfo, error)
+	ListObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo
+
+	Close() error
+}
+
+var _ Storage = (*S3)(nil)
@@ -0,0 +1,64 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Timeouts are the default timeouts per class of operation, applied
+// whenever the caller's context has no deadline of its own. A zero
+// value leaves that class of operations without a timeout.
+type Timeouts struct {
+	// Read covers getting and stating objects, including reading the body
+	// returned by GetObject until it is closed
+	Read time.Duration
+
+	// Write covers uploads, copies, deletes and bucket changes
+	Write time.Duration
+
+	// List covers listings, until the returned channel is closed
+	List time.Duration
+
+	// Presign covers generating presigned URLs, which may need to look
+	// up the bucket region
+	Presign time.Duration
+}
+
+// withTimeout applies timeout to ctx unless it is zero or ctx already has a deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// cancelReadCloser cancels the context of a read once its body is closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
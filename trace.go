@@ -0,0 +1,126 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// RequestTrace is the timing of a single HTTP request to the endpoint,
+// reported once the response headers have been received. Phases that did
+// not happen, such as DNS and connecting when a pooled connection was
+// reused, are zero.
+type RequestTrace struct {
+	Method     string
+	Host       string
+	Path       string
+	StatusCode int
+	Err        error
+
+	// RequestID and HostID are the x-amz-request-id and x-amz-id-2
+	// response headers that identify the request with the provider
+	RequestID string
+	HostID    string
+
+	Reused          bool
+	DNS             time.Duration
+	Connect         time.Duration
+	TLSHandshake    time.Duration
+	TimeToFirstByte time.Duration
+	Total           time.Duration
+}
+
+// traceTransport records the timing of every request using httptrace
+type traceTransport struct {
+	next     http.RoundTripper
+	logger   Logger
+	callback func(RequestTrace)
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var dnsStart, connectStart, tlsStart time.Time
+	trace := RequestTrace{
+		Method: req.Method,
+		Host:   req.URL.Host,
+		Path:   req.URL.Path,
+	}
+
+	start := time.Now()
+	clientTrace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			trace.Reused = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			trace.DNS = time.Since(dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			trace.Connect = time.Since(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			trace.TLSHandshake = time.Since(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			trace.TimeToFirstByte = time.Since(start)
+		},
+	}
+
+	res, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), clientTrace)))
+	trace.Total = time.Since(start)
+	trace.Err = err
+	if res != nil {
+		trace.StatusCode = res.StatusCode
+		trace.RequestID = res.Header.Get("X-Amz-Request-Id")
+		trace.HostID = res.Header.Get("X-Amz-Id-2")
+	}
+
+	if t.callback != nil {
+		t.callback(trace)
+	} else {
+		fields := []any{
+			"host", trace.Host,
+			"method", trace.Method,
+			"path", trace.Path,
+			"status", trace.StatusCode,
+			"reused", trace.Reused,
+			"dns", trace.DNS,
+			"connect", trace.Connect,
+			"tls", trace.TLSHandshake,
+			"ttfb", trace.TimeToFirstByte,
+			"total", trace.Total,
+			"request_id", trace.RequestID,
+			"host_id", trace.HostID,
+		}
+		if err != nil {
+			fields = append(fields, "error", err)
+		}
+		t.logger.Debug("request timing", fields...)
+	}
+
+	return res, err
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/loopholelabs/s3"
+
+// tracingObserver records an OpenTelemetry span for every operation
+type tracingObserver struct {
+	tracer trace.Tracer
+}
+
+func newTracingObserver(provider trace.TracerProvider) *tracingObserver {
+	return &tracingObserver{
+		tracer: provider.Tracer(tracerName),
+	}
+}
+
+func (o *tracingObserver) OperationStarted(ctx context.Context, op *Operation) context.Context {
+	attributes := []attribute.KeyValue{
+		attribute.String("rpc.system", "aws-api"),
+		attribute.String("rpc.service", "S3"),
+		attribute.String("rpc.method", op.Name),
+		attribute.String("aws.s3.bucket", op.Bucket),
+	}
+	if op.Key != "" {
+		attributes = append(attributes, attribute.String("aws.s3.key", op.Key))
+	}
+	ctx, _ = o.tracer.Start(ctx, "S3."+op.Name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	return ctx
+}
+
+func (o *tracingObserver) OperationRetried(ctx context.Context, _ *Operation, attempt int, err error) {
+	trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+		attribute.Int("s3.attempt", attempt),
+		attribute.String("exception.message", err.Error()),
+	))
+}
+
+func (o *tracingObserver) OperationFinished(ctx context.Context, op *Operation, err error) {
+	span := trace.SpanFromContext(ctx)
+	if op.BytesSent > 0 {
+		span.SetAttributes(attribute.Int64("s3.bytes_sent", op.BytesSent))
+	}
+	if op.BytesReceived > 0 {
+		span.SetAttributes(attribute.Int64("s3.bytes_received", op.BytesReceived))
+	}
+	if err != nil && !errors.Is(err, ErrNotModified) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, OperationStatus(err))
+	}
+	span.End()
+}
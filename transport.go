@@ -0,0 +1,318 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrUnsupportedTransport = errors.New("transport options require the transport to be an *http.Transport")
+	ErrInvalidProxyURL      = errors.New("invalid proxy url")
+	ErrInvalidCACert        = errors.New("no valid certificates found in ca certificate file")
+	ErrClientKeyPair        = errors.New("client certificate and key must be set together")
+	ErrInvalidNetwork       = errors.New("invalid network")
+	ErrInvalidDNSServer     = errors.New("invalid dns server")
+)
+
+// newTransport builds the transport used by the minio client from the options
+func newTransport(options *Options, logger Logger) (http.RoundTripper, error) {
+	rt := options.Transport
+	if rt == nil {
+		transport, err := minio.DefaultTransport(options.Secure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transport: %w", err)
+		}
+		rt = transport
+	}
+
+	if configuresTransport(options) {
+		transport, ok := rt.(*http.Transport)
+		if !ok {
+			return nil, ErrUnsupportedTransport
+		}
+		// Never modify a transport that was passed in by the caller
+		transport = transport.Clone()
+		if err := configureTransport(transport, options); err != nil {
+			return nil, err
+		}
+		rt = transport
+	}
+
+	if options.TraceRequests || options.OnRequestTrace != nil {
+		rt = &traceTransport{
+			next:     rt,
+			logger:   logger,
+			callback: options.OnRequestTrace,
+		}
+	}
+
+	rt = &headerTransport{next: rt}
+
+	if options.RateLimit > 0 {
+		burst := options.RateBurst
+		if burst <= 0 {
+			burst = int(math.Max(1, math.Ceil(options.RateLimit)))
+		}
+		rt = &rateLimitTransport{
+			next:    rt,
+			limiter: rate.NewLimiter(rate.Limit(options.RateLimit), burst),
+		}
+	}
+
+	return rt, nil
+}
+
+// configuresTransport returns whether any of the options modify the *http.Transport
+func configuresTransport(options *Options) bool {
+	return options.ProxyURL != "" ||
+		options.CACertFile != "" ||
+		options.ClientCertFile != "" ||
+		options.ClientKeyFile != "" ||
+		options.InsecureSkipVerify ||
+		options.MaxIdleConns > 0 ||
+		options.MaxIdleConnsPerHost > 0 ||
+		options.MaxConnsPerHost > 0 ||
+		options.IdleConnTimeout > 0 ||
+		options.ResponseHeaderTimeout > 0 ||
+		configuresDialer(options)
+}
+
+// configuresDialer returns whether any of the options replace the dialer
+// of the transport
+func configuresDialer(options *Options) bool {
+	return options.DialTimeout > 0 ||
+		options.Network != "" ||
+		options.DNSServer != "" ||
+		options.Resolver != nil ||
+		options.DialContext != nil
+}
+
+// configureTransport applies the transport options to transport
+func configureTransport(transport *http.Transport, options *Options) error {
+	if options.ProxyURL != "" {
+		proxyURL, err := url.Parse(options.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("%w: %s", ErrInvalidProxyURL, options.ProxyURL)
+		}
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  proxyURL.String(),
+			HTTPSProxy: proxyURL.String(),
+			NoProxy:    options.NoProxy,
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+
+	if options.CACertFile != "" || options.ClientCertFile != "" || options.ClientKeyFile != "" || options.InsecureSkipVerify {
+		tlsConfig, err := configureTLS(transport.TLSClientConfig, options)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if options.MaxIdleConns > 0 {
+		transport.MaxIdleConns = options.MaxIdleConns
+	}
+	if options.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	}
+	if options.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = options.MaxConnsPerHost
+	}
+	if options.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = options.IdleConnTimeout
+	}
+	if configuresDialer(options) {
+		dial, err := newDialer(options)
+		if err != nil {
+			return err
+		}
+		transport.DialContext = dial
+	}
+	if options.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = options.ResponseHeaderTimeout
+	}
+
+	return nil
+}
+
+// newDialer returns the dial function for the dialer options, restricted to
+// the network in options.Network
+func newDialer(options *Options) (func(ctx context.Context, network string, addr string) (net.Conn, error), error) {
+	switch options.Network {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidNetwork, options.Network)
+	}
+
+	dial := options.DialContext
+	if dial == nil {
+		timeout := options.DialTimeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		resolver := options.Resolver
+		if resolver == nil && options.DNSServer != "" {
+			var err error
+			resolver, err = dnsResolver(options.DNSServer)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dial = (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+			Resolver:  resolver,
+		}).DialContext
+	}
+
+	if options.Network == "" || options.Network == "tcp" {
+		return dial, nil
+	}
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network = options.Network
+		}
+		return dial(ctx, network, addr)
+	}, nil
+}
+
+// dnsResolver returns a resolver that sends every query to server
+func dnsResolver(address string) (*net.Resolver, error) {
+	server := address
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || host == "" || port == "" || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDNSServer, address)
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}, nil
+}
+
+// configureTLS returns a copy of base with the TLS options applied
+func configureTLS(base *tls.Config, options *Options) (*tls.Config, error) {
+	var tlsConfig *tls.Config
+	if base != nil {
+		tlsConfig = base.Clone()
+	} else {
+		tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if options.CACertFile != "" {
+		data, err := os.ReadFile(options.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca certificate file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, ErrInvalidCACert
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (options.ClientCertFile == "") != (options.ClientKeyFile == "") {
+		return nil, ErrClientKeyPair
+	}
+	if options.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if options.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// rateLimitTransport waits for a token before every request, which includes
+// every page of a listing, every part of a multipart upload and every retry
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+type requestHeaderKey struct{}
+
+// withRequestHeader returns a context whose requests carry the given header,
+// for conditions minio-go has no option for, like If-Match on deletes. It is
+// added after signing, so it must not be an X-Amz header
+func withRequestHeader(ctx context.Context, key string, value string) context.Context {
+	header := http.Header{}
+	if existing, ok := ctx.Value(requestHeaderKey{}).(http.Header); ok {
+		header = existing.Clone()
+	}
+	header.Set(key, value)
+	return context.WithValue(ctx, requestHeaderKey{}, header)
+}
+
+// headerTransport adds the headers set with withRequestHeader to requests
+type headerTransport struct {
+	next http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, ok := req.Context().Value(requestHeaderKey{}).(http.Header)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,128 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// ExpiresAtMetadata is the user metadata key that stores the time
+	// an object expires at, formatted as RFC 3339
+	ExpiresAtMetadata = "Expires-At"
+
+	userMetadataHeaderPrefix = "X-Amz-Meta-"
+)
+
+// ReapExpired deletes every object under Options.ReaperPrefix whose
+// expiry time has passed, returning the number of deleted objects
+func (e *S3) ReapExpired(ctx context.Context) (int, error) {
+	objPrefix := e.listPrefix(e.options().ReaperPrefix)
+	e.logOperation("ReapExpired", "reaping expired objects", "prefix", objPrefix, "bucket", e.options().Bucket)
+
+	listCtx, listCancel := context.WithCancel(ctx)
+	defer listCancel()
+
+	now := time.Now()
+	deleted := 0
+	objects := e.list(listCtx, minio.ListObjectsOptions{
+		Prefix:       objPrefix,
+		Recursive:    true,
+		WithMetadata: true,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			return deleted, fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+
+		// Only MinIO returns metadata in listings, everywhere else we
+		// have to stat every object to find out when it expires
+		metadata := map[string]string(object.UserMetadata)
+		if metadata == nil {
+			var info minio.ObjectInfo
+			err := e.retry(ctx, func() (err error) {
+				info, err = e.client().StatObject(ctx, e.options().Bucket, object.Key, minio.StatObjectOptions{})
+				return err
+			})
+			if err != nil {
+				e.logger.Warn("failed to stat object while reaping", "key", object.Key, "error", err)
+				continue
+			}
+			metadata = info.UserMetadata
+		}
+
+		expiresAt, ok := objectExpiresAt(metadata)
+		if !ok || expiresAt.After(now) {
+			continue
+		}
+
+		// Deleted like any other object, so that the delete is audited and
+		// replicated
+		err := e.DeleteObjectWithOptions(ctx, e.options().ReaperPrefix, e.relativeKey(objPrefix, object.Key), DeleteOptions{})
+		if err != nil {
+			e.logger.Warn("failed to delete expired object", "key", object.Key, "error", err)
+			continue
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (e *S3) reap() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.options().ReaperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := e.ReapExpired(e.ctx)
+			if err != nil {
+				e.logger.Error("failed to reap expired objects", "error", err)
+			}
+			if deleted > 0 {
+				e.logger.Debug("reaped expired objects", "count", deleted)
+			}
+		}
+	}
+}
+
+// objectExpiresAt returns the expiry time stored in an object's user metadata,
+// which is keyed with the X-Amz-Meta- prefix in listings but not in stats
+func objectExpiresAt(metadata map[string]string) (time.Time, bool) {
+	for k, v := range metadata {
+		if len(k) > len(userMetadataHeaderPrefix) && strings.EqualFold(k[:len(userMetadataHeaderPrefix)], userMetadataHeaderPrefix) {
+			k = k[len(userMetadataHeaderPrefix):]
+		}
+		if !strings.EqualFold(k, ExpiresAtMetadata) {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return expiresAt, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,74 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/s3"
+	"github.com/loopholelabs/s3/pkg/s3test"
+)
+
+func TestReapExpired(t *testing.T) {
+	ctx := context.Background()
+	var audit bytes.Buffer
+	replica := s3test.NewServer(t)
+	client := s3test.NewServer(t, func(options *s3.Options) {
+		options.ReaperPrefix = "tmp"
+		options.Replicas = []*s3.S3{replica}
+		options.AuditSink = s3.NewJSONAuditSink(&audit)
+	})
+	data := []byte("hello world")
+	for key, ttl := range map[string]time.Duration{"expired": time.Millisecond, "live": time.Hour, "forever": 0} {
+		if _, err := client.PutObjectWithOptions(ctx, "tmp", key, bytes.NewReader(data), int64(len(data)), s3.PutOptions{TTL: ttl}); err != nil {
+			t.Fatalf("failed to put object: %v", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	audit.Reset()
+
+	deleted, err := client.ReapExpired(ctx)
+	if err != nil {
+		t.Fatalf("failed to reap expired objects: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 deleted object, got %d", deleted)
+	}
+	for _, storage := range []*s3.S3{client, replica} {
+		if _, err = storage.StatObject(ctx, "tmp", "expired"); !errors.Is(err, s3.ErrObjectNotFound) {
+			t.Fatalf("expected expired object to be deleted, got %v", err)
+		}
+		for _, key := range []string{"live", "forever"} {
+			if _, err = storage.StatObject(ctx, "tmp", key); err != nil {
+				t.Fatalf("expected %s to be kept: %v", key, err)
+			}
+		}
+	}
+
+	var event s3.AuditEvent
+	if err = json.Unmarshal(audit.Bytes(), &event); err != nil {
+		t.Fatalf("expected one audit event, got %q: %v", audit.String(), err)
+	}
+	if event.Operation != "DeleteObject" || event.Key != "tmp/expired" || event.Status != s3.OperationStatus(nil) {
+		t.Fatalf("expected the delete to be audited, got %+v", event)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// VerifyOptions are the options for VerifyPrefix
+type VerifyOptions struct {
+	Concurrency int
+
+	// Progress is called after every object has been processed, it
+	// is never called concurrently
+	Progress func(progress VerifyProgress)
+}
+
+// VerifyProgress is reported to VerifyOptions.Progress
+type VerifyProgress struct {
+	Key          string
+	Verified     int
+	Unverifiable int
+	Corrupt      int
+	Failed       int
+}
+
+// VerifySummary is the result of VerifyPrefix, with keys relative to the
+// verified prefix
+type VerifySummary struct {
+	Verified int
+
+	// Bytes is the number of bytes read
+	Bytes int64
+
+	// Unverifiable are the objects that have neither a full-object checksum
+	// nor an ETag that is the MD5 of their data, such as multipart uploads
+	// without checksums and objects encrypted with SSE-KMS, and objects
+	// encrypted with SSE-C, which can't be read without their key
+	Unverifiable []string
+
+	// Corrupt are the objects whose data does not match, their errors match
+	// ErrChecksumMismatch
+	Corrupt []ObjectFailure
+
+	// Failures are the objects that could not be read
+	Failures []ObjectFailure
+}
+
+// VerifyPrefix downloads every object under the given prefix and checks its
+// data against the strongest checksum stored with it, or its ETag if that is
+// the MD5 of the data, from up to opts.Concurrency workers at a time. The
+// stored bytes are verified, so transparently compressed objects are not
+// decompressed. It only returns an error if the prefix cannot be listed.
+func (e *S3) VerifyPrefix(ctx context.Context, prefix string, opts VerifyOptions) (*VerifySummary, error) {
+	e.logOperation("VerifyPrefix", "verifying prefix", "prefix", prefix, "bucket", e.options().Bucket, "concurrency", opts.Concurrency)
+
+	summary := new(VerifySummary)
+	var mu sync.Mutex
+	root := e.listPrefix(prefix)
+	err := e.forEachObject(ctx, prefix, opts.Concurrency, func(ctx context.Context, object minio.ObjectInfo) {
+		verified, n, err := e.verifyObject(ctx, object.Key)
+		key := e.relativeKey(root, object.Key)
+
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Bytes += n
+		switch {
+		case errors.Is(err, ErrChecksumMismatch):
+			summary.Corrupt = append(summary.Corrupt, ObjectFailure{
+				Key: key,
+				Err: err,
+			})
+		case err != nil:
+			summary.Failures = append(summary.Failures, ObjectFailure{
+				Key: key,
+				Err: err,
+			})
+		case verified:
+			summary.Verified++
+		default:
+			summary.Unverifiable = append(summary.Unverifiable, key)
+		}
+		if opts.Progress != nil {
+			opts.Progress(VerifyProgress{
+				Key:          key,
+				Verified:     summary.Verified,
+				Unverifiable: len(summary.Unverifiable),
+				Corrupt:      len(summary.Corrupt),
+				Failed:       len(summary.Failures),
+			})
+		}
+	})
+	return summary, err
+}
+
+// verifyObject reads an object by its full name and checks its data,
+// returning false if there is nothing to check it against
+func (e *S3) verifyObject(ctx context.Context, objName string) (bool, int64, error) {
+	ctx, op := e.startOperation(ctx, "GetObject", e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+	defer cancel()
+
+	var verified bool
+	var n int64
+	err := e.readRetry(ctx, func(client *minio.Client) error {
+		obj, err := client.GetObject(ctx, e.options().Bucket, objName, minio.GetObjectOptions{
+			Checksum: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer obj.Close()
+		info, err := obj.Stat()
+		if err != nil {
+			return err
+		}
+
+		hasher, expected, encode := objectVerifier(info)
+		if hasher == nil {
+			verified = false
+			return nil
+		}
+		n, err = io.Copy(hasher, obj)
+		if err != nil {
+			return err
+		}
+		if actual := encode(hasher.Sum(nil)); actual != expected {
+			return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
+		}
+		verified = true
+		return nil
+	})
+	op.BytesReceived = n
+	if customerKeyRequired(err) {
+		// Objects encrypted with a customer key can't be read without it
+		return false, n, op.finish(nil)
+	}
+	return verified, n, op.finish(err)
+}
+
+// customerKeyRequired returns whether err is the error returned for reading
+// an object encrypted with a customer key (SSE-C) without the key
+func customerKeyRequired(err error) bool {
+	resp := ErrorResponse(err)
+	return resp.StatusCode == http.StatusBadRequest && resp.Code == "InvalidRequest" && strings.Contains(resp.Message, "Server Side Encryption")
+}
+
+// objectVerifier returns a hash of the data of an object and the value it
+// must have once encoded, or nil if the object can't be verified
+func objectVerifier(info minio.ObjectInfo) (hash.Hash, string, func([]byte) string) {
+	if checksum, expected, ok := objectChecksum(info); ok {
+		return checksum.Hasher(), expected, base64.StdEncoding.EncodeToString
+	}
+
+	// The ETags of multipart uploads and of objects encrypted with a KMS or
+	// customer key are not the MD5 of their data
+	etag := strings.Trim(info.ETag, `"`)
+	encrypted := info.Metadata.Get("X-Amz-Server-Side-Encryption") == "aws:kms" || info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != ""
+	if len(etag) != md5.Size*2 || encrypted {
+		return nil, "", nil
+	}
+	return md5.New(), strings.ToLower(etag), hex.EncodeToString
+}
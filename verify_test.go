@@ -0,0 +1,62 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestObjectVerifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		info       minio.ObjectInfo
+		verifiable bool
+	}{
+		{"md5", minio.ObjectInfo{ETag: `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, Metadata: http.Header{}}, true},
+		{"multipart", minio.ObjectInfo{ETag: `"5eb63bbbe01eeed093cb22bb8f5acdc3-2"`, Metadata: http.Header{}}, false},
+		{"sse-kms", minio.ObjectInfo{ETag: `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, Metadata: http.Header{"X-Amz-Server-Side-Encryption": []string{"aws:kms"}}}, false},
+		{"sse-c", minio.ObjectInfo{ETag: `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, Metadata: http.Header{"X-Amz-Server-Side-Encryption-Customer-Algorithm": []string{"AES256"}}}, false},
+		{"checksum", minio.ObjectInfo{ETag: `"5eb63bbbe01eeed093cb22bb8f5acdc3-2"`, Metadata: http.Header{}, ChecksumCRC32C: "yZRlqg=="}, true},
+	}
+	for _, test := range tests {
+		hasher, _, _ := objectVerifier(test.info)
+		if (hasher != nil) != test.verifiable {
+			t.Errorf("expected %s to be verifiable %v", test.name, test.verifiable)
+		}
+	}
+}
+
+func TestCustomerKeyRequired(t *testing.T) {
+	ssec := minio.ErrorResponse{
+		StatusCode: http.StatusBadRequest,
+		Code:       "InvalidRequest",
+		Message:    "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.",
+	}
+	if !customerKeyRequired(&OperationError{Op: "GetObject", Err: ssec}) {
+		t.Fatal("expected SSE-C error to require a customer key")
+	}
+	if customerKeyRequired(fmt.Errorf("failed: %w", minio.ErrorResponse{StatusCode: http.StatusBadRequest, Code: "InvalidRequest", Message: "Invalid Request"})) {
+		t.Fatal("expected other invalid requests not to require a customer key")
+	}
+	if customerKeyRequired(nil) {
+		t.Fatal("expected no error not to require a customer key")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ListObjectVersions lists every version and delete marker of the objects
+// under the given prefix, recursively, with keys relative to the prefix as
+// in ListObjectsRecursive. The versions of a key are listed newest first,
+// and unversioned buckets list a single "null" version per object. Version
+// listings are not retried.
+func (e *S3) ListObjectVersions(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	e.logOperation("ListObjects", "listing object versions", "prefix", prefix, "bucket", e.options().Bucket)
+	root := e.listPrefix(prefix)
+	objects := e.list(ctx, minio.ListObjectsOptions{
+		Prefix:       root,
+		Recursive:    true,
+		WithVersions: true,
+	})
+
+	out := make(chan minio.ObjectInfo, 1)
+	go func() {
+		defer close(out)
+		for object := range objects {
+			if object.Err == nil {
+				object.Key = e.relativeKey(root, object.Key)
+			}
+			select {
+			case out <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// GetObjectTags returns the tags of an object, or of the version of it
+// selected with WithVersionID
+func (e *S3) GetObjectTags(ctx context.Context, prefix string, key string, opts ...ObjectOption) (map[string]string, error) {
+	objName, err := e.objectKey(prefix, key)
+	if err != nil {
+		return nil, err
+	}
+	getOpts := ApplyGetOptions(GetOptions{}, opts...)
+	e.logOperation("GetObjectTags", "getting object tags", "key", objName, "bucket", e.options().Bucket)
+	ctx, op := e.startOperation(ctx, "GetObjectTags", e.options().Bucket, objName)
+	ctx, cancel := withTimeout(ctx, e.options().Timeouts.Read)
+	defer cancel()
+	var tags map[string]string
+	err = e.readRetry(ctx, func(client *minio.Client) error {
+		t, err := client.GetObjectTagging(ctx, e.options().Bucket, objName, minio.GetObjectTaggingOptions{
+			VersionID: getOpts.VersionID,
+		})
+		if err != nil {
+			return err
+		}
+		tags = t.ToMap()
+		return nil
+	})
+	return tags, op.finish(err)
+}